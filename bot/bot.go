@@ -0,0 +1,209 @@
+// Package bot plays the game the way a person would, over the same websocket
+// protocol. Bots keep empty rooms lively and let the server be load tested.
+package bot
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/zachlatta/calhacks/client"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// Profile describes how well and how quickly a bot plays.
+type Profile struct {
+	Name string
+
+	// Skill is the chance that each submission is the correct solution
+	// rather than a wrong one.
+	Skill float64
+
+	// ThinkTime is roughly how long the bot takes to submit after a
+	// challenge is set, and RetryTime how long it takes to try again after
+	// failing. Each delay is randomized between half and one and a half
+	// times these.
+	ThinkTime time.Duration
+	RetryTime time.Duration
+
+	// MaxAttempts is how many times the bot submits per challenge before
+	// giving up. Zero means it never gives up.
+	MaxAttempts int
+}
+
+var Profiles = map[string]Profile{
+	"novice": {
+		Name:        "novice",
+		Skill:       0.3,
+		ThinkTime:   90 * time.Second,
+		RetryTime:   30 * time.Second,
+		MaxAttempts: 5,
+	},
+	"average": {
+		Name:        "average",
+		Skill:       0.6,
+		ThinkTime:   45 * time.Second,
+		RetryTime:   15 * time.Second,
+		MaxAttempts: 8,
+	},
+	"expert": {
+		Name:        "expert",
+		Skill:       0.9,
+		ThinkTime:   15 * time.Second,
+		RetryTime:   5 * time.Second,
+		MaxAttempts: 10,
+	},
+	// hammer submits as fast as the server allows, for load testing.
+	"hammer": {
+		Name:      "hammer",
+		Skill:     0.5,
+		ThinkTime: 0,
+		RetryTime: 5 * time.Second,
+	},
+}
+
+// wrongSolutions are submitted when a bot gets a challenge wrong on purpose
+// or has no solution for it. There's one for each language the sandbox can
+// run. Solutions in any other language are gotten wrong in the bot's Lang.
+var wrongSolutions = map[string][]byte{
+	"ruby": []byte(`puts "wrong answer"`),
+}
+
+// Bot is a single player.
+type Bot struct {
+	Profile   Profile
+	Solutions Solutions
+	Stats     *Stats
+
+	// Lang is the language submitted when there's no solution for the
+	// current challenge, or no wrong one in the solution's language. It
+	// must have one in wrongSolutions.
+	Lang string
+
+	rand *rand.Rand
+}
+
+func New(p Profile, solutions Solutions, stats *Stats) *Bot {
+	return &Bot{
+		Profile:   p,
+		Solutions: solutions,
+		Stats:     stats,
+		Lang:      "ruby",
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Play plays the game over conn until it's closed or stop is closed.
+func (b *Bot) Play(conn *client.Conn, stop <-chan struct{}) {
+	var (
+		challenge *model.Challenge
+		attempts  int
+		lastSeq   int64
+		pending   = make(map[string]time.Time)
+		submit    <-chan time.Time
+	)
+	schedule := func(d time.Duration) {
+		submit = time.After(d/2 + time.Duration(b.rand.Int63n(int64(d)+1)))
+	}
+	start := func(c *model.Challenge) {
+		challenge, attempts = c, 0
+		schedule(b.Profile.ThinkTime)
+	}
+	retry := func() {
+		if challenge == nil {
+			return
+		}
+		if b.Profile.MaxAttempts > 0 && attempts >= b.Profile.MaxAttempts {
+			challenge, submit = nil, nil
+			return
+		}
+		schedule(b.Profile.RetryTime)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-submit:
+			submit = nil
+			if challenge == nil {
+				continue
+			}
+			attempts++
+			lang, code := b.solution(challenge.ID)
+			requestID, err := conn.Submit(lang, code)
+			if err != nil {
+				return
+			}
+			pending[requestID] = time.Now()
+			b.Stats.addSubmitted()
+		case evt, ok := <-conn.Events():
+			if !ok {
+				return
+			}
+			b.Stats.addReceived()
+
+			if evt.Seq > 0 {
+				switch {
+				case lastSeq > 0 && evt.Seq > lastSeq+1:
+					b.Stats.addDropped(evt.Seq - lastSeq - 1)
+				case evt.Seq <= lastSeq:
+					b.Stats.addDuplicated()
+				}
+				if evt.Seq > lastSeq {
+					lastSeq = evt.Seq
+				}
+			}
+
+			switch body := evt.Body.(type) {
+			case *protocol.SessionStartedEvent:
+				if !body.Resumed {
+					lastSeq = 0
+				}
+			case *protocol.InitialStateEvent:
+				if body.CurrentChallenge != nil {
+					start(body.CurrentChallenge)
+				}
+			case *protocol.ChallengeSetEvent:
+				start(body.Challenge)
+			case *protocol.CodeRanEvent:
+				if sent, ok := pending[evt.RequestID]; ok {
+					b.Stats.addRun(time.Since(sent), body.Passed)
+					delete(pending, evt.RequestID)
+				}
+				if body.Passed {
+					challenge, submit = nil, nil
+				} else {
+					retry()
+				}
+			case *protocol.ErrorEvent:
+				b.Stats.addError(body.Code)
+				delete(pending, evt.RequestID)
+				retry()
+			}
+
+			switch evt.Type {
+			case protocol.Ack:
+				if sent, ok := pending[evt.RequestID]; ok {
+					b.Stats.addAck(time.Since(sent))
+				}
+			case protocol.TimerFinished, protocol.BreakStarted,
+				protocol.NoChallenges:
+				challenge, submit = nil, nil
+			}
+		}
+	}
+}
+
+// solution returns what to submit for the challenge with the given ID,
+// which is right as often as the bot's skill says.
+func (b *Bot) solution(challengeID int64) (lang string, code []byte) {
+	s, ok := b.Solutions[challengeID]
+	if ok && b.rand.Float64() < b.Profile.Skill {
+		return s.Lang, s.Code
+	}
+	if wrong, ok := wrongSolutions[s.Lang]; ok {
+		return s.Lang, wrong
+	}
+	return b.Lang, wrongSolutions[b.Lang]
+}
@@ -0,0 +1,47 @@
+package bot
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zachlatta/calhacks/bundle"
+)
+
+type Solution struct {
+	Lang string
+	Code []byte
+}
+
+// Solutions maps challenge IDs to their correct solutions.
+type Solutions map[int64]Solution
+
+// LoadSolutions reads the solutions in dir. Each file is named after the ID
+// of the challenge it solves, with an extension for its language, like
+// 12.rb. Files that don't follow this pattern are skipped.
+func LoadSolutions(dir string) (Solutions, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	solutions := make(Solutions)
+	for _, f := range files {
+		name := f.Name()
+		lang := bundle.LangOf(name)
+		if f.IsDir() || lang == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name,
+			filepath.Ext(name)), 10, 64)
+		if err != nil {
+			continue
+		}
+		code, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		solutions[id] = Solution{Lang: lang, Code: code}
+	}
+	return solutions, nil
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats collects what a group of bots saw. It's safe to share between bots.
+type Stats struct {
+	mu sync.Mutex
+
+	connected       int64
+	connectFailures int64
+	disconnected    int64
+	received        int64
+	dropped         int64
+	duplicated      int64
+	submitted       int64
+	passed          int64
+	failed          int64
+	errors          map[string]int64
+
+	// ackLatencies are the times between submitting and the server
+	// acknowledging the submission, and runLatencies the times between
+	// submitting and getting the result.
+	ackLatencies []time.Duration
+	runLatencies []time.Duration
+}
+
+func NewStats() *Stats {
+	return &Stats{errors: make(map[string]int64)}
+}
+
+func (s *Stats) Connected() {
+	s.mu.Lock()
+	s.connected++
+	s.mu.Unlock()
+}
+
+func (s *Stats) ConnectFailed() {
+	s.mu.Lock()
+	s.connectFailures++
+	s.mu.Unlock()
+}
+
+func (s *Stats) Disconnected() {
+	s.mu.Lock()
+	s.disconnected++
+	s.mu.Unlock()
+}
+
+func (s *Stats) addReceived() {
+	s.mu.Lock()
+	s.received++
+	s.mu.Unlock()
+}
+
+func (s *Stats) addDropped(n int64) {
+	s.mu.Lock()
+	s.dropped += n
+	s.mu.Unlock()
+}
+
+func (s *Stats) addDuplicated() {
+	s.mu.Lock()
+	s.duplicated++
+	s.mu.Unlock()
+}
+
+func (s *Stats) addSubmitted() {
+	s.mu.Lock()
+	s.submitted++
+	s.mu.Unlock()
+}
+
+func (s *Stats) addAck(latency time.Duration) {
+	s.mu.Lock()
+	s.ackLatencies = append(s.ackLatencies, latency)
+	s.mu.Unlock()
+}
+
+func (s *Stats) addRun(latency time.Duration, passed bool) {
+	s.mu.Lock()
+	s.runLatencies = append(s.runLatencies, latency)
+	if passed {
+		s.passed++
+	}
+	s.mu.Unlock()
+}
+
+func (s *Stats) addError(code string) {
+	s.mu.Lock()
+	s.failed++
+	s.errors[code]++
+	s.mu.Unlock()
+}
+
+// String summarizes the stats collected so far.
+func (s *Stats) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "connected %d (failed %d, disconnected %d)\n",
+		s.connected, s.connectFailures, s.disconnected)
+	fmt.Fprintf(&buf, "messages received %d, dropped %d, duplicated %d\n",
+		s.received, s.dropped, s.duplicated)
+	fmt.Fprintf(&buf, "submissions %d, passed %d, errors %d\n",
+		s.submitted, s.passed, s.failed)
+	codes := make([]string, 0, len(s.errors))
+	for code := range s.errors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&buf, "    %s: %d\n", code, s.errors[code])
+	}
+	fmt.Fprintf(&buf, "ack latency %s\n", percentiles(s.ackLatencies))
+	fmt.Fprintf(&buf, "run latency %s\n", percentiles(s.runLatencies))
+	return buf.String()
+}
+
+func percentiles(ds []time.Duration) string {
+	if len(ds) == 0 {
+		return "n/a"
+	}
+	sorted := make([]time.Duration, len(ds))
+	copy(sorted, ds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return fmt.Sprintf("p50 %s, p90 %s, p99 %s, max %s", at(0.5), at(0.9),
+		at(0.99), sorted[len(sorted)-1])
+}
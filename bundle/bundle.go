@@ -0,0 +1,220 @@
+// Package bundle reads and writes challenges as packages of plain files, so
+// problem sets can be kept in version control and moved between servers.
+//
+// A package is a directory, or a zip of one, laid out like this:
+//
+//	challenge.yml          title, seconds and time_limit (in milliseconds),
+//	                       and optionally difficulty, comma separated tags,
+//	                       and the author, source and source_url it's from
+//	statement.md           the description shown to players
+//	tests/sample/NAME.in   sample test cases, with the expected output
+//	tests/sample/NAME.out  in a file with the same name
+//	tests/hidden/NAME.in   hidden test cases, laid out the same way
+//	tests/hidden/NAME.out
+//	solutions/correct/*    solutions that must pass every test case
+//	solutions/wrong/*      solutions that must fail at least one
+//	checker.EXT            optional, see model.Checker
+//
+// Test cases are ordered by name. The language of solutions and the checker
+// is guessed from their extension.
+//
+// Kattis problem packages can be read too, see DecodeKattis.
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// Files are the contents of a package's files, by their slash separated path
+// within it.
+type Files map[string][]byte
+
+// ReadDir reads every file in dir, skipping hidden directories like .git.
+func ReadDir(dir string) (Files, error) {
+	files := make(Files)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() == ".DS_Store" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)], err = ioutil.ReadFile(p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// MaxZipSize is the most a zipped package's files can add up to once they're
+// uncompressed, so a small zip can't take up all of a server's memory.
+const MaxZipSize = 64 << 20
+
+// ReadZip reads every file in the zip archive r. If they're all in one
+// directory, like when a package's directory was zipped, the directory is
+// left out of their paths. Packages bigger than MaxZipSize uncompressed are
+// rejected.
+func ReadZip(r io.ReaderAt, size int64) (Files, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(Files)
+	var total int64
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || skipped(f.Name) {
+			continue
+		}
+		name := path.Clean(f.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s is outside of the package", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(rc, MaxZipSize-total+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		total += int64(len(data))
+		if total > MaxZipSize {
+			return nil, fmt.Errorf("the package is over %d MB uncompressed",
+				MaxZipSize>>20)
+		}
+		files[name] = data
+	}
+	return files.stripDir(), nil
+}
+
+// Read reads the package at p, which is either a directory or a zip file.
+func Read(p string) (Files, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return ReadDir(p)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadZip(f, info.Size())
+}
+
+// skipped reports whether the file at p in a zip should be left out because
+// it's in a hidden directory, or was added by the OS that made the zip.
+func skipped(p string) bool {
+	parts := strings.Split(p, "/")
+	for _, dir := range parts[:len(parts)-1] {
+		if strings.HasPrefix(dir, ".") || dir == "__MACOSX" {
+			return true
+		}
+	}
+	return parts[len(parts)-1] == ".DS_Store"
+}
+
+// stripDir removes the top level directory from the paths of files if
+// they're all in the same one.
+func (files Files) stripDir() Files {
+	var dir string
+	for p := range files {
+		i := strings.Index(p, "/")
+		if i < 0 || (dir != "" && p[:i] != dir) {
+			return files
+		}
+		dir = p[:i]
+	}
+	stripped := make(Files)
+	for p, data := range files {
+		stripped[strings.TrimPrefix(p, dir+"/")] = data
+	}
+	return stripped
+}
+
+// WriteDir writes files into dir, creating it if it doesn't exist.
+func (files Files) WriteDir(dir string) error {
+	for p, data := range files {
+		name := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteZip writes files to w as a zip archive.
+func (files Files) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, p := range files.paths("") {
+		f, err := zw.Create(p)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(files[p]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Zip returns files as a zip archive.
+func (files Files) Zip() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := files.WriteZip(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// paths returns the sorted paths of the files in dir, including the files in
+// its subdirectories.
+func (files Files) paths(dir string) []string {
+	var paths []string
+	for p := range files {
+		if dir == "" || strings.HasPrefix(p, dir+"/") {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Decode reads a challenge from files, which may be in this package's format
+// or a Kattis problem package.
+func Decode(files Files) (*model.Challenge, error) {
+	if _, ok := files[kattisMetadata]; ok {
+		return DecodeKattis(files)
+	}
+	return decode(files)
+}
@@ -0,0 +1,259 @@
+package bundle
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kylelemons/go-gypsy/yaml"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const (
+	metadataFile  = "challenge.yml"
+	statementFile = "statement.md"
+	checkerName   = "checker"
+
+	sampleDir  = "tests/sample"
+	hiddenDir  = "tests/hidden"
+	correctDir = "solutions/correct"
+	wrongDir   = "solutions/wrong"
+
+	inExt  = ".in"
+	outExt = ".out"
+)
+
+func decode(files Files) (*model.Challenge, error) {
+	meta, err := readYAML(files, metadataFile)
+	if err != nil {
+		return nil, err
+	}
+	c := &model.Challenge{}
+	if c.Title, err = getString(meta, "title"); err != nil {
+		return nil, err
+	}
+	if c.Seconds, err = getInt(meta, "seconds"); err != nil {
+		return nil, err
+	}
+	if c.TimeLimit, err = getInt(meta, "time_limit"); err != nil {
+		return nil, err
+	}
+	if c.Difficulty, err = getOptional(meta, "difficulty"); err != nil {
+		return nil, err
+	}
+	tags, err := getOptional(meta, "tags")
+	if err != nil {
+		return nil, err
+	}
+	c.Tags = splitList(tags)
+	if c.Attribution, err = getAttribution(meta); err != nil {
+		return nil, err
+	}
+	c.Description = strings.TrimSpace(string(files[statementFile]))
+
+	samples, err := readTests(files, sampleDir, outExt, true)
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := readTests(files, hiddenDir, outExt, false)
+	if err != nil {
+		return nil, err
+	}
+	c.TestCases = append(samples, hidden...)
+
+	correct, err := readSolutions(files, correctDir, true)
+	if err != nil {
+		return nil, err
+	}
+	wrong, err := readSolutions(files, wrongDir, false)
+	if err != nil {
+		return nil, err
+	}
+	c.Solutions = append(correct, wrong...)
+
+	for _, p := range files.paths("") {
+		if path.Dir(p) != "." ||
+			strings.TrimSuffix(p, path.Ext(p)) != checkerName {
+			continue
+		}
+		lang := LangOf(p)
+		if lang == "" {
+			return nil, fmt.Errorf("can't tell what language %s is written in",
+				p)
+		}
+		c.Checker = &model.Checker{Lang: lang, Code: string(files[p])}
+	}
+	return c, nil
+}
+
+// Encode returns c as a package. Every test case and solution is included,
+// so the package must only be given to people who can edit c.
+func Encode(c *model.Challenge) Files {
+	files := make(Files)
+	meta := yaml.Map{
+		"title":      yaml.Scalar(strconv.Quote(c.Title)),
+		"seconds":    yaml.Scalar(strconv.Itoa(c.Seconds)),
+		"time_limit": yaml.Scalar(strconv.Itoa(c.TimeLimit)),
+	}
+	if c.Difficulty != "" {
+		meta["difficulty"] = yaml.Scalar(c.Difficulty)
+	}
+	if len(c.Tags) > 0 {
+		meta["tags"] = yaml.Scalar(strings.Join(c.Tags, ", "))
+	}
+	if a := c.Attribution; a != nil {
+		meta["author"] = yaml.Scalar(strconv.Quote(a.Author))
+		meta["source"] = yaml.Scalar(strconv.Quote(a.Source))
+		meta["source_url"] = yaml.Scalar(strconv.Quote(a.URL))
+	}
+	files[metadataFile] = []byte(yaml.Render(meta))
+	files[statementFile] = []byte(c.Description + "\n")
+
+	writeTests(files, sampleDir, c.Samples())
+	writeTests(files, hiddenDir, c.HiddenTestCases())
+
+	for i, s := range c.Solutions {
+		dir := wrongDir
+		if s.Correct {
+			dir = correctDir
+		}
+		name := fmt.Sprintf("%s/%d%s", dir, i+1, ExtOf(s.Lang))
+		files[name] = []byte(s.Code)
+	}
+
+	if c.Checker != nil {
+		files[checkerName+ExtOf(c.Checker.Lang)] = []byte(c.Checker.Code)
+	}
+	return files
+}
+
+func writeTests(files Files, dir string, tcs []model.TestCase) {
+	width := len(strconv.Itoa(len(tcs)))
+	for i, tc := range tcs {
+		name := fmt.Sprintf("%s/%0*d", dir, width, i+1)
+		files[name+inExt] = []byte(tc.Input)
+		files[name+outExt] = []byte(tc.ExpectedOutput)
+	}
+}
+
+// readTests reads the test cases in dir and its subdirectories. Each input
+// file ends in .in, and the expected output is in a file with the same name
+// ending in answerExt.
+func readTests(files Files, dir, answerExt string,
+	sample bool) ([]model.TestCase, error) {
+	var tcs []model.TestCase
+	for _, p := range files.paths(dir) {
+		if path.Ext(p) != inExt {
+			continue
+		}
+		outPath := strings.TrimSuffix(p, inExt) + answerExt
+		out, ok := files[outPath]
+		if !ok {
+			return nil, fmt.Errorf("%s has no expected output in %s", p,
+				outPath)
+		}
+		tcs = append(tcs, model.TestCase{
+			Input:          string(files[p]),
+			ExpectedOutput: string(out),
+			Sample:         sample,
+		})
+	}
+	return tcs, nil
+}
+
+func readSolutions(files Files, dir string,
+	correct bool) ([]model.Solution, error) {
+	var solutions []model.Solution
+	for _, p := range files.paths(dir) {
+		lang := LangOf(p)
+		if lang == "" {
+			return nil, fmt.Errorf("can't tell what language %s is written in",
+				p)
+		}
+		solutions = append(solutions, model.Solution{
+			Lang:    lang,
+			Code:    string(files[p]),
+			Correct: correct,
+		})
+	}
+	return solutions, nil
+}
+
+func readYAML(files Files, name string) (*yaml.File, error) {
+	data, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing", name)
+	}
+	root, err := yaml.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &yaml.File{Root: root}, nil
+}
+
+// getString returns the string at key in f, unquoting it if it's quoted.
+func getString(f *yaml.File, key string) (string, error) {
+	s, err := f.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", key, err)
+	}
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		if s, err = strconv.Unquote(s); err != nil {
+			return "", fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return s, nil
+}
+
+// getOptional returns the string at key in f, or an empty string if it isn't
+// there.
+func getOptional(f *yaml.File, key string) (string, error) {
+	if _, err := f.Get(key); err != nil {
+		if _, ok := err.(*yaml.NodeNotFound); ok {
+			return "", nil
+		}
+	}
+	return getString(f, key)
+}
+
+// getAttribution returns the author, source and source_url in f, or nil if
+// none of them are there.
+func getAttribution(f *yaml.File) (*model.Attribution, error) {
+	a := &model.Attribution{}
+	var err error
+	if a.Author, err = getOptional(f, "author"); err != nil {
+		return nil, err
+	}
+	if a.Source, err = getOptional(f, "source"); err != nil {
+		return nil, err
+	}
+	if a.URL, err = getOptional(f, "source_url"); err != nil {
+		return nil, err
+	}
+	if *a == (model.Attribution{}) {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// splitList splits s at commas and whitespace.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// getInt returns the integer at key in f, or zero if it isn't there.
+func getInt(f *yaml.File, key string) (int, error) {
+	n, err := f.GetInt(key)
+	if _, ok := err.(*yaml.NodeNotFound); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("%s: %v", key, err)
+	}
+	return int(n), nil
+}
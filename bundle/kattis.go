@@ -0,0 +1,137 @@
+package bundle
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// Files in Kattis problem packages, described at
+// http://www.problemarchive.org/wiki/index.php/Problem_Format.
+const (
+	kattisMetadata  = "problem.yaml"
+	kattisTimeLimit = ".timelimit"
+	kattisSampleDir = "data/sample"
+	kattisSecretDir = "data/secret"
+	kattisAnsExt    = ".ans"
+)
+
+// kattisSeconds is how long rounds of challenges imported from Kattis
+// packages last, since the packages don't say.
+const kattisSeconds = 300
+
+// kattisStatements are where statements can be in a Kattis package, in the
+// order they're looked for.
+var kattisStatements = []string{
+	"problem_statement/problem.en.md",
+	"problem_statement/problem.md",
+	"statement/problem.en.md",
+	"problem_statement/problem.en.tex",
+	"problem_statement/problem.tex",
+	"statement/problem.en.tex",
+}
+
+// kattisSubmissions maps the directories of a Kattis package's submissions
+// to whether the submissions in them are correct.
+var kattisSubmissions = map[string]bool{
+	"submissions/accepted":            true,
+	"submissions/wrong_answer":        false,
+	"submissions/time_limit_exceeded": false,
+	"submissions/run_time_error":      false,
+}
+
+var (
+	// nameRe matches a name on one line of problem.yaml, which the YAML
+	// parser would misread if it has a colon in it.
+	nameRe        = regexp.MustCompile(`(?m)^name:[ \t]*(\S.*)$`)
+	problemNameRe = regexp.MustCompile(`\\problemname\{([^}]*)\}`)
+)
+
+// DecodeKattis reads a challenge from a Kattis problem package. Rounds of it
+// last five minutes, and its keywords become its tags. Submissions in
+// languages that can't be run are left out, and packages that need a custom
+// output validator or options for the default one can't be read.
+func DecodeKattis(files Files) (*model.Challenge, error) {
+	meta, err := readYAML(files, kattisMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if v, err := getString(meta, "validation"); err == nil && v != "default" {
+		return nil, fmt.Errorf("validation %q isn't supported, only default",
+			v)
+	}
+
+	c := &model.Challenge{Seconds: kattisSeconds}
+	for _, p := range kattisStatements {
+		if s, ok := files[p]; ok {
+			c.Description = strings.TrimSpace(string(s))
+			break
+		}
+	}
+	if m := nameRe.FindSubmatch(files[kattisMetadata]); m != nil {
+		c.Title = strings.Trim(strings.TrimSpace(string(m[1])), `"'`)
+	} else {
+		c.Title, _ = getString(meta, "name.en")
+	}
+	if c.Title == "" {
+		m := problemNameRe.FindStringSubmatch(c.Description)
+		if m == nil {
+			return nil, fmt.Errorf("%s has no name", kattisMetadata)
+		}
+		c.Title = strings.TrimSpace(m[1])
+	}
+
+	if c.TimeLimit, err = kattisTimeLimitOf(files); err != nil {
+		return nil, err
+	}
+	keywords, err := getOptional(meta, "keywords")
+	if err != nil {
+		return nil, err
+	}
+	c.Tags = splitList(keywords)
+	if c.Attribution, err = getAttribution(meta); err != nil {
+		return nil, err
+	}
+
+	samples, err := readTests(files, kattisSampleDir, kattisAnsExt, true)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := readTests(files, kattisSecretDir, kattisAnsExt, false)
+	if err != nil {
+		return nil, err
+	}
+	c.TestCases = append(samples, secret...)
+
+	for _, p := range files.paths("submissions") {
+		correct, ok := kattisSubmissions[path.Dir(p)]
+		lang := LangOf(p)
+		if !ok || lang == "" {
+			continue
+		}
+		c.Solutions = append(c.Solutions, model.Solution{
+			Lang:    lang,
+			Code:    string(files[p]),
+			Correct: correct,
+		})
+	}
+	return c, nil
+}
+
+// kattisTimeLimitOf returns the time limit in a Kattis package's .timelimit
+// file, in milliseconds, or zero if there isn't one.
+func kattisTimeLimitOf(files Files) (int, error) {
+	s, ok := files[kattisTimeLimit]
+	if !ok {
+		return 0, nil
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(string(s)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", kattisTimeLimit, err)
+	}
+	return int(secs * 1000), nil
+}
@@ -0,0 +1,29 @@
+package bundle
+
+import "path/filepath"
+
+// exts maps languages to the extension their files have.
+var exts = map[string]string{
+	"ruby": ".rb",
+}
+
+// LangOf guesses the language of the file at path from its extension. It
+// returns an empty string if it can't tell.
+func LangOf(path string) string {
+	ext := filepath.Ext(path)
+	for lang, e := range exts {
+		if e == ext {
+			return lang
+		}
+	}
+	return ""
+}
+
+// ExtOf returns the extension files in lang have, or ".txt" if it doesn't
+// know.
+func ExtOf(lang string) string {
+	if ext, ok := exts[lang]; ok {
+		return ext
+	}
+	return ".txt"
+}
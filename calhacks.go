@@ -2,4 +2,7 @@ package calhacks
 
 import "github.com/zachlatta/calhacks/game"
 
-var Game = game.NewGame()
+var Lobby = game.NewLobby()
+
+// Game is the main room.
+var Game = Lobby.Main()
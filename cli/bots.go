@@ -0,0 +1,152 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zachlatta/calhacks/bot"
+	"github.com/zachlatta/calhacks/client"
+)
+
+const botsUsage = `usage: %s bots [flags]
+
+Fills a server with bots and reports what they saw. Each bot logs in with
+one of the tokens in the file given by -tokens, which can be made for a
+server's bots by running "calhacks bot-tokens" with its configuration.
+
+Flags:
+`
+
+// Bots runs the bots command with args, the arguments after its name.
+func Bots(args []string) {
+	fs := flag.NewFlagSet("bots", flag.ExitOnError)
+	server := fs.String("server", envOr("CALHACKS_SERVER",
+		"http://localhost:3000"), "URL of the server")
+	tokens := fs.String("tokens", "",
+		"file of tokens to log the bots in with, one per line and bot")
+	profile := fs.String("profile", "mix",
+		"how the bots play: novice, average, expert, hammer, or mix")
+	solutions := fs.String("solutions", "",
+		"directory of solutions named after their challenge's ID, like 12.rb")
+	ramp := fs.Duration("ramp", 10*time.Millisecond,
+		"time between connecting each bot")
+	duration := fs.Duration("duration", 0,
+		"how long to play for, or until interrupted if zero")
+	report := fs.Duration("report", 10*time.Second,
+		"how often to print stats")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, botsUsage, prog())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *tokens == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if _, ok := bot.Profiles[*profile]; !ok && *profile != "mix" {
+		log.Fatalf("unknown profile %q", *profile)
+	}
+	sols := bot.Solutions{}
+	if *solutions != "" {
+		var err error
+		sols, err = bot.LoadSolutions(*solutions)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	toks, err := readTokens(*tokens)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stats := bot.NewStats()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	go func() {
+		for _, tok := range toks {
+			b := bot.New(pickProfile(*profile), sols, stats)
+
+			wg.Add(1)
+			go func(tok string) {
+				defer wg.Done()
+				conn, err := client.New(*server, tok).Connect()
+				if err != nil {
+					stats.ConnectFailed()
+					return
+				}
+				stats.Connected()
+				b.Play(conn, stop)
+				conn.Close()
+				stats.Disconnected()
+			}(tok)
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(*ramp):
+			}
+		}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	var done <-chan time.Time
+	if *duration > 0 {
+		done = time.After(*duration)
+	}
+	tick := time.Tick(*report)
+loop:
+	for {
+		select {
+		case <-tick:
+			fmt.Println(stats)
+		case <-interrupt:
+			break loop
+		case <-done:
+			break loop
+		}
+	}
+	close(stop)
+	wg.Wait()
+	fmt.Println(stats)
+}
+
+func pickProfile(name string) bot.Profile {
+	if name != "mix" {
+		return bot.Profiles[name]
+	}
+	mix := []string{"novice", "average", "average", "expert"}
+	return bot.Profiles[mix[rand.Intn(len(mix))]]
+}
+
+// readTokens returns the tokens in the file at path, skipping blank lines.
+func readTokens(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var toks []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if tok := strings.TrimSpace(s.Text()); tok != "" {
+			toks = append(toks, tok)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("%s has no tokens in it", path)
+	}
+	return toks, nil
+}
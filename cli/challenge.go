@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zachlatta/calhacks/bundle"
+	"github.com/zachlatta/calhacks/client"
+)
+
+const challengeUsage = `usage: %[1]s challenge import [flags] path
+       %[1]s challenge export [flags] id path
+
+Import creates a challenge from the package at path, a directory or zip file
+in the calhacks or Kattis format. Export saves a challenge you can edit as a
+package at path, zipped if path ends in .zip.
+
+Flags:
+`
+
+// Challenge runs the challenge command with args, the arguments after its
+// name.
+func Challenge(args []string) {
+	fs := flag.NewFlagSet("challenge", flag.ExitOnError)
+	server := fs.String("server", envOr("CALHACKS_SERVER",
+		"http://localhost:3000"), "URL of the server")
+	token := fs.String("token", os.Getenv("CALHACKS_TOKEN"),
+		"token to log in with, instead of logging in through GitHub")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, challengeUsage, prog())
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd := args[0]
+	fs.Parse(args[1:])
+
+	var run func(c *client.Client, args []string) error
+	switch {
+	case cmd == "import" && fs.NArg() == 1:
+		run = importChallenge
+	case cmd == "export" && fs.NArg() == 2:
+		run = exportChallenge
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *token == "" {
+		var err error
+		*token, err = loadOrLogin(*server)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := run(client.New(*server, *token), fs.Args()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func importChallenge(c *client.Client, args []string) error {
+	files, err := bundle.Read(args[0])
+	if err != nil {
+		return err
+	}
+	// Decode it here too so mistakes in the package are caught before it's
+	// uploaded.
+	if _, err := bundle.Decode(files); err != nil {
+		return err
+	}
+	pkg, err := files.Zip()
+	if err != nil {
+		return err
+	}
+	chlng, err := c.ImportChallenge(pkg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %q as challenge %d, a draft.\n", chlng.Title,
+		chlng.ID)
+	return nil
+}
+
+func exportChallenge(c *client.Client, args []string) error {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q isn't a challenge id", args[0])
+	}
+	pkg, err := c.ExportChallenge(id)
+	if err != nil {
+		return err
+	}
+	path := args[1]
+	if strings.HasSuffix(path, ".zip") {
+		return ioutil.WriteFile(path, pkg, 0644)
+	}
+	files, err := bundle.ReadZip(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		return err
+	}
+	return files.WriteDir(path)
+}
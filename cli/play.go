@@ -0,0 +1,288 @@
+// Package cli implements the commands for playing and managing challenges
+// from the terminal. They only talk to servers through their API, and are
+// shared by calhacks and calhacks-client.
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zachlatta/calhacks/bundle"
+	"github.com/zachlatta/calhacks/client"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const playUsage = `usage: %s play [flags] file
+
+Joins the game and runs file against the challenge's samples every time
+it's saved, or submits it for grading with -submit-on-save. Type "submit"
+and press enter to submit it. With -mode, waits to be matched with players
+of a similar rating first, and joins the room of the match.
+
+Flags:
+`
+
+// Play runs the play command with args, the arguments after its name.
+func Play(args []string) {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	server := fs.String("server", envOr("CALHACKS_SERVER",
+		"http://localhost:3000"), "URL of the server")
+	token := fs.String("token", os.Getenv("CALHACKS_TOKEN"),
+		"token to log in with, instead of logging in through GitHub")
+	lang := fs.String("lang", "",
+		"language the file is written in, guessed from its extension if unset")
+	mode := fs.String("mode", "",
+		"mode to be matched for, duel or ffa, instead of joining the main room")
+	region := fs.String("region", "", "region to be matched within, if any")
+	submitOnSave := fs.Bool("submit-on-save", false,
+		"submit the file every time it's saved, instead of running the samples")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, playUsage, prog())
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+
+	if *lang == "" {
+		*lang = bundle.LangOf(path)
+		if *lang == "" {
+			log.Fatalf("can't tell what language %s is written in, set -lang",
+				path)
+		}
+	}
+
+	if *token == "" {
+		var err error
+		*token, err = loadOrLogin(*server)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	c := client.New(*server, *token)
+	var room string
+	if *mode != "" {
+		var err error
+		room, err = findMatch(c, *mode, *lang, *region)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	conn, err := c.ConnectTo(room)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	submit := func(src []byte) {
+		if _, err := conn.Submit(*lang, src); err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("\nSubmitted %s.\n", path)
+	}
+	go watch(path, func(src []byte) {
+		if *submitOnSave {
+			submit(src)
+			return
+		}
+		if _, err := conn.RunSamples(*lang, src, nil); err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("\nRunning %s against the samples.\n", path)
+	})
+	go readCommands(func(cmd string) {
+		if cmd != "submit" {
+			fmt.Println(`Type "submit" to submit your solution.`)
+			return
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		submit(src)
+	})
+
+	for evt := range conn.Events() {
+		printEvent(evt)
+	}
+	if err := conn.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// findMatch queues for mode from the main room and waits to be matched,
+// returning the room of the match.
+func findMatch(c *client.Client, mode, lang, region string) (string, error) {
+	conn, err := c.Connect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.JoinQueue(mode, lang, region); err != nil {
+		return "", err
+	}
+	fmt.Printf("Waiting to be matched for %s...\n", mode)
+	for evt := range conn.Events() {
+		switch body := evt.Body.(type) {
+		case *protocol.MatchFoundEvent:
+			fmt.Printf("Matched with %d other players.\n", len(body.UserIDs)-1)
+			return body.Room, nil
+		case *protocol.ErrorEvent:
+			return "", fmt.Errorf("couldn't join the queue: %s", body.Message)
+		}
+	}
+	if err := conn.Err(); err != nil {
+		return "", err
+	}
+	return "", client.ErrClosed
+}
+
+// prog is the name of the command being run, for usage messages, since the
+// commands here are shared by calhacks and calhacks-client.
+func prog() string {
+	return filepath.Base(os.Args[0])
+}
+
+func envOr(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
+}
+
+func tokenPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".calhacks_token")
+}
+
+// loadOrLogin returns the token saved by the last login, logging in through
+// GitHub if there isn't one.
+func loadOrLogin(server string) (string, error) {
+	if tok, err := ioutil.ReadFile(tokenPath()); err == nil {
+		return strings.TrimSpace(string(tok)), nil
+	}
+	tok, err := client.Login(server, func(url string) error {
+		fmt.Printf("Visit this URL to log in:\n\n    %s\n\n", url)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(tokenPath(), []byte(tok), 0600); err != nil {
+		log.Println(err)
+	}
+	return tok, nil
+}
+
+// watch calls run with the contents of the file at path every time it's
+// saved.
+func watch(path string, run func(src []byte)) {
+	var last time.Time
+	if info, err := os.Stat(path); err == nil {
+		last = info.ModTime()
+	}
+	for _ = range time.Tick(500 * time.Millisecond) {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(last) {
+			continue
+		}
+		last = info.ModTime()
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		run(src)
+	}
+}
+
+// readCommands calls run with each line typed on stdin.
+func readCommands(run func(cmd string)) {
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		if cmd := strings.TrimSpace(s.Text()); cmd != "" {
+			run(cmd)
+		}
+	}
+}
+
+func printChallenge(c *model.Challenge) {
+	if c == nil {
+		return
+	}
+	fmt.Printf("\n== %s ==\n\n%s\n\n", c.Title, c.Description)
+	for i, tc := range c.Samples() {
+		fmt.Printf("Sample %d input:\n%s\nSample %d output:\n%s\n\n", i+1,
+			tc.Input, i+1, tc.ExpectedOutput)
+	}
+}
+
+func printEvent(evt *protocol.Event) {
+	switch body := evt.Body.(type) {
+	case *protocol.InitialStateEvent:
+		printChallenge(body.CurrentChallenge)
+		fmt.Printf("%d players in the game.\n", len(body.CurrentUsers))
+	case *protocol.ChallengeSetEvent:
+		printChallenge(body.Challenge)
+	case *protocol.TimerChangedEvent:
+		fmt.Printf("\r%d:%02d left ", body.Remaining/60, body.Remaining%60)
+	case *protocol.UserJoinedEvent:
+		fmt.Printf("\n%s joined.\n", body.User.Username)
+	case *protocol.CodeRanEvent:
+		verdict := "FAILED"
+		if body.Passed {
+			verdict = "PASSED"
+		} else if body.TimedOut {
+			verdict = "TIME LIMIT EXCEEDED"
+		}
+		fmt.Printf("\n%s, %d of %d tests passed, %d points\n%s\n", verdict,
+			body.TestsPassed, body.TestsTotal, body.Points, body.Output)
+	case *protocol.SamplesRanEvent:
+		for i, r := range body.Results {
+			fmt.Printf("\n-- Sample %d --\nInput:\n%s\nOutput:\n%s\n", i+1,
+				r.Input, r.Output)
+			if r.ExpectedOutput != "" {
+				fmt.Printf("Expected:\n%s\n", r.ExpectedOutput)
+			}
+		}
+	case *protocol.QueuePositionEvent:
+		if body.Position > 0 {
+			fmt.Printf("\nWaiting to run, %d in line.\n", body.Position)
+		} else {
+			fmt.Println("\nRunning...")
+		}
+	case *protocol.RejudgedEvent:
+		verdict := "failed"
+		if body.Passed {
+			verdict = "passed"
+		}
+		fmt.Printf("\nSubmission %d was rejudged and now %s, %d of %d tests "+
+			"passed, %d points (was %d).\n", body.SubmissionID, verdict,
+			body.TestsPassed, body.TestsTotal, body.Points, body.OldPoints)
+	case *protocol.ErrorEvent:
+		fmt.Printf("\nError: %s (%s)\n", body.Message, body.Code)
+	}
+
+	switch evt.Type {
+	case protocol.TimerFinished:
+		fmt.Println("\nTime's up!")
+	case protocol.BreakStarted:
+		fmt.Println("Break time, the next challenge starts soon.")
+	case protocol.NoChallenges:
+		fmt.Println("There are no challenges to play yet, waiting for one.")
+	}
+}
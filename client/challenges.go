@@ -0,0 +1,79 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// APIError is an error response from the server's API.
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+}
+
+// do sends a request to the API at path, returning the response if it
+// succeeded and an *APIError if it didn't.
+func (c *Client) do(method, path, contentType string,
+	body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 400 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var data struct {
+		Error APIError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil ||
+		data.Error.Status == 0 {
+		return nil, &APIError{resp.StatusCode, resp.Status}
+	}
+	return nil, &data.Error
+}
+
+// ImportChallenge creates a challenge from a zipped challenge package.
+func (c *Client) ImportChallenge(pkg []byte) (*model.Challenge, error) {
+	resp, err := c.do("POST", "/challenges/import", "application/zip",
+		bytes.NewReader(pkg))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var chlng model.Challenge
+	if err := json.NewDecoder(resp.Body).Decode(&chlng); err != nil {
+		return nil, err
+	}
+	return &chlng, nil
+}
+
+// ExportChallenge returns the challenge with the given ID as a zipped
+// challenge package.
+func (c *Client) ExportChallenge(id int64) ([]byte, error) {
+	resp, err := c.do("GET", fmt.Sprintf("/challenges/%d/export", id), "",
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
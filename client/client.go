@@ -0,0 +1,257 @@
+// Package client talks to a calhacks server on behalf of a player.
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// maxReconnects is how many times in a row a Conn tries to reconnect after
+// losing its connection before giving up.
+const maxReconnects = 5
+
+var ErrClosed = errors.New("connection closed")
+
+type Client struct {
+	// BaseURL is the URL of the server's API, like http://localhost:3000.
+	BaseURL string
+
+	// Token is the JWT the server issued when the player logged in.
+	Token string
+}
+
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+func (c *Client) header() http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+c.Token)
+	return h
+}
+
+// Conn is a connection to the game. If the connection drops, Conn reconnects
+// and resumes its session so that no events are missed.
+type Conn struct {
+	client *Client
+	room   string
+	events chan *protocol.Event
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	session string
+	lastSeq int64
+	nextID  int64
+	closed  bool
+	err     error
+}
+
+// Connect joins the game's main room.
+func (c *Client) Connect() (*Conn, error) {
+	return c.ConnectTo("")
+}
+
+// ConnectTo joins a room the player was matched into, or the main room if
+// room is empty.
+func (c *Client) ConnectTo(room string) (*Conn, error) {
+	conn := &Conn{
+		client: c,
+		room:   room,
+		events: make(chan *protocol.Event, 64),
+	}
+	ws, err := conn.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn.ws = ws
+	go conn.readLoop()
+	return conn, nil
+}
+
+func (c *Conn) dial() (*websocket.Conn, error) {
+	u, err := url.Parse(c.client.BaseURL + "/connect")
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+
+	q := u.Query()
+	if c.room != "" {
+		q.Set("room", c.room)
+	}
+	c.mu.Lock()
+	if c.session != "" {
+		q.Set("session", c.session)
+		q.Set("last_seq", strconv.FormatInt(c.lastSeq, 10))
+	}
+	c.mu.Unlock()
+	u.RawQuery = q.Encode()
+
+	dialer := websocket.Dialer{
+		Subprotocols: []string{protocol.Subprotocol(protocol.Version)},
+	}
+	ws, _, err := dialer.Dial(u.String(), c.client.header())
+	return ws, err
+}
+
+// Events returns the events sent by the server. The channel is closed once
+// the connection is closed or can't be recovered, after which Err explains
+// why.
+func (c *Conn) Events() <-chan *protocol.Event {
+	return c.events
+}
+
+// Err returns the error that ended the connection, if any.
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.events)
+	failures := 0
+	for {
+		c.mu.Lock()
+		ws := c.ws
+		c.mu.Unlock()
+
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if c.isClosed() {
+				return
+			}
+			if failures >= maxReconnects {
+				c.fail(err)
+				return
+			}
+			failures++
+			time.Sleep(time.Duration(failures) * time.Second)
+			ws, err := c.dial()
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			c.ws = ws
+			c.mu.Unlock()
+			continue
+		}
+		failures = 0
+
+		var evt protocol.Event
+		if err := json.Unmarshal(data, &evt); err != nil {
+			// Events this client doesn't understand, probably because the
+			// server is newer, are skipped.
+			continue
+		}
+		c.mu.Lock()
+		if evt.Type == protocol.SessionStarted {
+			c.session = evt.Body.(*protocol.SessionStartedEvent).Token
+		}
+		if evt.Seq > 0 {
+			c.lastSeq = evt.Seq
+		}
+		c.mu.Unlock()
+		c.events <- &evt
+	}
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// Send sends evt to the server, giving it a new request ID that the server's
+// reply will carry.
+func (c *Conn) Send(evt *protocol.Event) (requestID string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return "", ErrClosed
+	}
+	c.nextID++
+	evt.RequestID = strconv.FormatInt(c.nextID, 10)
+	return evt.RequestID, c.ws.WriteJSON(evt)
+}
+
+// Submit submits code written in lang for grading against the current
+// challenge.
+func (c *Conn) Submit(lang string, code []byte) (requestID string, err error) {
+	return c.Send(&protocol.Event{
+		Type: protocol.SubmitCode,
+		Body: &protocol.RunCodeEvent{
+			Lang: lang,
+			Code: base64.StdEncoding.EncodeToString(code),
+		},
+	})
+}
+
+// RunSamples runs code written in lang against the current challenge's
+// sample test cases, or against input alone if it isn't nil. It's never
+// graded.
+func (c *Conn) RunSamples(lang string, code []byte,
+	input *string) (requestID string, err error) {
+	return c.Send(&protocol.Event{
+		Type: protocol.RunSamples,
+		Body: &protocol.RunSamplesEvent{
+			Lang:  lang,
+			Code:  base64.StdEncoding.EncodeToString(code),
+			Input: input,
+		},
+	})
+}
+
+// Chat sends text to the room, or only to the player with the ID to if it
+// isn't zero.
+func (c *Conn) Chat(to int64, text string) (requestID string, err error) {
+	return c.Send(&protocol.Event{
+		Type: protocol.SendChat,
+		Body: &protocol.SendChatEvent{To: to, Text: text},
+	})
+}
+
+// JoinQueue queues the player to be matched with others for mode. lang and
+// region are optional. Once they're matched, a MatchFound event says which
+// room to join with ConnectTo.
+func (c *Conn) JoinQueue(mode, lang, region string) (requestID string,
+	err error) {
+	return c.Send(&protocol.Event{
+		Type: protocol.JoinQueue,
+		Body: &protocol.JoinQueueEvent{Mode: mode, Lang: lang, Region: region},
+	})
+}
+
+// LeaveQueue takes the player out of the matchmaking queue.
+func (c *Conn) LeaveQueue() (requestID string, err error) {
+	return c.Send(&protocol.Event{Type: protocol.LeaveQueue})
+}
+
+// Close leaves the game.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return c.ws.Close()
+}
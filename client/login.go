@@ -0,0 +1,54 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// loginTimeout is how long Login waits for the player to finish logging in.
+const loginTimeout = 5 * time.Minute
+
+// Login logs the player in through GitHub and returns the token the server
+// issues for them. It calls open with the URL the player needs to visit, then
+// waits for the server to redirect them back to a listener on this machine.
+func Login(baseURL string, open func(url string) error) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+
+	tokens := make(chan string, 1)
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		tok := r.FormValue("tok")
+		if tok == "" {
+			http.Error(w, "missing token", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "You're logged in. You can close this window.")
+		select {
+		case tokens <- tok:
+		default:
+		}
+	}))
+
+	callback := "http://" + l.Addr().String() + "/"
+	loginURL := strings.TrimRight(baseURL, "/") + "/oauth/login?redirect=" +
+		url.QueryEscape(callback)
+	if err := open(loginURL); err != nil {
+		return "", err
+	}
+
+	select {
+	case tok := <-tokens:
+		return tok, nil
+	case <-time.After(loginTimeout):
+		return "", errors.New("timed out waiting for login")
+	}
+}
@@ -0,0 +1,39 @@
+// Command calhacks-client plays and manages challenges on calhacks servers.
+// It only talks to servers through their API, so it can be run anywhere
+// without the server's code or configuration.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/zachlatta/calhacks/cli"
+)
+
+const usage = `usage: calhacks-client command [arguments]
+
+Commands:
+    play       play from the terminal
+    bots       fill a server with bots
+    challenge  import and export challenge packages
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+
+	switch cmd {
+	case "play":
+		cli.Play(args)
+	case "bots":
+		cli.Bots(args)
+	case "challenge":
+		cli.Challenge(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,35 @@
+// Command calhacks-worker runs code for calhacks servers started with RUNNER
+// set to remote. It takes jobs from the servers' Redis, so any number of
+// workers can be run anywhere that can reach it and a Docker daemon.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/zachlatta/calhacks/redisutil"
+	"github.com/zachlatta/calhacks/runner"
+	"github.com/zachlatta/calhacks/sandbox"
+)
+
+func main() {
+	hostname, _ := os.Hostname()
+	id := flag.String("id", hostname, "name the worker advertises itself as")
+	concurrency := flag.Int("concurrency", 4, "number of jobs to run at once")
+	endpoint := flag.String("docker", sandbox.DefaultEndpoint,
+		"Docker daemon to run code on")
+	dir := flag.String("dir", "/tmp/calhacks",
+		"directory code is written to, which must exist on the Docker host")
+	flag.Parse()
+
+	sb, err := sandbox.New(*endpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sb.Dir = *dir
+
+	w := runner.NewWorker(*id, *concurrency, redisutil.NewPool(), sb)
+	log.Printf("worker %s running %v", w.ID, w.Languages)
+	w.Run()
+}
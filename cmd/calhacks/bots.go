@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/handler"
+	"github.com/zachlatta/calhacks/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+const botTokensUsage = `usage: calhacks bot-tokens [flags]
+
+Prints a token for each of n bots, one per line, creating the bots' users
+directly in the server's database the first time. This must be run with the
+same configuration as the server. Pass the tokens to "calhacks-client bots".
+
+Flags:
+`
+
+func botTokens(args []string) {
+	fs := flag.NewFlagSet("bot-tokens", flag.ExitOnError)
+	n := fs.Int("n", 10, "number of bots")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, botTokensUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	datastore.Connect()
+	defer datastore.Disconnect()
+
+	for i := 0; i < *n; i++ {
+		tok, err := botToken(i)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(tok)
+	}
+}
+
+// botToken returns a token for the ith bot, creating its user the first
+// time. Bots are given negative GitHub IDs so they can't collide with real
+// users.
+func botToken(i int) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, err := datastore.NewContextWithTx(ctx)
+	if err != nil {
+		return "", err
+	}
+	tx, _ := datastore.TxFromContext(ctx)
+
+	githubID := -(i + 1)
+	u, err := datastore.GetUserByGitHubID(ctx, githubID)
+	if err == sql.ErrNoRows {
+		u = &model.User{
+			Username: fmt.Sprintf("bot-%d", i+1),
+			GitHubID: githubID,
+		}
+		err = datastore.SaveUser(ctx, u)
+	}
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return handler.CreateToken(u)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/zachlatta/calhacks"
+	"github.com/zachlatta/calhacks/cli"
 	"github.com/zachlatta/calhacks/datastore"
 	"github.com/zachlatta/calhacks/handler"
 )
@@ -31,7 +33,41 @@ func httpLogAndApplyCORS(handler http.Handler) http.Handler {
 	})
 }
 
+const usage = `usage: calhacks [command] [arguments]
+
+Commands:
+    serve       run the server (the default)
+    bot-tokens  create users for bots and print their tokens
+    play        play from the terminal
+    challenge   import and export challenge packages
+
+Play and challenge only talk to a server through its API. They're also in
+calhacks-client, which can be installed without the server's dependencies.
+`
+
 func main() {
+	cmd := "serve"
+	var args []string
+	if len(os.Args) > 1 {
+		cmd, args = os.Args[1], os.Args[2:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve()
+	case "bot-tokens":
+		botTokens(args)
+	case "play":
+		cli.Play(args)
+	case "challenge":
+		cli.Challenge(args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve() {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
@@ -40,7 +76,7 @@ func main() {
 	datastore.Connect()
 	defer datastore.Disconnect()
 
-	go calhacks.Game.Run()
+	calhacks.Lobby.Run()
 
 	m := http.NewServeMux()
 	m.Handle("/", handler.Handler())
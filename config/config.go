@@ -17,15 +17,29 @@ var (
 )
 
 func init() {
+	// Without a checkout to read the config files from, settings only come
+	// from the environment, the same as when the files don't exist.
 	baseCfgPath, err := osutil.ResolveFilePathInEnv("GOPATH",
 		"/src/github.com/zachlatta/calhacks/")
-	if err != nil {
-		panic(err)
+	if err == nil {
+		readFiles(baseCfgPath)
 	}
 
+	githubOauthConfig = &oauth.Config{
+		ClientId:     GitHubClientID(),
+		ClientSecret: GitHubClientSecret(),
+		Scope:        "public",
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		RedirectURL:  RedirectURL(),
+	}
+}
+
+func readFiles(baseCfgPath string) {
 	cfgPath := baseCfgPath + "config/config.yml"
 	dbCfgPath := baseCfgPath + "db/dbconf.yml"
 
+	var err error
 	config, err = yaml.ReadFile(cfgPath)
 	if err != nil && !os.IsNotExist(err) {
 		log.Fatal("Error loading config", err)
@@ -35,15 +49,6 @@ func init() {
 	if err != nil && !os.IsNotExist(err) {
 		log.Fatal("Error loading config", err)
 	}
-
-	githubOauthConfig = &oauth.Config{
-		ClientId:     GitHubClientID(),
-		ClientSecret: GitHubClientSecret(),
-		Scope:        "public",
-		AuthURL:      "https://github.com/login/oauth/authorize",
-		TokenURL:     "https://github.com/login/oauth/access_token",
-		RedirectURL:  RedirectURL(),
-	}
 }
 
 func Get(param string) string {
@@ -0,0 +1,142 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+// attemptsStmt groups submissions into attempts, ordered by when their round
+// started. Rounds are as long as their round_started game event says. It's
+// completed with a condition on the submissions, s, if any.
+const attemptsStmt = `
+SELECT s.round_id, s.user_id, s.challenge_id, bool_or(s.points > 0),
+  (array_agg(s.lang ORDER BY s.points > 0 DESC, s.created DESC))[1],
+  coalesce(extract(epoch FROM
+    min(CASE WHEN s.points > 0 THEN s.created END) - r.created), 0),
+  coalesce((
+    SELECT max(e.seconds) FROM game_events e
+    WHERE e.round_id = s.round_id AND e.type = 'round_started'
+  ), 0)
+FROM submissions s
+JOIN rounds r ON r.id = s.round_id
+%s
+GROUP BY s.round_id, s.user_id, s.challenge_id, r.created
+ORDER BY r.created, s.round_id, s.user_id
+`
+
+var getAttemptsStmt = fmt.Sprintf(attemptsStmt, "")
+
+var getRoundAttemptsStmt = fmt.Sprintf(attemptsStmt, "WHERE s.round_id=$1")
+
+const updateCalibrationStmt = `UPDATE challenge_calibrations SET
+calibrated=$2, rating=$3, attempts=$4, difficulty=$5, seconds=$6, applied=$7
+WHERE challenge_id=$1`
+
+const createCalibrationStmt = `INSERT INTO challenge_calibrations
+(challenge_id, calibrated, rating, attempts, difficulty, seconds, applied)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+const getCalibrationStmt = `SELECT challenge_id, calibrated, rating,
+attempts, difficulty, seconds, applied FROM challenge_calibrations WHERE
+challenge_id=$1`
+
+const applyCalibrationStmt = `UPDATE challenges SET
+difficulty=coalesce($2, difficulty), seconds=coalesce($3, seconds) WHERE
+id=$1`
+
+// GetAttempts returns every attempt at every challenge, oldest first.
+func GetAttempts(ctx context.Context) ([]*model.Attempt, error) {
+	tx, _ := TxFromContext(ctx)
+	return scanAttempts(tx.Query(getAttemptsStmt))
+}
+
+// GetRoundAttempts returns the attempts made in a round.
+func GetRoundAttempts(ctx context.Context,
+	roundID int64) ([]*model.Attempt, error) {
+	tx, _ := TxFromContext(ctx)
+	return scanAttempts(tx.Query(getRoundAttemptsStmt, roundID))
+}
+
+func scanAttempts(rows *sql.Rows, err error) ([]*model.Attempt, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attempts []*model.Attempt
+	for rows.Next() {
+		a := model.Attempt{}
+		var solveSeconds float64
+		if err := rows.Scan(&a.RoundID, &a.UserID, &a.ChallengeID, &a.Solved,
+			&a.Lang, &solveSeconds, &a.RoundSeconds); err != nil {
+			return nil, err
+		}
+		a.SolveSeconds = int(solveSeconds)
+		attempts = append(attempts, &a)
+	}
+	return attempts, rows.Err()
+}
+
+// SaveCalibration saves a challenge's calibration, replacing the last one.
+// It doesn't change the challenge, see ApplyCalibration.
+func SaveCalibration(ctx context.Context, cal *model.Calibration) error {
+	tx, _ := TxFromContext(ctx)
+
+	var (
+		difficulty sql.NullString
+		seconds    sql.NullInt64
+	)
+	if cal.Difficulty != "" {
+		difficulty = sql.NullString{String: cal.Difficulty, Valid: true}
+	}
+	if cal.Seconds != 0 {
+		seconds = sql.NullInt64{Int64: int64(cal.Seconds), Valid: true}
+	}
+	return upsert(tx, updateCalibrationStmt, createCalibrationStmt,
+		cal.ChallengeID, cal.Calibrated, cal.Rating, cal.Attempts, difficulty,
+		seconds, cal.Applied)
+}
+
+// GetCalibration returns a challenge's latest calibration, or sql.ErrNoRows
+// if it hasn't been calibrated.
+func GetCalibration(ctx context.Context,
+	challengeID int64) (*model.Calibration, error) {
+	tx, _ := TxFromContext(ctx)
+
+	cal := model.Calibration{}
+	var (
+		difficulty sql.NullString
+		seconds    sql.NullInt64
+	)
+	row := tx.QueryRow(getCalibrationStmt, challengeID)
+	if err := row.Scan(&cal.ChallengeID, &cal.Calibrated, &cal.Rating,
+		&cal.Attempts, &difficulty, &seconds, &cal.Applied); err != nil {
+		return nil, err
+	}
+	cal.Difficulty = difficulty.String
+	cal.Seconds = int(seconds.Int64)
+	return &cal, nil
+}
+
+// ApplyCalibration sets a challenge's difficulty and round length to the
+// ones a calibration suggests, leaving alone any it doesn't. The challenge
+// isn't given a new version, since neither changes how it's judged.
+func ApplyCalibration(ctx context.Context, cal *model.Calibration) error {
+	tx, _ := TxFromContext(ctx)
+
+	var (
+		difficulty sql.NullString
+		seconds    sql.NullInt64
+	)
+	if cal.Difficulty != "" {
+		difficulty = sql.NullString{String: cal.Difficulty, Valid: true}
+	}
+	if cal.Seconds != 0 {
+		seconds = sql.NullInt64{Int64: int64(cal.Seconds), Valid: true}
+	}
+	_, err := tx.Exec(applyCalibrationStmt, cal.ChallengeID, difficulty,
+		seconds)
+	return err
+}
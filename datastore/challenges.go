@@ -1,7 +1,9 @@
 package datastore
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"code.google.com/p/go.net/context"
@@ -9,26 +11,101 @@ import (
 )
 
 const createChlngStmt = `INSERT INTO challenges (created, updated, title,
-description, seconds, expected_output) VALUES ($1, $2, $3, $4, $5, $6)
-RETURNING id`
+description, seconds, author_id, time_limit, status, checker_lang,
+checker_code, difficulty) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10,
+$11) RETURNING id`
+
+const updateChlngStmt = `UPDATE challenges SET updated=$2, title=$3,
+description=$4, seconds=$5, time_limit=$6, status=$7, checker_lang=$8,
+checker_code=$9, difficulty=$10 WHERE id=$1`
+
+const deleteChlngStmt = `UPDATE challenges SET deleted=$2 WHERE id=$1`
 
 const createTestCaseStmt = `INSERT INTO challenge_test_cases (created, updated,
-challenge_id) VALUES ($1, $2, $3) RETURNING id`
+challenge_id, input, expected_output, sample) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`
+
+const updateTestCaseStmt = `UPDATE challenge_test_cases SET updated=$3,
+input=$4, expected_output=$5, sample=$6 WHERE id=$1 AND challenge_id=$2`
+
+const deleteTestCaseStmt = `DELETE FROM challenge_test_cases WHERE id=$1`
 
-const getChlngStmt = `SELECT id, created, updated, title, description, seconds,
-expected_output FROM challenges WHERE id=$1`
+const chlngColumns = `id, created, updated, title, description, seconds,
+author_id, deleted, time_limit, status, checker_lang, checker_code,
+difficulty, version`
+
+const getChlngStmt = `SELECT ` + chlngColumns + ` FROM challenges WHERE id=$1`
 
 const getChlngTestCasesStmt = `
-SELECT id, created, updated
+SELECT id, created, updated, input, expected_output, sample
 FROM challenge_test_cases
 WHERE challenge_id=$1
+ORDER BY id
+`
+
+const createSolutionStmt = `INSERT INTO challenge_solutions (created, updated,
+challenge_id, lang, code, correct) VALUES ($1, $2, $3, $4, $5, $6) RETURNING
+id`
+
+const deleteSolutionsStmt = `DELETE FROM challenge_solutions WHERE
+challenge_id=$1`
+
+const getSolutionsStmt = `
+SELECT id, created, updated, lang, code, correct
+FROM challenge_solutions
+WHERE challenge_id=$1
+ORDER BY id
 `
 
+const createTagStmt = `INSERT INTO challenge_tags (challenge_id, tag) VALUES
+($1, $2)`
+
+const deleteTagsStmt = `DELETE FROM challenge_tags WHERE challenge_id=$1`
+
+const getTagsStmt = `SELECT tag FROM challenge_tags WHERE challenge_id=$1
+ORDER BY tag`
+
+const createSourceStmt = `INSERT INTO challenge_sources (challenge_id,
+author, source, url) VALUES ($1, $2, $3, $4)`
+
+const deleteSourceStmt = `DELETE FROM challenge_sources WHERE
+challenge_id=$1`
+
+const getSourceStmt = `SELECT author, source, url FROM challenge_sources
+WHERE challenge_id=$1`
+
+// chlngSearchDoc is what challenges are searched by. It must match the
+// expression challenges_search_idx is on, or the index won't be used.
+const chlngSearchDoc = `to_tsvector('english', title || ' ' || description)`
+
 const getRandChlngIDStmt = `
 SELECT id FROM challenges
-OFFSET random()*(SELECT count(*) FROM challenges)
+WHERE deleted IS NULL AND status='published'%s
+ORDER BY random()
 LIMIT 1`
 
+// ChallengeFilter narrows down the challenges returned by ListChallenges.
+// Zero values don't filter anything.
+type ChallengeFilter struct {
+	AuthorID int64
+	Title    string // matched anywhere in the title, ignoring case
+	Status   string
+
+	// Query is searched for in the title and description, and challenges
+	// that match it best come first.
+	Query string
+
+	// Tags are tags challenges must all have.
+	Tags       []string
+	Difficulty string
+
+	// IncludeDeleted includes challenges that have been deleted.
+	IncludeDeleted bool
+
+	Limit  int
+	Offset int
+}
+
 // TODO: Cancel if context cancels.
 func SaveChallenge(ctx context.Context, c *model.Challenge) error {
 	tx, _ := TxFromContext(ctx)
@@ -40,9 +117,28 @@ func SaveChallenge(ctx context.Context, c *model.Challenge) error {
 	}
 	c.Updated = time.Now()
 
+	if c.Status == "" {
+		c.Status = model.StatusDraft
+	}
+
+	var checkerLang, checkerCode sql.NullString
+	if c.Checker != nil {
+		checkerLang = sql.NullString{String: c.Checker.Lang, Valid: true}
+		checkerCode = sql.NullString{String: c.Checker.Code, Valid: true}
+	}
+	var difficulty sql.NullString
+	if c.Difficulty != "" {
+		difficulty = sql.NullString{String: c.Difficulty, Valid: true}
+	}
+
 	if newChallenge {
+		var authorID sql.NullInt64
+		if c.AuthorID != 0 {
+			authorID = sql.NullInt64{Int64: c.AuthorID, Valid: true}
+		}
 		rows, err := tx.Query(createChlngStmt, c.Created, c.Updated, c.Title,
-			c.Description, c.Seconds, c.ExpectedOutput)
+			c.Description, c.Seconds, authorID, c.TimeLimit, c.Status,
+			checkerLang, checkerCode, difficulty)
 		if err != nil {
 			return err
 		}
@@ -55,7 +151,14 @@ func SaveChallenge(ctx context.Context, c *model.Challenge) error {
 			return err
 		}
 	} else {
-		fmt.Println("NOT IMPLEMENTED")
+		if _, err := tx.Exec(updateChlngStmt, c.ID, c.Updated, c.Title,
+			c.Description, c.Seconds, c.TimeLimit, c.Status, checkerLang,
+			checkerCode, difficulty); err != nil {
+			return err
+		}
+		if err := deleteRemovedTestCases(ctx, c); err != nil {
+			return err
+		}
 	}
 
 	for i := 0; i < len(c.TestCases); i++ {
@@ -63,6 +166,155 @@ func SaveChallenge(ctx context.Context, c *model.Challenge) error {
 			return err
 		}
 	}
+	if err := saveTags(ctx, c); err != nil {
+		return err
+	}
+	if err := saveAttribution(ctx, c); err != nil {
+		return err
+	}
+	if err := saveSolutions(ctx, c); err != nil {
+		return err
+	}
+	return saveVersion(ctx, c)
+}
+
+// saveTags replaces the saved tags of c with c.Tags.
+func saveTags(ctx context.Context, c *model.Challenge) error {
+	tx, _ := TxFromContext(ctx)
+
+	if _, err := tx.Exec(deleteTagsStmt, c.ID); err != nil {
+		return err
+	}
+	for _, tag := range c.Tags {
+		if _, err := tx.Exec(createTagStmt, c.ID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTags(ctx context.Context, challengeID int64) ([]string, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getTagsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// saveAttribution replaces the saved attribution of c with c.Attribution.
+func saveAttribution(ctx context.Context, c *model.Challenge) error {
+	tx, _ := TxFromContext(ctx)
+
+	if _, err := tx.Exec(deleteSourceStmt, c.ID); err != nil {
+		return err
+	}
+	if a := c.Attribution; a != nil {
+		if _, err := tx.Exec(createSourceStmt, c.ID, a.Author, a.Source,
+			a.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getAttribution(ctx context.Context,
+	challengeID int64) (*model.Attribution, error) {
+	tx, _ := TxFromContext(ctx)
+
+	a := model.Attribution{}
+	err := tx.QueryRow(getSourceStmt, challengeID).Scan(&a.Author, &a.Source,
+		&a.URL)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// getDetails loads the tags and attribution of c.
+func getDetails(ctx context.Context, c *model.Challenge) error {
+	var err error
+	if c.Tags, err = getTags(ctx, c.ID); err != nil {
+		return err
+	}
+	c.Attribution, err = getAttribution(ctx, c.ID)
+	return err
+}
+
+// saveSolutions replaces the saved solutions of c with c.Solutions.
+func saveSolutions(ctx context.Context, c *model.Challenge) error {
+	tx, _ := TxFromContext(ctx)
+
+	if _, err := tx.Exec(deleteSolutionsStmt, c.ID); err != nil {
+		return err
+	}
+	for i := range c.Solutions {
+		s := &c.Solutions[i]
+		s.Created = time.Now()
+		s.Updated = s.Created
+		row := tx.QueryRow(createSolutionStmt, s.Created, s.Updated, c.ID,
+			s.Lang, s.Code, s.Correct)
+		if err := row.Scan(&s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getSolutions(ctx context.Context,
+	challengeID int64) ([]model.Solution, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getSolutionsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var solutions []model.Solution
+	for rows.Next() {
+		s := model.Solution{}
+		if err := rows.Scan(&s.ID, &s.Created, &s.Updated, &s.Lang, &s.Code,
+			&s.Correct); err != nil {
+			return nil, err
+		}
+		solutions = append(solutions, s)
+	}
+	return solutions, rows.Err()
+}
+
+// deleteRemovedTestCases deletes the saved test cases of c that are no longer
+// in c.TestCases.
+func deleteRemovedTestCases(ctx context.Context, c *model.Challenge) error {
+	tx, _ := TxFromContext(ctx)
+
+	saved, err := getTestCases(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	keep := make(map[int64]bool)
+	for _, tc := range c.TestCases {
+		keep[tc.ID] = true
+	}
+	for _, tc := range saved {
+		if keep[tc.ID] {
+			continue
+		}
+		if _, err := tx.Exec(deleteTestCaseStmt, tc.ID); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -79,7 +331,7 @@ func SaveTestCase(ctx context.Context, tc *model.TestCase,
 
 	if newTc {
 		rows, err := tx.Query(createTestCaseStmt, tc.Created, tc.Updated,
-			challengeID)
+			challengeID, tc.Input, tc.ExpectedOutput, tc.Sample)
 		if err != nil {
 			return err
 		}
@@ -92,39 +344,218 @@ func SaveTestCase(ctx context.Context, tc *model.TestCase,
 			return err
 		}
 	} else {
-		fmt.Println("NOT IMPLEMENTED")
+		res, err := tx.Exec(updateTestCaseStmt, tc.ID, challengeID, tc.Updated,
+			tc.Input, tc.ExpectedOutput, tc.Sample)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("test case %d isn't part of challenge %d", tc.ID,
+				challengeID)
+		}
 	}
 	return nil
 }
 
+// GetChallenge returns the challenge with the given ID with its test cases and
+// solutions, even if it's been deleted.
 func GetChallenge(ctx context.Context, id int64) (*model.Challenge, error) {
 	tx, _ := TxFromContext(ctx)
 
+	c, err := scanChallenge(tx.QueryRow(getChlngStmt, id))
+	if err != nil {
+		return nil, err
+	}
+	c.TestCases, err = getTestCases(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.Solutions, err = getSolutions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := getDetails(ctx, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanChallenge(row scanner) (*model.Challenge, error) {
 	c := model.Challenge{}
-	row := tx.QueryRow(getChlngStmt, id)
-	if err := row.Scan(&c.ID, &c.Created, &c.Updated, &c.Title, &c.Description,
-		&c.Seconds, &c.ExpectedOutput); err != nil {
+	var (
+		authorID                 sql.NullInt64
+		deleted                  *time.Time
+		checkerLang, checkerCode sql.NullString
+		difficulty               sql.NullString
+	)
+	if err := row.Scan(&c.ID, &c.Created, &c.Updated, &c.Title,
+		&c.Description, &c.Seconds, &authorID, &deleted,
+		&c.TimeLimit, &c.Status, &checkerLang, &checkerCode,
+		&difficulty, &c.Version); err != nil {
 		return nil, err
 	}
-	rows, err := tx.Query(getChlngTestCasesStmt, id)
+	c.AuthorID = authorID.Int64
+	c.Deleted = deleted
+	c.Difficulty = difficulty.String
+	if checkerLang.Valid {
+		c.Checker = &model.Checker{
+			Lang: checkerLang.String,
+			Code: checkerCode.String,
+		}
+	}
+	return &c, nil
+}
+
+func getTestCases(ctx context.Context,
+	challengeID int64) ([]model.TestCase, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getChlngTestCasesStmt, challengeID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	tcs := []model.TestCase{}
 	for rows.Next() {
 		t := model.TestCase{}
-		if err := rows.Scan(&t.ID, &t.Created, &t.Updated); err != nil {
+		if err := rows.Scan(&t.ID, &t.Created, &t.Updated, &t.Input,
+			&t.ExpectedOutput, &t.Sample); err != nil {
 			return nil, err
 		}
-		c.TestCases = append(c.TestCases, t)
+		tcs = append(tcs, t)
 	}
+	return tcs, rows.Err()
+}
 
-	return &c, nil
+// ListChallenges returns the challenges matching f, without their test
+// cases. They're ordered by how well they match f.Query if it's set, and
+// newest first otherwise.
+func ListChallenges(ctx context.Context,
+	f *ChallengeFilter) ([]*model.Challenge, error) {
+	tx, _ := TxFromContext(ctx)
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if !f.IncludeDeleted {
+		where = append(where, "deleted IS NULL")
+	}
+	if f.AuthorID != 0 {
+		where = append(where, "author_id="+arg(f.AuthorID))
+	}
+	if f.Status != "" {
+		where = append(where, "status="+arg(f.Status))
+	}
+	if f.Title != "" {
+		where = append(where, "title ILIKE "+arg("%"+escapeLike(f.Title)+"%"))
+	}
+	if f.Difficulty != "" {
+		where = append(where, "difficulty="+arg(f.Difficulty))
+	}
+	for _, tag := range f.Tags {
+		where = append(where, "id IN (SELECT challenge_id FROM challenge_tags "+
+			"WHERE tag="+arg(tag)+")")
+	}
+	order := "created DESC, id DESC"
+	if f.Query != "" {
+		q := "plainto_tsquery('english', " + arg(f.Query) + ")"
+		where = append(where, chlngSearchDoc+" @@ "+q)
+		order = "ts_rank(" + chlngSearchDoc + ", " + q + ") DESC, " + order
+	}
+
+	query := "SELECT " + chlngColumns + " FROM challenges"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + order
+	if f.Limit > 0 {
+		query += " LIMIT " + arg(f.Limit)
+	}
+	if f.Offset > 0 {
+		query += " OFFSET " + arg(f.Offset)
+	}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chlngs := []*model.Challenge{}
+	for rows.Next() {
+		c, err := scanChallenge(rows)
+		if err != nil {
+			return nil, err
+		}
+		chlngs = append(chlngs, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for _, c := range chlngs {
+		if err := getDetails(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	return chlngs, nil
 }
 
-func GetRandomChallenge(ctx context.Context) (*model.Challenge, error) {
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// DeleteChallenge soft deletes a challenge. Deleted challenges are kept so
+// past rounds can still refer to them, but they're never picked for new
+// rounds.
+func DeleteChallenge(ctx context.Context, id int64) error {
+	tx, _ := TxFromContext(ctx)
+	_, err := tx.Exec(deleteChlngStmt, id, time.Now())
+	return err
+}
+
+// GetRandomChallenge returns a random published challenge. If any tags are
+// given, the challenge has at least one of them. If a difficulty is given,
+// the challenge has it, going by its calibrated difficulty if it has one.
+func GetRandomChallenge(ctx context.Context, tags []string,
+	difficulty string) (*model.Challenge, error) {
 	tx, _ := TxFromContext(ctx)
+
+	var (
+		filter string
+		args   []interface{}
+	)
+	if len(tags) > 0 {
+		params := make([]string, len(tags))
+		for i, tag := range tags {
+			args = append(args, tag)
+			params[i] = fmt.Sprintf("$%d", i+1)
+		}
+		filter = fmt.Sprintf(` AND id IN (
+  SELECT challenge_id FROM challenge_tags WHERE tag IN (%s)
+)`, strings.Join(params, ", "))
+	}
+	if difficulty != "" {
+		args = append(args, difficulty)
+		filter += fmt.Sprintf(` AND coalesce((
+  SELECT cal.difficulty FROM challenge_calibrations cal
+  WHERE cal.challenge_id = challenges.id
+), challenges.difficulty) = $%d`, len(args))
+	}
 	var id int64
-	row := tx.QueryRow(getRandChlngIDStmt)
+	row := tx.QueryRow(fmt.Sprintf(getRandChlngIDStmt, filter), args...)
 	if err := row.Scan(&id); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,113 @@
+package datastore
+
+import (
+	"encoding/json"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createGameEventStmt = `INSERT INTO game_events (created, room, type,
+round_id, challenge_id, user_id, seconds, node) VALUES ($1, $2, $3, $4, $5, $6,
+$7, $8) RETURNING id`
+
+const getGameEventsStmt = `
+SELECT id, created, room, type, round_id, challenge_id, user_id, seconds, node
+FROM game_events
+WHERE room=$1 AND id > $2
+ORDER BY id
+`
+
+const updateGameSnapshotStmt = `UPDATE game_snapshots SET event_id=$2,
+round_id=$3, challenge_id=$4, is_break=$5, started=$6, seconds=$7, users=$8,
+solvers=$9 WHERE room=$1 AND event_id < $2`
+
+const createGameSnapshotStmt = `INSERT INTO game_snapshots (room, event_id,
+round_id, challenge_id, is_break, started, seconds, users, solvers) VALUES ($1,
+$2, $3, $4, $5, $6, $7, $8, $9)`
+
+const getGameSnapshotStmt = `SELECT room, event_id, round_id, challenge_id,
+is_break, started, seconds, users, solvers FROM game_snapshots WHERE room=$1`
+
+// SaveGameEvent appends e to the game's event log. Game events are never
+// updated once written.
+func SaveGameEvent(ctx context.Context, e *model.GameEvent) error {
+	tx, _ := TxFromContext(ctx)
+
+	e.Created = time.Now()
+	row := tx.QueryRow(createGameEventStmt, e.Created, e.Room, e.Type,
+		e.RoundID, e.ChallengeID, e.UserID, e.Seconds, e.Node)
+	return row.Scan(&e.ID)
+}
+
+// GetGameEvents returns a room's game events after the one with the ID
+// afterID, in the order they were written. An afterID of 0 returns the whole
+// log.
+func GetGameEvents(ctx context.Context, room string,
+	afterID int64) ([]*model.GameEvent, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getGameEventsStmt, room, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*model.GameEvent
+	for rows.Next() {
+		e := model.GameEvent{}
+		if err := rows.Scan(&e.ID, &e.Created, &e.Room, &e.Type, &e.RoundID,
+			&e.ChallengeID, &e.UserID, &e.Seconds, &e.Node); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// SaveGameSnapshot makes s its room's latest snapshot, unless the room
+// already has one as of a later event.
+func SaveGameSnapshot(ctx context.Context, s *model.GameSnapshot) error {
+	tx, _ := TxFromContext(ctx)
+
+	users, err := json.Marshal(s.Users)
+	if err != nil {
+		return err
+	}
+	solvers, err := json.Marshal(s.Solvers)
+	if err != nil {
+		return err
+	}
+	return upsert(tx, updateGameSnapshotStmt, createGameSnapshotStmt, s.Room,
+		s.EventID, s.RoundID, s.ChallengeID, s.IsBreak, s.Started, s.Seconds,
+		string(users), string(solvers))
+}
+
+// GetGameSnapshot returns a room's latest snapshot, or sql.ErrNoRows if it
+// doesn't have one yet.
+func GetGameSnapshot(ctx context.Context,
+	room string) (*model.GameSnapshot, error) {
+	tx, _ := TxFromContext(ctx)
+
+	var (
+		s       model.GameSnapshot
+		users   string
+		solvers string
+	)
+	if err := tx.QueryRow(getGameSnapshotStmt, room).Scan(&s.Room,
+		&s.EventID, &s.RoundID, &s.ChallengeID, &s.IsBreak, &s.Started,
+		&s.Seconds, &users, &solvers); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(users), &s.Users); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(solvers), &s.Solvers); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
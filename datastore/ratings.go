@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const claimRoundStmt = `UPDATE rounds SET rated=true WHERE id=$1 AND NOT
+rated`
+
+const ratingColumns = `user_id, lang, rating, deviation, volatility, rounds,
+updated`
+
+const getRatingStmt = `SELECT ` + ratingColumns + ` FROM ratings WHERE
+user_id=$1 AND lang=$2`
+
+const getUserRatingsStmt = `SELECT ` + ratingColumns + ` FROM ratings WHERE
+user_id=$1 ORDER BY lang`
+
+const getTopRatingsStmt = `SELECT ` + ratingColumns + ` FROM ratings WHERE
+lang=$1 ORDER BY rating DESC, user_id LIMIT $2`
+
+const updateRatingStmt = `UPDATE ratings SET rating=$3, deviation=$4,
+volatility=$5, rounds=$6, updated=$7 WHERE user_id=$1 AND lang=$2`
+
+const createRatingStmt = `INSERT INTO ratings (` + ratingColumns + `) VALUES
+($1, $2, $3, $4, $5, $6, $7)`
+
+const createRatingChangeStmt = `INSERT INTO rating_changes (user_id, lang,
+round_id, created, rating, deviation) VALUES ($1, $2, $3, $4, $5, $6)`
+
+const getRatingChangesStmt = `
+SELECT round_id, created, rating, deviation
+FROM rating_changes
+WHERE user_id=$1 AND lang=$2
+ORDER BY created, id
+`
+
+// ClaimRound marks a round as rated, reporting false if it already was.
+func ClaimRound(ctx context.Context, roundID int64) (bool, error) {
+	tx, _ := TxFromContext(ctx)
+
+	res, err := tx.Exec(claimRoundStmt, roundID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetRating returns a user's rating in a language, or their overall rating if
+// lang is empty. It returns sql.ErrNoRows if they don't have one yet.
+func GetRating(ctx context.Context, userID int64,
+	lang string) (*model.Rating, error) {
+	tx, _ := TxFromContext(ctx)
+	return scanRating(tx.QueryRow(getRatingStmt, userID, lang))
+}
+
+// GetUserRatings returns a user's overall rating and their rating in each
+// language they've used, overall first.
+func GetUserRatings(ctx context.Context,
+	userID int64) ([]*model.Rating, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getUserRatingsStmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+// GetTopRatings returns the highest ratings in a language, or the highest
+// overall ratings if lang is empty.
+func GetTopRatings(ctx context.Context, lang string,
+	limit int) ([]*model.Rating, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getTopRatingsStmt, lang, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+// SaveRating saves a user's rating after a round, and adds it to their
+// rating history.
+func SaveRating(ctx context.Context, r *model.Rating, roundID int64) error {
+	tx, _ := TxFromContext(ctx)
+
+	r.Updated = time.Now()
+	if err := upsert(tx, updateRatingStmt, createRatingStmt, r.UserID, r.Lang,
+		r.Rating, r.Deviation, r.Volatility, r.Rounds, r.Updated); err != nil {
+		return err
+	}
+	_, err := tx.Exec(createRatingChangeStmt, r.UserID, r.Lang, roundID,
+		r.Updated, r.Rating, r.Deviation)
+	return err
+}
+
+// GetRatingChanges returns a user's rating after each round they've been
+// rated in, oldest first. Their overall rating is returned if lang is empty.
+func GetRatingChanges(ctx context.Context, userID int64,
+	lang string) ([]*model.RatingChange, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getRatingChangesStmt, userID, lang)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []*model.RatingChange{}
+	for rows.Next() {
+		c := model.RatingChange{}
+		if err := rows.Scan(&c.RoundID, &c.Created, &c.Rating,
+			&c.Deviation); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+func scanRatings(rows *sql.Rows) ([]*model.Rating, error) {
+	defer rows.Close()
+	ratings := []*model.Rating{}
+	for rows.Next() {
+		r, err := scanRating(rows)
+		if err != nil {
+			return nil, err
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
+func scanRating(row scanner) (*model.Rating, error) {
+	r := model.Rating{}
+	if err := row.Scan(&r.UserID, &r.Lang, &r.Rating, &r.Deviation,
+		&r.Volatility, &r.Rounds, &r.Updated); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
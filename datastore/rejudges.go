@@ -0,0 +1,165 @@
+package datastore
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createRejudgeStmt = `INSERT INTO rejudges (created, challenge_id,
+requested_by, reason, only_stale, status) VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id`
+
+const rejudgeColumns = `id, created, challenge_id, requested_by, reason,
+only_stale, status, started, finished, submissions, changed, error`
+
+// claimRejudgeStmt takes the oldest pending rejudge. The status is checked
+// again outside the subquery so two nodes can't both claim it.
+const claimRejudgeStmt = `
+UPDATE rejudges SET status='running', started=$1
+WHERE status='pending' AND id=(
+  SELECT id FROM rejudges WHERE status='pending'
+  ORDER BY created, id
+  LIMIT 1
+  FOR UPDATE
+)
+RETURNING ` + rejudgeColumns
+
+const finishRejudgeStmt = `UPDATE rejudges SET status=$2, finished=$3,
+submissions=$4, changed=$5, error=$6 WHERE id=$1`
+
+const getRejudgeStmt = `SELECT ` + rejudgeColumns + ` FROM rejudges WHERE
+id=$1`
+
+const getChlngRejudgesStmt = `SELECT ` + rejudgeColumns + ` FROM rejudges
+WHERE challenge_id=$1 ORDER BY created DESC, id DESC`
+
+const createRejudgeResultStmt = `INSERT INTO rejudge_results (rejudge_id,
+submission_id, user_id, old_version, new_version, old_passed, new_passed,
+old_tests_passed, new_tests_passed, old_tests_total, new_tests_total,
+old_points, new_points) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+$12, $13) RETURNING id`
+
+const getRejudgeResultsStmt = `
+SELECT id, rejudge_id, submission_id, user_id, old_version, new_version,
+  old_passed, new_passed, old_tests_passed, new_tests_passed,
+  old_tests_total, new_tests_total, old_points, new_points
+FROM rejudge_results
+WHERE rejudge_id=$1
+ORDER BY id
+`
+
+// SaveRejudge inserts a new, pending rejudge.
+func SaveRejudge(ctx context.Context, r *model.Rejudge) error {
+	tx, _ := TxFromContext(ctx)
+
+	r.Created = time.Now()
+	r.Status = model.RejudgePending
+	var requestedBy sql.NullInt64
+	if r.RequestedBy != 0 {
+		requestedBy = sql.NullInt64{Int64: r.RequestedBy, Valid: true}
+	}
+	row := tx.QueryRow(createRejudgeStmt, r.Created, r.ChallengeID,
+		requestedBy, r.Reason, r.OnlyStale, r.Status)
+	return row.Scan(&r.ID)
+}
+
+// ClaimRejudge marks the oldest pending rejudge as running and returns it. It
+// returns sql.ErrNoRows if there are no pending rejudges.
+func ClaimRejudge(ctx context.Context) (*model.Rejudge, error) {
+	tx, _ := TxFromContext(ctx)
+	return scanRejudge(tx.QueryRow(claimRejudgeStmt, time.Now()))
+}
+
+// FinishRejudge saves the outcome of a rejudge that's stopped running.
+func FinishRejudge(ctx context.Context, r *model.Rejudge) error {
+	tx, _ := TxFromContext(ctx)
+
+	now := time.Now()
+	r.Finished = &now
+	_, err := tx.Exec(finishRejudgeStmt, r.ID, r.Status, r.Finished,
+		r.Submissions, r.Changed, r.Error)
+	return err
+}
+
+// GetRejudge returns a rejudge with its results.
+func GetRejudge(ctx context.Context, id int64) (*model.Rejudge, error) {
+	tx, _ := TxFromContext(ctx)
+
+	r, err := scanRejudge(tx.QueryRow(getRejudgeStmt, id))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(getRejudgeResultsStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	r.Results = []*model.RejudgeResult{}
+	for rows.Next() {
+		res := model.RejudgeResult{}
+		var oldVersion sql.NullInt64
+		if err := rows.Scan(&res.ID, &res.RejudgeID, &res.SubmissionID,
+			&res.UserID, &oldVersion, &res.NewVersion, &res.OldPassed,
+			&res.NewPassed, &res.OldTestsPassed, &res.NewTestsPassed,
+			&res.OldTestsTotal, &res.NewTestsTotal, &res.OldPoints,
+			&res.NewPoints); err != nil {
+			return nil, err
+		}
+		res.OldVersion = int(oldVersion.Int64)
+		r.Results = append(r.Results, &res)
+	}
+	return r, rows.Err()
+}
+
+// GetChallengeRejudges returns a challenge's rejudges, newest first, without
+// their results.
+func GetChallengeRejudges(ctx context.Context,
+	challengeID int64) ([]*model.Rejudge, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getChlngRejudgesStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rejudges := []*model.Rejudge{}
+	for rows.Next() {
+		r, err := scanRejudge(rows)
+		if err != nil {
+			return nil, err
+		}
+		rejudges = append(rejudges, r)
+	}
+	return rejudges, rows.Err()
+}
+
+// SaveRejudgeResult inserts the record of how a rejudge changed a
+// submission.
+func SaveRejudgeResult(ctx context.Context, res *model.RejudgeResult) error {
+	tx, _ := TxFromContext(ctx)
+
+	var oldVersion sql.NullInt64
+	if res.OldVersion != 0 {
+		oldVersion = sql.NullInt64{Int64: int64(res.OldVersion), Valid: true}
+	}
+	row := tx.QueryRow(createRejudgeResultStmt, res.RejudgeID,
+		res.SubmissionID, res.UserID, oldVersion, res.NewVersion,
+		res.OldPassed, res.NewPassed, res.OldTestsPassed, res.NewTestsPassed,
+		res.OldTestsTotal, res.NewTestsTotal, res.OldPoints, res.NewPoints)
+	return row.Scan(&res.ID)
+}
+
+func scanRejudge(row scanner) (*model.Rejudge, error) {
+	r := model.Rejudge{}
+	var requestedBy sql.NullInt64
+	if err := row.Scan(&r.ID, &r.Created, &r.ChallengeID, &requestedBy,
+		&r.Reason, &r.OnlyStale, &r.Status, &r.Started, &r.Finished,
+		&r.Submissions, &r.Changed, &r.Error); err != nil {
+		return nil, err
+	}
+	r.RequestedBy = requestedBy.Int64
+	return &r, nil
+}
@@ -0,0 +1,66 @@
+package datastore
+
+import (
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createReviewStmt = `INSERT INTO challenge_reviews (created,
+challenge_id, reviewer_id, approved, comment) VALUES ($1, $2, $3, $4, $5)
+RETURNING id`
+
+const getReviewsStmt = `
+SELECT id, created, challenge_id, reviewer_id, approved, comment
+FROM challenge_reviews
+WHERE challenge_id=$1
+ORDER BY created, id
+`
+
+const setChlngStatusStmt = `UPDATE challenges SET status=$2, updated=$3
+WHERE id=$1`
+
+// SaveReview inserts a new review. Reviews are never updated once written.
+func SaveReview(ctx context.Context, r *model.Review) error {
+	tx, _ := TxFromContext(ctx)
+
+	r.Created = time.Now()
+	row := tx.QueryRow(createReviewStmt, r.Created, r.ChallengeID,
+		r.ReviewerID, r.Approved, r.Comment)
+	return row.Scan(&r.ID)
+}
+
+// GetReviews returns a challenge's reviews, oldest first.
+func GetReviews(ctx context.Context, challengeID int64) ([]*model.Review,
+	error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getReviewsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reviews := []*model.Review{}
+	for rows.Next() {
+		r := model.Review{}
+		if err := rows.Scan(&r.ID, &r.Created, &r.ChallengeID, &r.ReviewerID,
+			&r.Approved, &r.Comment); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, &r)
+	}
+	return reviews, rows.Err()
+}
+
+// SetChallengeStatus moves a challenge to a new status without touching the
+// rest of it.
+func SetChallengeStatus(ctx context.Context, c *model.Challenge,
+	status string) error {
+	tx, _ := TxFromContext(ctx)
+
+	c.Status = status
+	c.Updated = time.Now()
+	_, err := tx.Exec(setChlngStatusStmt, c.ID, c.Status, c.Updated)
+	return err
+}
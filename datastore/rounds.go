@@ -0,0 +1,110 @@
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createRoundStmt = `INSERT INTO rounds (created, updated, room,
+challenge_id) VALUES ($1, $2, $3, $4) RETURNING id`
+
+const getRoundStmt = `SELECT id, created, updated, room, challenge_id FROM
+rounds WHERE id=$1`
+
+const createRoundEventsStmt = `INSERT INTO round_events (created, round_id,
+recipient_id, body) VALUES %s`
+
+const getRoundEventsStmt = `
+SELECT id, created, round_id, recipient_id, body
+FROM round_events
+WHERE round_id=$1 AND (recipient_id IS NULL OR recipient_id=$2)
+ORDER BY created, id
+`
+
+func SaveRound(ctx context.Context, r *model.Round) error {
+	tx, _ := TxFromContext(ctx)
+
+	r.Created = time.Now()
+	r.Updated = r.Created
+
+	row := tx.QueryRow(createRoundStmt, r.Created, r.Updated, r.Room,
+		r.ChallengeID)
+	return row.Scan(&r.ID)
+}
+
+func GetRound(ctx context.Context, id int64) (*model.Round, error) {
+	tx, _ := TxFromContext(ctx)
+
+	r := model.Round{}
+	row := tx.QueryRow(getRoundStmt, id)
+	if err := row.Scan(&r.ID, &r.Created, &r.Updated, &r.Room,
+		&r.ChallengeID); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SaveRoundEvents appends events to their rounds' logs with a single insert.
+// Their IDs aren't filled in. Round events are never updated once written.
+func SaveRoundEvents(ctx context.Context, events []*model.RoundEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	tx, _ := TxFromContext(ctx)
+
+	rows := make([]string, len(events))
+	args := make([]interface{}, 0, 4*len(events))
+	for i, e := range events {
+		if e.Created.IsZero() {
+			e.Created = time.Now()
+		}
+		var recipient sql.NullInt64
+		if e.RecipientID != 0 {
+			recipient = sql.NullInt64{Int64: e.RecipientID, Valid: true}
+		}
+		n := len(args)
+		rows[i] = fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+		args = append(args, e.Created, e.RoundID, recipient, string(e.Event))
+	}
+	_, err := tx.Exec(fmt.Sprintf(createRoundEventsStmt,
+		strings.Join(rows, ", ")), args...)
+	return err
+}
+
+// GetRoundEvents returns the broadcast events for a round in the order they
+// were sent, along with any events sent privately to recipientID.
+func GetRoundEvents(ctx context.Context, roundID,
+	recipientID int64) ([]*model.RoundEvent, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getRoundEventsStmt, roundID, recipientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*model.RoundEvent
+	for rows.Next() {
+		var (
+			e         model.RoundEvent
+			recipient sql.NullInt64
+			body      string
+		)
+		if err := rows.Scan(&e.ID, &e.Created, &e.RoundID, &recipient,
+			&body); err != nil {
+			return nil, err
+		}
+		e.RecipientID = recipient.Int64
+		e.Event = []byte(body)
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
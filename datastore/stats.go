@@ -0,0 +1,257 @@
+package datastore
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const updateStatsStmt = `UPDATE challenge_stats SET updated=$2,
+submissions=submissions+1, attempts=attempts+$3, solves=solves+$4 WHERE
+challenge_id=$1`
+
+const createStatsStmt = `INSERT INTO challenge_stats (challenge_id, updated,
+submissions, attempts, solves) VALUES ($1, $2, 1, $3, $4)`
+
+const updateLangStatsStmt = `UPDATE challenge_lang_stats SET
+submissions=submissions+1, solves=solves+$3 WHERE challenge_id=$1 AND
+lang=$2`
+
+const createLangStatsStmt = `INSERT INTO challenge_lang_stats (challenge_id,
+lang, submissions, solves) VALUES ($1, $2, 1, $3)`
+
+const updateTestFailuresStmt = `UPDATE challenge_test_failures SET
+failures=failures+1 WHERE challenge_id=$1 AND test_case_id=$2`
+
+const createTestFailuresStmt = `INSERT INTO challenge_test_failures
+(challenge_id, test_case_id, failures) VALUES ($1, $2, 1)`
+
+// solveTimesStmt is completed with a condition on the submissions, s, whose
+// solve times should be saved.
+const solveTimesStmt = `
+INSERT INTO challenge_solve_times (submission_id, challenge_id, seconds)
+SELECT s.id, s.challenge_id, extract(epoch FROM s.created - r.created)
+FROM submissions s
+JOIN rounds r ON r.id = s.round_id
+WHERE `
+
+const createSolveTimeStmt = solveTimesStmt + `s.id=$1`
+
+const rebuildStatsStmt = `
+INSERT INTO challenge_stats (challenge_id, updated, submissions, attempts,
+  solves)
+SELECT $1, $2, count(*), count(DISTINCT (round_id, user_id)),
+  coalesce(sum(CASE WHEN points > 0 THEN 1 ELSE 0 END), 0)
+FROM submissions
+WHERE challenge_id=$1
+`
+
+const rebuildLangStatsStmt = `
+INSERT INTO challenge_lang_stats (challenge_id, lang, submissions, solves)
+SELECT challenge_id, lang, count(*),
+  sum(CASE WHEN points > 0 THEN 1 ELSE 0 END)
+FROM submissions
+WHERE challenge_id=$1
+GROUP BY challenge_id, lang
+`
+
+const rebuildTestFailuresStmt = `
+INSERT INTO challenge_test_failures (challenge_id, test_case_id, failures)
+SELECT challenge_id, failed_test_id, count(*)
+FROM submissions
+WHERE challenge_id=$1 AND failed_test_id IS NOT NULL
+GROUP BY challenge_id, failed_test_id
+`
+
+const rebuildSolveTimesStmt = solveTimesStmt +
+	`s.challenge_id=$1 AND s.points > 0`
+
+var clearStatsStmts = []string{
+	`DELETE FROM challenge_stats WHERE challenge_id=$1`,
+	`DELETE FROM challenge_lang_stats WHERE challenge_id=$1`,
+	`DELETE FROM challenge_test_failures WHERE challenge_id=$1`,
+	`DELETE FROM challenge_solve_times WHERE challenge_id=$1`,
+}
+
+const getStatsStmt = `SELECT updated, submissions, attempts, solves FROM
+challenge_stats WHERE challenge_id=$1`
+
+const getMedianSolveStmt = `
+SELECT avg(seconds)
+FROM (
+  SELECT seconds
+  FROM challenge_solve_times
+  WHERE challenge_id=$1
+  ORDER BY seconds
+  OFFSET (SELECT (count(*) - 1) / 2 FROM challenge_solve_times
+    WHERE challenge_id=$1)
+  LIMIT 2 - (SELECT count(*) % 2 FROM challenge_solve_times
+    WHERE challenge_id=$1)
+) middle
+`
+
+const getLangStatsStmt = `
+SELECT lang, submissions, solves
+FROM challenge_lang_stats
+WHERE challenge_id=$1
+ORDER BY submissions DESC, lang
+`
+
+// getMostFailedTestStmt leaves out test cases that have since been removed.
+const getMostFailedTestStmt = `
+SELECT f.test_case_id, f.failures
+FROM challenge_test_failures f
+JOIN challenge_test_cases t ON t.id = f.test_case_id
+WHERE f.challenge_id=$1
+ORDER BY f.failures DESC, f.test_case_id
+LIMIT 1
+`
+
+// AddSubmissionStats adds a newly graded submission to its challenge's
+// stats. firstAttempt is whether it's the player's first submission in its
+// round.
+//
+// The challenge's row in challenge_stats is updated first, which keeps
+// anything else from changing its stats until the transaction is done. Two
+// transactions adding a challenge's first submission at once can still
+// conflict, in which case one fails and should be retried.
+func AddSubmissionStats(ctx context.Context, s *model.Submission,
+	firstAttempt bool) error {
+	tx, _ := TxFromContext(ctx)
+
+	attempts, solves := 0, 0
+	if firstAttempt {
+		attempts = 1
+	}
+	if s.Points > 0 {
+		solves = 1
+	}
+
+	if err := upsert(tx, updateStatsStmt, createStatsStmt, s.ChallengeID,
+		time.Now(), attempts, solves); err != nil {
+		return err
+	}
+	if err := upsert(tx, updateLangStatsStmt, createLangStatsStmt,
+		s.ChallengeID, s.Lang, solves); err != nil {
+		return err
+	}
+	if s.FailedTestID != 0 {
+		if err := upsert(tx, updateTestFailuresStmt, createTestFailuresStmt,
+			s.ChallengeID, s.FailedTestID); err != nil {
+			return err
+		}
+	}
+	if s.Points > 0 {
+		if _, err := tx.Exec(createSolveTimeStmt, s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildChallengeStats works out a challenge's stats again from all of its
+// submissions, for when they've changed, like after a rejudge.
+func RebuildChallengeStats(ctx context.Context, challengeID int64) error {
+	tx, _ := TxFromContext(ctx)
+
+	for _, stmt := range clearStatsStmts {
+		if _, err := tx.Exec(stmt, challengeID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(rebuildStatsStmt, challengeID,
+		time.Now()); err != nil {
+		return err
+	}
+	for _, stmt := range []string{rebuildLangStatsStmt,
+		rebuildTestFailuresStmt, rebuildSolveTimesStmt} {
+		if _, err := tx.Exec(stmt, challengeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetChallengeStats returns a challenge's stats. Challenges no one has
+// submitted a solution to yet have empty stats.
+func GetChallengeStats(ctx context.Context,
+	challengeID int64) (*model.ChallengeStats, error) {
+	tx, _ := TxFromContext(ctx)
+
+	stats := &model.ChallengeStats{
+		ChallengeID: challengeID,
+		Languages:   []*model.LangStats{},
+	}
+	var updated time.Time
+	err := tx.QueryRow(getStatsStmt, challengeID).Scan(&updated,
+		&stats.Submissions, &stats.Attempts, &stats.Solves)
+	if err == sql.ErrNoRows {
+		return stats, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stats.Updated = &updated
+	if stats.Attempts > 0 {
+		stats.SolveRate = float64(stats.Solves) / float64(stats.Attempts)
+	}
+
+	var median sql.NullFloat64
+	if err := tx.QueryRow(getMedianSolveStmt, challengeID).Scan(
+		&median); err != nil {
+		return nil, err
+	}
+	if median.Valid {
+		stats.MedianSolveSeconds = &median.Float64
+	}
+
+	rows, err := tx.Query(getLangStatsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		l := model.LangStats{}
+		if err := rows.Scan(&l.Lang, &l.Submissions, &l.Solves); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.Languages = append(stats.Languages, &l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	f := model.TestFailures{}
+	err = tx.QueryRow(getMostFailedTestStmt, challengeID).Scan(&f.TestCaseID,
+		&f.Failures)
+	if err == nil {
+		stats.MostFailedTest = &f
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	cal, err := GetCalibration(ctx, challengeID)
+	if err == nil {
+		stats.Calibration = cal
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// upsert runs update, and then insert if update didn't change any rows. Both
+// are given args.
+func upsert(tx *sql.Tx, update, insert string, args ...interface{}) error {
+	res, err := tx.Exec(update, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = tx.Exec(insert, args...)
+	return err
+}
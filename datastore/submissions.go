@@ -0,0 +1,118 @@
+package datastore
+
+import (
+	"database/sql"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createSubmissionStmt = `INSERT INTO submissions (created, user_id,
+round_id, challenge_id, room, lang, code, passed, tests_passed, tests_total,
+points, challenge_version, failed_test_id) VALUES ($1, $2, $3, $4, $5, $6, $7,
+$8, $9, $10, $11, $12, $13) RETURNING id`
+
+const getSubmissionCountsStmt = `
+SELECT
+  coalesce(sum(CASE WHEN passed THEN 1 ELSE 0 END), 0),
+  coalesce(sum(CASE WHEN passed THEN 0 ELSE 1 END), 0)
+FROM submissions
+WHERE round_id=$1 AND user_id=$2
+`
+
+const submissionColumns = `id, created, user_id, round_id, challenge_id,
+room, lang, code, passed, tests_passed, tests_total, points,
+challenge_version, failed_test_id`
+
+const getChlngSubmissionsStmt = `SELECT ` + submissionColumns + `
+FROM submissions
+WHERE challenge_id=$1
+ORDER BY round_id, user_id, created, id`
+
+const countChlngSubmissionsStmt = `SELECT count(*) FROM submissions WHERE
+challenge_id=$1`
+
+const updateSubmissionResultStmt = `UPDATE submissions SET passed=$2,
+tests_passed=$3, tests_total=$4, points=$5, challenge_version=$6,
+failed_test_id=$7 WHERE id=$1`
+
+// SaveSubmission inserts a new submission. Only rejudges change submissions
+// once they're written, see UpdateSubmissionResult.
+func SaveSubmission(ctx context.Context, s *model.Submission) error {
+	tx, _ := TxFromContext(ctx)
+
+	s.Created = time.Now()
+
+	var failedTestID sql.NullInt64
+	if s.FailedTestID != 0 {
+		failedTestID = sql.NullInt64{Int64: s.FailedTestID, Valid: true}
+	}
+
+	row := tx.QueryRow(createSubmissionStmt, s.Created, s.UserID, s.RoundID,
+		s.ChallengeID, s.Room, s.Lang, s.Code, s.Passed, s.TestsPassed,
+		s.TestsTotal, s.Points, s.ChallengeVersion, failedTestID)
+	return row.Scan(&s.ID)
+}
+
+// GetSubmissionCounts returns how many of a user's submissions in a round
+// passed and failed.
+func GetSubmissionCounts(ctx context.Context, roundID,
+	userID int64) (passed, failed int, err error) {
+	tx, _ := TxFromContext(ctx)
+	row := tx.QueryRow(getSubmissionCountsStmt, roundID, userID)
+	err = row.Scan(&passed, &failed)
+	return passed, failed, err
+}
+
+// GetChallengeSubmissions returns every submission for a challenge, grouped
+// by round and then by user, oldest first within each group.
+func GetChallengeSubmissions(ctx context.Context,
+	challengeID int64) ([]*model.Submission, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getChlngSubmissionsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	submissions := []*model.Submission{}
+	for rows.Next() {
+		s := model.Submission{}
+		var version, failedTestID sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.Created, &s.UserID, &s.RoundID,
+			&s.ChallengeID, &s.Room, &s.Lang, &s.Code, &s.Passed,
+			&s.TestsPassed, &s.TestsTotal, &s.Points, &version,
+			&failedTestID); err != nil {
+			return nil, err
+		}
+		s.ChallengeVersion = int(version.Int64)
+		s.FailedTestID = failedTestID.Int64
+		submissions = append(submissions, &s)
+	}
+	return submissions, rows.Err()
+}
+
+// CountChallengeSubmissions returns how many submissions there are for a
+// challenge.
+func CountChallengeSubmissions(ctx context.Context,
+	challengeID int64) (int, error) {
+	tx, _ := TxFromContext(ctx)
+	var n int
+	err := tx.QueryRow(countChlngSubmissionsStmt, challengeID).Scan(&n)
+	return n, err
+}
+
+// UpdateSubmissionResult saves a submission's new verdict and score after
+// it's been rejudged.
+func UpdateSubmissionResult(ctx context.Context, s *model.Submission) error {
+	tx, _ := TxFromContext(ctx)
+	var failedTestID sql.NullInt64
+	if s.FailedTestID != 0 {
+		failedTestID = sql.NullInt64{Int64: s.FailedTestID, Valid: true}
+	}
+	_, err := tx.Exec(updateSubmissionResultStmt, s.ID, s.Passed,
+		s.TestsPassed, s.TestsTotal, s.Points, s.ChallengeVersion,
+		failedTestID)
+	return err
+}
@@ -9,19 +9,22 @@ import (
 )
 
 const createUserStmt = `INSERT INTO users (created, updated, username,
-profile_picture, github_id, github_url, access_token, score) VALUES ($1, $2,
-$3, $4, $5, $6, $7, $8) RETURNING id`
+profile_picture, github_id, github_url, access_token, score, role) VALUES ($1,
+$2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
 
 const getUserStmt = `SELECT id, created, updated, username, profile_picture,
-github_id, github_url, access_token, score FROM users WHERE id=$1`
+github_id, github_url, access_token, score, role FROM users WHERE id=$1`
 
 const getUserByGitHubIDStmt = `SELECT id, created, updated, username,
-profile_picture, github_id, github_url, access_token, score FROM users WHERE
-github_id=$1`
+profile_picture, github_id, github_url, access_token, score, role FROM users
+WHERE github_id=$1`
 
 const updateUserStmt = `UPDATE users SET updated=$2, username=$3,
-profile_picture=$4, github_id=$5, github_url=$6, access_token=$7, score=$8
-WHERE id=$1`
+profile_picture=$4, github_id=$5, github_url=$6, access_token=$7, score=$8,
+role=$9 WHERE id=$1`
+
+const addUserScoreStmt = `UPDATE users SET score=score+$2, updated=$3 WHERE
+id=$1`
 
 func SaveUser(ctx context.Context, u *model.User) error {
 	tx, _ := TxFromContext(ctx)
@@ -32,9 +35,12 @@ func SaveUser(ctx context.Context, u *model.User) error {
 		newUser = true
 	}
 	u.Updated = time.Now()
+	if u.Role == "" {
+		u.Role = model.RolePlayer
+	}
 	if newUser {
 		rows, err := tx.Query(createUserStmt, u.Created, u.Updated, u.Username,
-			u.ProfilePicture, u.GitHubID, u.GitHubURL, u.AccessToken, u.Score)
+			u.ProfilePicture, u.GitHubID, u.GitHubURL, u.AccessToken, u.Score, u.Role)
 		if err != nil {
 			return err
 		}
@@ -49,13 +55,20 @@ func SaveUser(ctx context.Context, u *model.User) error {
 	} else {
 		if _, err := tx.Exec(updateUserStmt, u.ID, u.Updated, u.Username,
 			u.ProfilePicture, u.GitHubID, u.GitHubURL, u.AccessToken,
-			u.Score); err != nil {
+			u.Score, u.Role); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// AddUserScore adds points to the user's score.
+func AddUserScore(ctx context.Context, userID int64, points int) error {
+	tx, _ := TxFromContext(ctx)
+	_, err := tx.Exec(addUserScoreStmt, userID, points, time.Now())
+	return err
+}
+
 func GetUser(ctx context.Context, id int64) (*model.User, error) {
 	return getUser(ctx, getUserStmt, id)
 }
@@ -70,7 +83,7 @@ func getUser(ctx context.Context, stmt string, id int64) (*model.User, error) {
 	row := tx.QueryRow(stmt, id)
 	if err := row.Scan(&u.ID, &u.Created, &u.Updated, &u.Username,
 		&u.ProfilePicture, &u.GitHubID, &u.GitHubURL, &u.AccessToken,
-		&u.Score); err != nil {
+		&u.Score, &u.Role); err != nil {
 		return nil, err
 	}
 	return &u, nil
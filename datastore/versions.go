@@ -0,0 +1,130 @@
+package datastore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/zachlatta/calhacks/model"
+)
+
+const createVersionStmt = `INSERT INTO challenge_versions (challenge_id,
+version, created, editor_id, title, description, time_limit, checker_lang,
+checker_code, test_cases) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id`
+
+const setChlngVersionStmt = `UPDATE challenges SET version=$2 WHERE id=$1`
+
+const versionColumns = `id, challenge_id, version, created, editor_id, title,
+description, time_limit, checker_lang, checker_code, test_cases`
+
+const getVersionsStmt = `SELECT ` + versionColumns + ` FROM
+challenge_versions WHERE challenge_id=$1 ORDER BY version`
+
+const getVersionStmt = `SELECT ` + versionColumns + ` FROM
+challenge_versions WHERE challenge_id=$1 AND version=$2`
+
+const getLatestVersionStmt = `SELECT ` + versionColumns + ` FROM
+challenge_versions WHERE challenge_id=$1 ORDER BY version DESC LIMIT 1`
+
+// saveVersion makes a new version of c if it's changed since its latest one,
+// and sets c.Version to the number of the version it's now at. The version
+// is credited to the user in ctx, if there is one.
+func saveVersion(ctx context.Context, c *model.Challenge) error {
+	tx, _ := TxFromContext(ctx)
+
+	v := model.VersionOf(c)
+	latest, err := scanVersion(tx.QueryRow(getLatestVersionStmt, c.ID))
+	switch {
+	case err == sql.ErrNoRows:
+		v.Version = 1
+	case err != nil:
+		return err
+	case latest.SameAs(v):
+		c.Version = latest.Version
+		return nil
+	default:
+		v.Version = latest.Version + 1
+	}
+
+	v.Created = time.Now()
+	var editorID sql.NullInt64
+	if user, ok := UserFromContext(ctx); ok {
+		v.EditorID = user.ID
+		editorID = sql.NullInt64{Int64: user.ID, Valid: true}
+	}
+	var checkerLang, checkerCode sql.NullString
+	if v.Checker != nil {
+		checkerLang = sql.NullString{String: v.Checker.Lang, Valid: true}
+		checkerCode = sql.NullString{String: v.Checker.Code, Valid: true}
+	}
+	tcs, err := json.Marshal(v.TestCases)
+	if err != nil {
+		return err
+	}
+	row := tx.QueryRow(createVersionStmt, v.ChallengeID, v.Version, v.Created,
+		editorID, v.Title, v.Description, v.TimeLimit, checkerLang,
+		checkerCode, string(tcs))
+	if err := row.Scan(&v.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(setChlngVersionStmt, c.ID, v.Version); err != nil {
+		return err
+	}
+	c.Version = v.Version
+	return nil
+}
+
+// GetChallengeVersions returns every version of a challenge, oldest first.
+func GetChallengeVersions(ctx context.Context,
+	challengeID int64) ([]*model.ChallengeVersion, error) {
+	tx, _ := TxFromContext(ctx)
+
+	rows, err := tx.Query(getVersionsStmt, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []*model.ChallengeVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetChallengeVersion returns one version of a challenge.
+func GetChallengeVersion(ctx context.Context, challengeID int64,
+	version int) (*model.ChallengeVersion, error) {
+	tx, _ := TxFromContext(ctx)
+	return scanVersion(tx.QueryRow(getVersionStmt, challengeID, version))
+}
+
+func scanVersion(row scanner) (*model.ChallengeVersion, error) {
+	v := model.ChallengeVersion{}
+	var (
+		editorID                 sql.NullInt64
+		checkerLang, checkerCode sql.NullString
+		tcs                      []byte
+	)
+	if err := row.Scan(&v.ID, &v.ChallengeID, &v.Version, &v.Created,
+		&editorID, &v.Title, &v.Description, &v.TimeLimit, &checkerLang,
+		&checkerCode, &tcs); err != nil {
+		return nil, err
+	}
+	v.EditorID = editorID.Int64
+	if checkerLang.Valid {
+		v.Checker = &model.Checker{
+			Lang: checkerLang.String,
+			Code: checkerCode.String,
+		}
+	}
+	if err := json.Unmarshal(tcs, &v.TestCases); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
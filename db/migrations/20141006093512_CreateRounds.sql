@@ -0,0 +1,23 @@
+
+-- +goose Up
+CREATE TABLE rounds (
+  id serial not null primary key,
+  created timestamp not null,
+  updated timestamp not null,
+  challenge_id integer references challenges(id) not null
+);
+
+CREATE TABLE round_events (
+  id serial not null primary key,
+  created timestamp not null,
+  round_id integer references rounds(id) not null,
+  recipient_id integer references users(id),
+  body text not null
+);
+
+CREATE INDEX round_events_round_id_idx ON round_events (round_id, created);
+
+
+-- +goose Down
+DROP TABLE round_events;
+DROP TABLE rounds;
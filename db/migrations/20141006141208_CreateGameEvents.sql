@@ -0,0 +1,15 @@
+
+-- +goose Up
+CREATE TABLE game_events (
+  id serial not null primary key,
+  created timestamp not null,
+  type text not null,
+  round_id integer not null default 0,
+  challenge_id integer not null default 0,
+  user_id integer not null default 0,
+  seconds integer not null default 0
+);
+
+
+-- +goose Down
+DROP TABLE game_events;
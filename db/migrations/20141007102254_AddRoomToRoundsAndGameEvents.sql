@@ -0,0 +1,21 @@
+
+-- +goose Up
+ALTER TABLE rounds
+  ADD COLUMN room text not null default 'main';
+
+ALTER TABLE game_events
+  ADD COLUMN room text not null default 'main',
+  ADD COLUMN node text not null default '';
+
+CREATE INDEX game_events_room_idx ON game_events (room, id);
+
+
+-- +goose Down
+DROP INDEX game_events_room_idx;
+
+ALTER TABLE game_events
+  DROP COLUMN node,
+  DROP COLUMN room;
+
+ALTER TABLE rounds
+  DROP COLUMN room;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+ALTER TABLE users
+  ADD COLUMN role text not null default 'player';
+
+
+-- +goose Down
+ALTER TABLE users
+  DROP COLUMN role;
@@ -0,0 +1,31 @@
+
+-- +goose Up
+ALTER TABLE challenge_test_cases
+  ADD COLUMN input text not null default '',
+  ADD COLUMN expected_output text not null default '',
+  ADD COLUMN sample boolean not null default false;
+
+-- Test cases had no contents until now, so they're replaced by a hidden test
+-- case for each challenge's expected output.
+DELETE FROM challenge_test_cases;
+
+INSERT INTO challenge_test_cases (created, updated, challenge_id,
+  expected_output)
+  SELECT created, updated, id, expected_output FROM challenges;
+
+ALTER TABLE challenges
+  DROP COLUMN expected_output;
+
+
+-- +goose Down
+ALTER TABLE challenges
+  ADD COLUMN expected_output text not null default '';
+
+UPDATE challenges SET expected_output = t.expected_output
+  FROM challenge_test_cases t
+  WHERE t.challenge_id = challenges.id AND NOT t.sample;
+
+ALTER TABLE challenge_test_cases
+  DROP COLUMN sample,
+  DROP COLUMN expected_output,
+  DROP COLUMN input;
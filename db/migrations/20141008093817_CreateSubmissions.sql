@@ -0,0 +1,23 @@
+
+-- +goose Up
+CREATE TABLE submissions (
+  id serial not null primary key,
+  created timestamp not null,
+  user_id integer references users(id) not null,
+  round_id integer references rounds(id) not null,
+  challenge_id integer references challenges(id) not null,
+  room text not null,
+  lang text not null,
+  code text not null,
+  passed boolean not null,
+  tests_passed integer not null,
+  tests_total integer not null,
+  points integer not null
+);
+
+CREATE INDEX submissions_round_id_user_id_idx ON submissions (round_id,
+  user_id);
+
+
+-- +goose Down
+DROP TABLE submissions;
@@ -0,0 +1,11 @@
+
+-- +goose Up
+ALTER TABLE challenges
+  ADD COLUMN author_id integer references users(id),
+  ADD COLUMN deleted timestamp;
+
+
+-- +goose Down
+ALTER TABLE challenges
+  DROP COLUMN deleted,
+  DROP COLUMN author_id;
@@ -0,0 +1,21 @@
+
+-- +goose Up
+ALTER TABLE challenges
+  ADD COLUMN time_limit integer not null default 2000;
+
+CREATE TABLE challenge_solutions (
+  id serial not null primary key,
+  created timestamp not null,
+  updated timestamp not null,
+  challenge_id integer references challenges(id) not null,
+  lang text not null,
+  code text not null,
+  correct boolean not null
+);
+
+
+-- +goose Down
+DROP TABLE challenge_solutions;
+
+ALTER TABLE challenges
+  DROP COLUMN time_limit;
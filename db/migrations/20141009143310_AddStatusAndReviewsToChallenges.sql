@@ -0,0 +1,26 @@
+
+-- +goose Up
+ALTER TABLE challenges
+  ADD COLUMN status text not null default 'draft';
+
+-- Every challenge was in rotation before there was a review process.
+UPDATE challenges SET status = 'published';
+
+CREATE TABLE challenge_reviews (
+  id serial not null primary key,
+  created timestamp not null,
+  challenge_id integer references challenges(id) not null,
+  reviewer_id integer references users(id) not null,
+  approved boolean not null,
+  comment text not null
+);
+
+CREATE INDEX challenge_reviews_challenge_id_idx ON challenge_reviews
+  (challenge_id, created);
+
+
+-- +goose Down
+DROP TABLE challenge_reviews;
+
+ALTER TABLE challenges
+  DROP COLUMN status;
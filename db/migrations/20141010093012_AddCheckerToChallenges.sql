@@ -0,0 +1,11 @@
+
+-- +goose Up
+ALTER TABLE challenges
+  ADD COLUMN checker_lang text,
+  ADD COLUMN checker_code text;
+
+
+-- +goose Down
+ALTER TABLE challenges
+  DROP COLUMN checker_lang,
+  DROP COLUMN checker_code;
@@ -0,0 +1,33 @@
+
+-- +goose Up
+ALTER TABLE challenges
+  ADD COLUMN difficulty text;
+
+CREATE TABLE challenge_tags (
+  challenge_id integer references challenges(id) not null,
+  tag text not null,
+  primary key (challenge_id, tag)
+);
+
+CREATE INDEX challenge_tags_tag_idx ON challenge_tags (tag);
+
+CREATE TABLE challenge_sources (
+  challenge_id integer references challenges(id) not null primary key,
+  author text not null,
+  source text not null,
+  url text not null
+);
+
+CREATE INDEX challenges_search_idx ON challenges
+  USING gin(to_tsvector('english', title || ' ' || description));
+
+
+-- +goose Down
+DROP INDEX challenges_search_idx;
+
+DROP TABLE challenge_sources;
+
+DROP TABLE challenge_tags;
+
+ALTER TABLE challenges
+  DROP COLUMN difficulty;
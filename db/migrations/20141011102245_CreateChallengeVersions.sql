@@ -0,0 +1,48 @@
+
+-- +goose Up
+CREATE TABLE challenge_versions (
+  id serial not null primary key,
+  challenge_id integer references challenges(id) not null,
+  version integer not null,
+  created timestamp not null,
+  editor_id integer references users(id),
+  title text not null,
+  description text not null,
+  time_limit integer not null,
+  checker_lang text,
+  checker_code text,
+  test_cases json not null,
+  unique (challenge_id, version)
+);
+
+ALTER TABLE challenges
+  ADD COLUMN version integer not null default 1;
+
+ALTER TABLE submissions
+  ADD COLUMN challenge_version integer;
+
+-- Challenges as they are now become their first version.
+INSERT INTO challenge_versions (challenge_id, version, created, editor_id,
+  title, description, time_limit, checker_lang, checker_code, test_cases)
+SELECT c.id, 1, c.updated, c.author_id, c.title, c.description, c.time_limit,
+  c.checker_lang, c.checker_code, coalesce((
+    SELECT array_to_json(array_agg(row_to_json(t) ORDER BY t.id))
+    FROM (
+      SELECT id, created, updated, input, expected_output, sample
+      FROM challenge_test_cases
+      WHERE challenge_id = c.id
+    ) t
+  ), '[]')
+FROM challenges c;
+
+UPDATE submissions SET challenge_version = 1;
+
+
+-- +goose Down
+ALTER TABLE submissions
+  DROP COLUMN challenge_version;
+
+ALTER TABLE challenges
+  DROP COLUMN version;
+
+DROP TABLE challenge_versions;
@@ -0,0 +1,49 @@
+
+-- +goose Up
+CREATE TABLE rejudges (
+  id serial not null primary key,
+  created timestamp not null,
+  challenge_id integer references challenges(id) not null,
+  requested_by integer references users(id),
+  reason text not null,
+  only_stale boolean not null,
+  status text not null,
+  started timestamp,
+  finished timestamp,
+  submissions integer not null default 0,
+  changed integer not null default 0,
+  error text not null default ''
+);
+
+CREATE INDEX rejudges_status_idx ON rejudges (status, created);
+CREATE INDEX rejudges_challenge_id_idx ON rejudges (challenge_id, created);
+
+CREATE TABLE rejudge_results (
+  id serial not null primary key,
+  rejudge_id integer references rejudges(id) not null,
+  submission_id integer references submissions(id) not null,
+  user_id integer references users(id) not null,
+  old_version integer,
+  new_version integer not null,
+  old_passed boolean not null,
+  new_passed boolean not null,
+  old_tests_passed integer not null,
+  new_tests_passed integer not null,
+  old_tests_total integer not null,
+  new_tests_total integer not null,
+  old_points integer not null,
+  new_points integer not null
+);
+
+CREATE INDEX rejudge_results_rejudge_id_idx ON rejudge_results (rejudge_id);
+
+CREATE INDEX submissions_challenge_id_idx ON submissions
+  (challenge_id, round_id, user_id, created);
+
+
+-- +goose Down
+DROP INDEX submissions_challenge_id_idx;
+
+DROP TABLE rejudge_results;
+
+DROP TABLE rejudges;
@@ -0,0 +1,72 @@
+
+-- +goose Up
+ALTER TABLE submissions
+  ADD COLUMN failed_test_id integer;
+
+CREATE TABLE challenge_stats (
+  challenge_id integer references challenges(id) not null primary key,
+  updated timestamp not null,
+  submissions integer not null,
+  attempts integer not null,
+  solves integer not null
+);
+
+CREATE TABLE challenge_lang_stats (
+  challenge_id integer references challenges(id) not null,
+  lang text not null,
+  submissions integer not null,
+  solves integer not null,
+  primary key (challenge_id, lang)
+);
+
+-- Test cases can be removed from a challenge after they've been failed, so
+-- test_case_id doesn't reference them.
+CREATE TABLE challenge_test_failures (
+  challenge_id integer references challenges(id) not null,
+  test_case_id integer not null,
+  failures integer not null,
+  primary key (challenge_id, test_case_id)
+);
+
+CREATE TABLE challenge_solve_times (
+  submission_id integer references submissions(id) not null primary key,
+  challenge_id integer references challenges(id) not null,
+  seconds integer not null
+);
+
+CREATE INDEX challenge_solve_times_challenge_id_idx ON challenge_solve_times
+  (challenge_id, seconds);
+
+-- Work out the stats of the submissions made so far. Which test they failed
+-- wasn't recorded, so there are no test failures yet.
+INSERT INTO challenge_stats (challenge_id, updated, submissions, attempts,
+  solves)
+SELECT challenge_id, now(), count(*), count(DISTINCT (round_id, user_id)),
+  sum(CASE WHEN points > 0 THEN 1 ELSE 0 END)
+FROM submissions
+GROUP BY challenge_id;
+
+INSERT INTO challenge_lang_stats (challenge_id, lang, submissions, solves)
+SELECT challenge_id, lang, count(*),
+  sum(CASE WHEN points > 0 THEN 1 ELSE 0 END)
+FROM submissions
+GROUP BY challenge_id, lang;
+
+INSERT INTO challenge_solve_times (submission_id, challenge_id, seconds)
+SELECT s.id, s.challenge_id, extract(epoch FROM s.created - r.created)
+FROM submissions s
+JOIN rounds r ON r.id = s.round_id
+WHERE s.points > 0;
+
+
+-- +goose Down
+DROP TABLE challenge_solve_times;
+
+DROP TABLE challenge_test_failures;
+
+DROP TABLE challenge_lang_stats;
+
+DROP TABLE challenge_stats;
+
+ALTER TABLE submissions
+  DROP COLUMN failed_test_id;
@@ -0,0 +1,15 @@
+
+-- +goose Up
+CREATE TABLE challenge_calibrations (
+  challenge_id integer references challenges(id) not null primary key,
+  calibrated timestamp not null,
+  rating double precision not null,
+  attempts integer not null,
+  difficulty text,
+  seconds integer,
+  applied boolean not null default false
+);
+
+
+-- +goose Down
+DROP TABLE challenge_calibrations;
@@ -0,0 +1,43 @@
+
+-- +goose Up
+CREATE TABLE ratings (
+  user_id integer references users(id) not null,
+  lang text not null,
+  rating double precision not null,
+  deviation double precision not null,
+  volatility double precision not null,
+  rounds integer not null,
+  updated timestamp not null,
+  primary key (user_id, lang)
+);
+
+CREATE INDEX ratings_lang_idx ON ratings (lang, rating);
+
+CREATE TABLE rating_changes (
+  id serial not null primary key,
+  user_id integer references users(id) not null,
+  lang text not null,
+  round_id integer references rounds(id) not null,
+  created timestamp not null,
+  rating double precision not null,
+  deviation double precision not null
+);
+
+CREATE INDEX rating_changes_user_id_idx ON rating_changes
+  (user_id, lang, created);
+
+-- Rounds are rated once, by whichever node claims them. Rounds played before
+-- ratings existed are left unrated.
+ALTER TABLE rounds
+  ADD COLUMN rated boolean not null default false;
+
+UPDATE rounds SET rated = true;
+
+
+-- +goose Down
+ALTER TABLE rounds
+  DROP COLUMN rated;
+
+DROP TABLE rating_changes;
+
+DROP TABLE ratings;
@@ -0,0 +1,19 @@
+
+-- +goose Up
+-- A room's state as of one of its game events, so it can be worked out
+-- without replaying the room's whole log. Only the latest is kept.
+CREATE TABLE game_snapshots (
+  room text not null primary key,
+  event_id integer not null,
+  round_id integer not null,
+  challenge_id integer not null,
+  is_break boolean not null,
+  started timestamp not null,
+  seconds integer not null,
+  users json not null,
+  solvers json not null
+);
+
+
+-- +goose Down
+DROP TABLE game_snapshots;
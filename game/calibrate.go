@@ -0,0 +1,128 @@
+package game
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/zachlatta/calhacks/config"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/rating"
+)
+
+const (
+	// calibrationInterval is how often challenges are calibrated. Only one
+	// node does it each time.
+	calibrationInterval = time.Hour
+
+	// calibrationLockKey isn't namespaced to a room, since calibration
+	// covers every room's rounds.
+	calibrationLockKey = "calibration_lock"
+
+	// minCalibrationAttempts is how many attempts a challenge needs before
+	// its difficulty is suggested, and minCalibrationSolves how many solves
+	// before its round length is.
+	minCalibrationAttempts = 20
+	minCalibrationSolves   = 10
+
+	// Suggested round lengths leave the median solver as long again as they
+	// took, rounded up to 30 seconds, and between 1 minute and 1 hour.
+	secondsStep = 30
+	minSeconds  = 60
+	maxSeconds  = 60 * 60
+)
+
+// calibrator calibrates challenges every calibrationInterval, whenever this
+// node gets the calibration lock.
+func (g *game) calibrator() {
+	for {
+		if err := g.calibrateIfDue(); err != nil {
+			log.Println(err)
+		}
+		time.Sleep(calibrationInterval / 10)
+	}
+}
+
+// calibrateIfDue calibrates challenges if no node has in the last
+// calibrationInterval.
+func (g *game) calibrateIfDue() error {
+	c := g.pool.Get()
+	_, err := redis.String(c.Do("SET", calibrationLockKey, nodeID, "NX", "EX",
+		int(calibrationInterval/time.Second)))
+	c.Close()
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return calibrate()
+}
+
+// calibrate rates every challenge against the players who've attempted it,
+// and works out the difficulty and round length to suggest for those with
+// enough attempts. Suggestions are applied to challenges if the CALIBRATION
+// setting is "apply".
+func calibrate() error {
+	var attempts []*model.Attempt
+	if err := inTx(func(ctx context.Context) error {
+		var err error
+		attempts, err = datastore.GetAttempts(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+	elo := rating.NewElo()
+	for _, a := range attempts {
+		elo.Add(a)
+	}
+
+	apply := config.Get("CALIBRATION") == "apply"
+	now := time.Now()
+	for id, r := range elo.Challenges {
+		cal := &model.Calibration{
+			ChallengeID: id,
+			Calibrated:  now,
+			Rating:      r,
+			Attempts:    elo.Attempts[id],
+		}
+		if cal.Attempts >= minCalibrationAttempts {
+			cal.Difficulty = rating.Difficulty(r)
+		}
+		if err := inTx(func(ctx context.Context) error {
+			stats, err := datastore.GetChallengeStats(ctx, id)
+			if err != nil {
+				return err
+			}
+			if stats.Solves >= minCalibrationSolves &&
+				stats.MedianSolveSeconds != nil {
+				cal.Seconds = suggestedSeconds(*stats.MedianSolveSeconds)
+			}
+			if apply && (cal.Difficulty != "" || cal.Seconds != 0) {
+				if err := datastore.ApplyCalibration(ctx, cal); err != nil {
+					return err
+				}
+				cal.Applied = true
+			}
+			return datastore.SaveCalibration(ctx, cal)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// suggestedSeconds is the round length to suggest for a challenge with the
+// given median solve time.
+func suggestedSeconds(median float64) int {
+	s := int(math.Ceil(2*median/secondsStep)) * secondsStep
+	if s < minSeconds {
+		return minSeconds
+	} else if s > maxSeconds {
+		return maxSeconds
+	}
+	return s
+}
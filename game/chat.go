@@ -0,0 +1,291 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const (
+	// maxChatLength is the longest chat message, in characters.
+	maxChatLength = 280
+
+	// chatHistorySize is how many of a room's chat messages are kept for
+	// players who join later.
+	chatHistorySize = 50
+
+	defaultMuteTime = 5 * time.Minute
+	defaultKickTime = 30 * time.Minute
+)
+
+// ChatFilter screens chat messages before they're sent. It returns the text
+// to send, which may be censored, or an error explaining why the message
+// can't be sent at all.
+type ChatFilter interface {
+	Filter(text string) (string, error)
+}
+
+// WordFilter censors whole words, like profanity, by replacing each of their
+// letters with an asterisk. Matching ignores case.
+type WordFilter struct {
+	re *regexp.Regexp
+}
+
+func NewWordFilter(words []string) *WordFilter {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	return &WordFilter{
+		re: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`),
+	}
+}
+
+func (f *WordFilter) Filter(text string) (string, error) {
+	return f.re.ReplaceAllStringFunc(text, func(w string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(w))
+	}), nil
+}
+
+var linkRegexp = regexp.MustCompile(
+	`(?i)\b(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|ly|gg|me|co)\b`)
+
+// LinkFilter rejects messages containing links.
+type LinkFilter struct{}
+
+func (LinkFilter) Filter(text string) (string, error) {
+	if linkRegexp.MatchString(text) {
+		return "", errors.New("links aren't allowed in chat")
+	}
+	return text, nil
+}
+
+// defaultChatFilters rejects links and censors the comma separated words in
+// the CHAT_BLOCKED_WORDS setting.
+func defaultChatFilters() []ChatFilter {
+	filters := []ChatFilter{LinkFilter{}}
+	if words := configList("CHAT_BLOCKED_WORDS"); len(words) > 0 {
+		filters = append(filters, NewWordFilter(words))
+	}
+	return filters
+}
+
+func sendChat(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.SendChatEvent)
+	text := strings.TrimSpace(evt.Text)
+	if text == "" {
+		return &eventError{protocol.ErrMalformedEvent,
+			"chat messages can't be empty"}
+	}
+	if utf8.RuneCountInString(text) > maxChatLength {
+		return &eventError{protocol.ErrMessageTooLong,
+			fmt.Sprintf("chat messages can't be longer than %d characters",
+				maxChatLength)}
+	}
+
+	c := h.conn(e.UserID)
+	if c == nil {
+		return nil
+	}
+	until, err := h.game.mutedUntil(e.UserID)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(until) {
+		return &eventError{protocol.ErrMuted,
+			fmt.Sprintf("you're muted until %s", until.Format(time.Kitchen))}
+	}
+	for _, f := range h.game.ChatFilters {
+		if text, err = f.Filter(text); err != nil {
+			return &eventError{protocol.ErrMessageRejected, err.Error()}
+		}
+	}
+
+	out := &protocol.Event{
+		Type:   protocol.ChatMessage,
+		UserID: e.UserID,
+		Body: &protocol.ChatMessageEvent{
+			From:     e.UserID,
+			Username: c.user.Username,
+			To:       evt.To,
+			Text:     text,
+			Sent:     time.Now(),
+		},
+	}
+	if evt.To == 0 {
+		return h.game.addChat(out)
+	}
+
+	present, err := h.game.isCurrentUser(evt.To)
+	if err != nil {
+		return err
+	}
+	if !present {
+		return &eventError{protocol.ErrUserNotFound,
+			"that player isn't in this room"}
+	}
+	h.game.sendDirect(evt.To, out)
+	if evt.To != e.UserID {
+		echo := *out
+		echo.RequestID = e.RequestID
+		h.sendTo(e.UserID, &echo)
+	}
+	return nil
+}
+
+func muteUser(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.MuteUserEvent)
+	if err := requireAdmin(h, e.UserID); err != nil {
+		return err
+	}
+	d := time.Duration(evt.Seconds) * time.Second
+	if d <= 0 {
+		d = defaultMuteTime
+	}
+	return h.game.mute(evt.UserID, d)
+}
+
+func kickUser(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.KickUserEvent)
+	if err := requireAdmin(h, e.UserID); err != nil {
+		return err
+	}
+	d := time.Duration(evt.Seconds) * time.Second
+	if d <= 0 {
+		d = defaultKickTime
+	}
+	return h.game.kick(evt.UserID, d)
+}
+
+func requireAdmin(h *hub, userID int64) error {
+	c := h.conn(userID)
+	if c == nil || !c.user.IsAdmin() {
+		return &eventError{protocol.ErrForbidden,
+			"only admins can moderate the room"}
+	}
+	return nil
+}
+
+// userKey returns the key k for the user with the given ID in the game's
+// room.
+func (g *game) userKey(k redisKey, userID int64) string {
+	return g.key(k) + ":" + strconv.FormatInt(userID, 10)
+}
+
+// addChat adds a chat message to the room's history and sends it to everyone
+// in the room.
+func (g *game) addChat(evt *protocol.Event) error {
+	body, err := json.Marshal(evt.Body)
+	if err != nil {
+		return err
+	}
+	c := g.pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("LPUSH", g.key(chatHistoryKey), body)
+	c.Send("LTRIM", g.key(chatHistoryKey), 0, chatHistorySize-1)
+	if _, err := c.Do("EXEC"); err != nil {
+		return err
+	}
+	g.broadcast(evt)
+	return nil
+}
+
+// recentChat returns the room's most recent chat messages, oldest first.
+func (g *game) recentChat() ([]*protocol.ChatMessageEvent, error) {
+	c := g.pool.Get()
+	defer c.Close()
+	reply, err := redis.ByteSlices(c.Do("LRANGE", g.key(chatHistoryKey), 0,
+		-1))
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]*protocol.ChatMessageEvent, len(reply))
+	for i, data := range reply {
+		var msg protocol.ChatMessageEvent
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, err
+		}
+		msgs[len(reply)-1-i] = &msg
+	}
+	return msgs, nil
+}
+
+func (g *game) isCurrentUser(userID int64) (bool, error) {
+	c := g.pool.Get()
+	defer c.Close()
+	return redis.Bool(c.Do("SISMEMBER", g.key(currentUserIDsKey), userID))
+}
+
+// until sets the key k for userID to expire after d, and returns when that
+// will be.
+func (g *game) until(k redisKey, userID int64, d time.Duration) (time.Time,
+	error) {
+	until := time.Now().Add(d)
+	c := g.pool.Get()
+	defer c.Close()
+	_, err := c.Do("SET", g.userKey(k, userID), until.Unix(), "EX",
+		int(d/time.Second))
+	return until, err
+}
+
+// untilOf returns when the key k for userID set by until expires, or the zero
+// time if it isn't set.
+func (g *game) untilOf(k redisKey, userID int64) (time.Time, error) {
+	c := g.pool.Get()
+	defer c.Close()
+	unix, err := redis.Int64(c.Do("GET", g.userKey(k, userID)))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(unix, 0), nil
+}
+
+func (g *game) mute(userID int64, d time.Duration) error {
+	until, err := g.until(mutedKey, userID, d)
+	if err != nil {
+		return err
+	}
+	g.broadcast(&protocol.Event{
+		Type:   protocol.UserMuted,
+		UserID: userID,
+		Body:   &protocol.UserMutedEvent{UserID: userID, Until: until},
+	})
+	return nil
+}
+
+func (g *game) mutedUntil(userID int64) (time.Time, error) {
+	return g.untilOf(mutedKey, userID)
+}
+
+// kick removes a player from the room and keeps them from reconnecting for d.
+// The node they're connected to ends their session when it gets the
+// UserKicked event.
+func (g *game) kick(userID int64, d time.Duration) error {
+	until, err := g.until(kickedKey, userID, d)
+	if err != nil {
+		return err
+	}
+	g.broadcast(&protocol.Event{
+		Type:   protocol.UserKicked,
+		UserID: userID,
+		Body:   &protocol.UserKickedEvent{UserID: userID, Until: until},
+	})
+	return nil
+}
+
+// KickedUntil returns when the user with the given ID is allowed back into
+// the room after being kicked, or the zero time if they weren't.
+func (g *game) KickedUntil(userID int64) (time.Time, error) {
+	return g.untilOf(kickedKey, userID)
+}
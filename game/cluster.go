@@ -0,0 +1,284 @@
+package game
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const (
+	// timerLockTTL is how long a node keeps ownership of a room's timer
+	// without renewing it. Another node takes over once it expires.
+	timerLockTTL = 3 * time.Second
+
+	// nodeTTL is how long a node is considered alive after its last
+	// heartbeat.
+	nodeTTL = 10 * time.Second
+)
+
+// nodeID identifies this server process among every other one sharing the
+// same Redis.
+var nodeID = randSeq(16)
+
+func nodeKey(id string) string {
+	return "node:" + id
+}
+
+var renewLockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// tickTimerScript counts down the room's timer in KEYS[2] by a second, but
+// only if the timer lock in KEYS[1] is still held by the node in ARGV[1]. The
+// lock is renewed for ARGV[2] milliseconds at the same time. It returns nil
+// if the lock has been lost, so a node that stalled for longer than the lock
+// lasts can't count down alongside the node that took over from it.
+var tickTimerScript = redis.NewScript(2, `
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return false
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return redis.call("DECR", KEYS[2])
+`)
+
+// errLostTimer is returned when this node tries to count down a room's timer
+// it no longer owns.
+var errLostTimer = errors.New("lost the room's timer to another node")
+
+// broadcast sends evt to every player in the room, no matter which node
+// they're connected to. The event is recorded once, here, rather than by each
+// node that delivers it.
+func (g *game) broadcast(evt interface{}) {
+	g.record(evt, 0)
+	g.publish(evt)
+}
+
+// publish sends evt to every player in the room like broadcast, but leaves it
+// out of the round log.
+func (g *game) publish(evt interface{}) {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c := g.pool.Get()
+	defer c.Close()
+	if _, err := c.Do("PUBLISH", g.key(broadcastChannel), body); err != nil {
+		log.Println(err)
+	}
+}
+
+// directMessage is a message for a single player, published for whichever
+// node they're connected to.
+type directMessage struct {
+	UserID  int64    `json:"user_id"`
+	Message *message `json:"message"`
+}
+
+// sendDirect sends evt to a single player, no matter which node they're
+// connected to.
+func (g *game) sendDirect(userID int64, evt interface{}) {
+	g.record(evt, userID)
+	if err := publishDirect(g.pool, g.room, userID, evt); err != nil {
+		log.Println(err)
+	}
+}
+
+// publishDirect sends evt to a single player in room, no matter which node
+// they're connected to. Unlike sendDirect, it works for rooms that aren't
+// running on this node, and doesn't record evt in the room's round.
+func publishDirect(pool *redis.Pool, room string, userID int64,
+	evt interface{}) error {
+	m, err := newMessage(evt)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&directMessage{UserID: userID, Message: m})
+	if err != nil {
+		return err
+	}
+	c := pool.Get()
+	defer c.Close()
+	_, err = c.Do("PUBLISH", roomKey(room, directChannel), body)
+	return err
+}
+
+// subscribe relays everything published to the room's broadcast and direct
+// channels to the connections on this node, resubscribing if the connection
+// to Redis drops.
+func (g *game) subscribe() {
+	for {
+		if err := g.receive(); err != nil {
+			log.Println(err)
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-g.Hub.done:
+			return
+		}
+	}
+}
+
+// receive passes on the messages published for the room until the room stops
+// or the subscription fails.
+func (g *game) receive() error {
+	c := g.pool.Get()
+	defer c.Close()
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe(g.key(broadcastChannel),
+		g.key(directChannel)); err != nil {
+		return err
+	}
+
+	// Receive blocks, so unsubscribe from another goroutine to stop it when
+	// the room stops. That goroutine is done with the connection before it's
+	// closed.
+	quit := make(chan struct{})
+	unsubscribed := make(chan struct{})
+	go func() {
+		defer close(unsubscribed)
+		select {
+		case <-g.Hub.done:
+			psc.Unsubscribe()
+		case <-quit:
+		}
+	}()
+	defer func() {
+		close(quit)
+		<-unsubscribed
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			if v.Channel == g.key(directChannel) {
+				var d directMessage
+				if err := json.Unmarshal(v.Data, &d); err != nil {
+					log.Println(err)
+					continue
+				}
+				select {
+				case g.Hub.direct <- &directEvent{userID: d.UserID,
+					msg: d.Message}:
+				case <-g.Hub.done:
+				}
+				continue
+			}
+			var m message
+			if err := json.Unmarshal(v.Data, &m); err != nil {
+				log.Println(err)
+				continue
+			}
+			if m.Type == protocol.ChallengeSet {
+				g.rounds.clear()
+			}
+			select {
+			case g.Hub.broadcast <- &m:
+			case <-g.Hub.done:
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// heartbeat marks this node as alive for another nodeTTL.
+func (g *game) heartbeat() error {
+	c := g.pool.Get()
+	defer c.Close()
+	_, err := c.Do("SET", nodeKey(nodeID), time.Now().Unix(), "EX",
+		int(nodeTTL/time.Second))
+	return err
+}
+
+// ownsTimer renews this node's lock on the room's timer, or takes the lock if
+// nobody holds it. Only the node holding the lock drives the room's timer.
+func (g *game) ownsTimer() (bool, error) {
+	return ownsLock(g.pool, g.key(timerLockKey), timerLockTTL)
+}
+
+// ownsLock renews this node's lock at key, or takes the lock if nobody holds
+// it. Locks expire if they aren't renewed within ttl.
+func ownsLock(pool *redis.Pool, key string, ttl time.Duration) (bool, error) {
+	c := pool.Get()
+	defer c.Close()
+
+	ms := int(ttl / time.Millisecond)
+	renewed, err := redis.Int(renewLockScript.Do(c, key, nodeID, ms))
+	if err != nil {
+		return false, err
+	}
+	if renewed == 1 {
+		return true, nil
+	}
+
+	_, err = redis.String(c.Do("SET", key, nodeID, "NX", "PX", ms))
+	if err != nil {
+		if err == redis.ErrNil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// takeOver is run when this node becomes the owner of the room's timer. The
+// last owner may have died partway through a transition, so the cached state
+// is rebuilt from the log before the timer carries on.
+func (g *game) takeOver() error {
+	s, err := g.rebuild()
+	if err != nil {
+		return err
+	}
+	if s.started.IsZero() {
+		return g.startBreak(5)
+	}
+	return nil
+}
+
+// sweepUsers removes players whose node has stopped sending heartbeats. Their
+// connections went down with it, so nobody else will say they've left.
+func (g *game) sweepUsers() error {
+	c := g.pool.Get()
+	defer c.Close()
+
+	nodes, err := redis.StringMap(c.Do("HGETALL", g.key(currentUserNodesKey)))
+	if err != nil {
+		return err
+	}
+
+	alive := make(map[string]bool)
+	for user, node := range nodes {
+		ok, seen := alive[node]
+		if !seen {
+			ok, err = redis.Bool(c.Do("EXISTS", nodeKey(node)))
+			if err != nil {
+				return err
+			}
+			alive[node] = ok
+		}
+		if ok {
+			continue
+		}
+		id, err := strconv.ParseInt(user, 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := g.removeCurrentUser(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
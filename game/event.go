@@ -2,175 +2,182 @@ package game
 
 import (
 	"encoding/base64"
-	"encoding/json"
+	"fmt"
 	"log"
-	"strings"
 
 	"code.google.com/p/go.net/context"
 
+	"github.com/garyburd/redigo/redis"
 	"github.com/zachlatta/calhacks/datastore"
 	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
 )
 
-type eventType int
-
-const (
-	userJoined eventType = iota
-	userLeft
-	timerChanged
-	timerFinished
-	challengeSet
-	breakStarted
-	runCode
-	codeRan
-	initialState
-)
-
-type userJoinedEvent struct {
-	User *model.User `json:"user"`
+// sentByClients is the set of event types clients are allowed to send.
+var sentByClients = map[protocol.EventType]bool{
+	protocol.RunCode:    true,
+	protocol.SubmitCode: true,
+	protocol.RunSamples: true,
+	protocol.SendChat:   true,
+	protocol.MuteUser:   true,
+	protocol.KickUser:   true,
+	protocol.JoinQueue:  true,
+	protocol.LeaveQueue: true,
 }
 
-type userLeftEvent struct {
-	UserID int64 `json:"user_id"`
+// eventError is an error to report back to the client whose event caused it.
+type eventError struct {
+	code string
+	msg  string
 }
 
-type timerChangedEvent struct {
-	Total     int `json:"total"`
-	Remaining int `json:"remaining"`
+func (e *eventError) Error() string {
+	return e.msg
 }
 
-type challengeSetEvent struct {
-	Challenge *model.Challenge `json:"challenge"`
-}
-
-type runCodeEvent struct {
-	Code string `json:"code"`
-	Lang string `json:"lang"`
+func processEvent(h *hub, e *protocol.Event) {
+	var err error
+	switch e.Type {
+	case protocol.RunCode, protocol.SubmitCode:
+		err = submitCode(h, e)
+	case protocol.RunSamples:
+		err = runSamples(h, e)
+	case protocol.SendChat:
+		err = sendChat(h, e)
+	case protocol.MuteUser:
+		err = muteUser(h, e)
+	case protocol.KickUser:
+		err = kickUser(h, e)
+	case protocol.JoinQueue:
+		err = joinQueue(h, e)
+	case protocol.LeaveQueue:
+		err = leaveQueue(h, e)
+	}
+	if err != nil {
+		if evtErr, ok := err.(*eventError); ok {
+			h.sendError(e.UserID, e.RequestID, evtErr.code, evtErr.msg)
+		} else {
+			log.Println(err)
+			h.sendError(e.UserID, e.RequestID, protocol.ErrInternal,
+				"something went wrong")
+		}
+		return
+	}
+	h.sendTo(e.UserID, &protocol.Event{
+		Type:      protocol.Ack,
+		RequestID: e.RequestID,
+		UserID:    -1,
+	})
 }
 
-type codeRanEvent struct {
-	Output string `json:"output"`
-	Passed bool   `json:"passed"`
+func submitCode(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.RunCodeEvent)
+	return queueRun(h, e, &runTask{
+		requestID: e.RequestID,
+		lang:      evt.Lang,
+		graded:    true,
+		priority:  gradedPriority,
+	}, evt.Code)
 }
 
-type initialStateEvent struct {
-	CurrentChallenge     *model.Challenge `json:"current_challenge"`
-	CurrentUsers         []*model.User    `json:"current_users"`
-	CurrentTimeRemaining int              `json:"time_remaining"`
-	TotalTime            int              `json:"total_time"`
+func runSamples(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.RunSamplesEvent)
+	return queueRun(h, e, &runTask{
+		requestID: e.RequestID,
+		lang:      evt.Lang,
+		input:     evt.Input,
+		priority:  samplesPriority,
+	}, evt.Code)
 }
 
-type event struct {
-	Type   eventType   `json:"type"`
-	UserID int64       `json:"user_id"`
-	Body   interface{} `json:"body,omitempty"`
-}
+// queueRun fills in the rest of t for the player who sent e and the current
+// challenge, and queues it to be run. code is the base64 encoded code to run.
+func queueRun(h *hub, e *protocol.Event, t *runTask, code string) error {
+	supported, err := h.game.codeRunner.executor.Supports(t.lang)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return &eventError{protocol.ErrUnsupportedLanguage,
+			fmt.Sprintf("%q isn't a supported language", t.lang)}
+	}
+	t.code, err = base64.StdEncoding.DecodeString(code)
+	if err != nil {
+		return &eventError{protocol.ErrMalformedEvent,
+			"code must be base64 encoded"}
+	}
 
-func (e *event) UnmarshalJSON(data []byte) error {
-	var typeWrapper struct {
-		Type eventType `json:"type"`
+	isBreak, err := h.game.isBreak()
+	if err != nil {
+		return err
 	}
-	if err := json.Unmarshal(data, &typeWrapper); err != nil {
+	chlngID, err := h.game.CurrentChallengeID()
+	if err != nil && err != redis.ErrNil {
 		return err
 	}
-	e.Type = typeWrapper.Type
-	switch e.Type {
-	case userJoined:
-		var wrapper struct {
-			Body userJoinedEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
-	case userLeft:
-		var wrapper struct {
-			Body userLeftEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
-	case timerChanged:
-		var wrapper struct {
-			Body timerChangedEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
-	case timerFinished:
-		e.Body = nil
-	case challengeSet:
-		var wrapper struct {
-			Body challengeSetEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
-	case breakStarted:
-		e.Body = nil
-	case runCode:
-		var wrapper struct {
-			Body runCodeEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
-	case codeRan:
-		e.Body = nil
-	case initialState:
-		var wrapper struct {
-			Body initialStateEvent `json:"body"`
-		}
-		if err := json.Unmarshal(data, &wrapper); err != nil {
-			return err
-		}
-		e.Body = wrapper.Body
+	if isBreak || chlngID == 0 {
+		return &eventError{protocol.ErrNoActiveRound,
+			"there's no round in progress"}
+	}
+	t.roundID, err = h.game.currentRoundID()
+	if err != nil {
+		return err
 	}
-	return nil
-}
 
-func processEvent(h *hub, e *event) {
-	switch e.Type {
-	case runCode:
-		ctx, cancel := context.WithCancel(context.Background())
-		ctx, err := datastore.NewContextWithTx(ctx)
-		if err != nil {
-			log.Println(err)
-			return
+	t.userID = e.UserID
+	t.c = h.conn(e.UserID)
+	if t.c == nil {
+		return &eventError{protocol.ErrNotConnected,
+			"your connection dropped before your code could be queued"}
+	}
+	if !h.limits.acquireRun(e.UserID) {
+		return &eventError{protocol.ErrTooManyRuns,
+			fmt.Sprintf("you can only have %d runs waiting at once",
+				maxQueuedRuns)}
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			h.limits.releaseRun(e.UserID)
 		}
-		defer cancel()
+	}()
 
-		tx, _ := datastore.TxFromContext(ctx)
-		defer tx.Commit()
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, err = datastore.NewContextWithTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
 
-		evt := e.Body.(runCodeEvent)
-		dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(evt.Code))
+	tx, _ := datastore.TxFromContext(ctx)
+	defer tx.Commit()
 
-		chlngID, err := h.game.currentChallengeID()
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		chlng, err := datastore.GetChallenge(ctx, chlngID)
-		if err != nil {
-			log.Println(err)
-			return
+	t.chlng, err = datastore.GetChallenge(ctx, chlngID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case t.graded:
+		t.tests = t.chlng.HiddenTestCases()
+	case t.input != nil:
+		t.tests = []model.TestCase{{Input: *t.input}}
+	default:
+		t.tests = t.chlng.Samples()
+		if len(t.tests) == 0 {
+			return &eventError{protocol.ErrNoSamples,
+				"this challenge has no sample test cases, run it with an input"}
 		}
+	}
 
-		h.game.dockerRunner.jobs <- &dockerTask{
-			c:     h.conns[e.UserID],
-			code:  dec,
-			lang:  evt.Lang,
-			chlng: chlng,
-		}
+	queued = h.game.codeRunner.queue.push(t)
+	if !queued {
+		return &eventError{protocol.ErrQueueFull,
+			"too much code is waiting to run, try again in a moment"}
 	}
+	return nil
 }
 
-func sendInitialState(h *hub, c *conn) {
+func sendInitialState(h *hub, userID int64) {
 	ctx, cancel := context.WithCancel(context.Background())
 	ctx, err := datastore.NewContextWithTx(ctx)
 	if err != nil {
@@ -182,7 +189,7 @@ func sendInitialState(h *hub, c *conn) {
 	tx, _ := datastore.TxFromContext(ctx)
 	defer tx.Commit()
 
-	chlngID, err := h.game.currentChallengeID()
+	chlngID, err := h.game.CurrentChallengeID()
 	if err != nil {
 		log.Println(err)
 		return
@@ -222,14 +229,21 @@ func sendInitialState(h *hub, c *conn) {
 		return
 	}
 
-	c.send <- &event{
-		Type:   initialState,
+	recentChat, err := h.game.recentChat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	h.sendTo(userID, &protocol.Event{
+		Type:   protocol.InitialState,
 		UserID: -1,
-		Body: &initialStateEvent{
-			CurrentChallenge:     chlng,
+		Body: &protocol.InitialStateEvent{
+			CurrentChallenge:     chlng.Public(),
 			CurrentUsers:         users,
 			CurrentTimeRemaining: timeRemaining,
 			TotalTime:            totalTime,
+			RecentChat:           recentChat,
 		},
-	}
+	})
 }
@@ -3,11 +3,12 @@ package game
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"runtime/debug"
 	"strconv"
-	"sync"
+	"strings"
 	"time"
 
 	"code.google.com/p/go.net/context"
@@ -17,6 +18,8 @@ import (
 	"github.com/zachlatta/calhacks/config"
 	"github.com/zachlatta/calhacks/datastore"
 	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+	"github.com/zachlatta/calhacks/runner"
 )
 
 const (
@@ -26,19 +29,40 @@ const (
 	maxMessageSize = 512
 )
 
+// noChallengesBreak is how long, in seconds, a room waits before looking for
+// a challenge again when there are none it can play.
+const noChallengesBreak = 30
+
 type conn struct {
 	ws   *websocket.Conn
 	send chan interface{}
 	user *model.User
+
+	// resume is the token of the session the client wants to resume, and
+	// lastSeq the sequence number of the last message it received in it.
+	resume  string
+	lastSeq int64
+
+	// version is the protocol version negotiated when the client connected.
+	version int
 }
 
-func NewConn(ws *websocket.Conn, send chan interface{}, u *model.User) *conn {
-	return &conn{ws: ws, send: send, user: u}
+func NewConn(ws *websocket.Conn, send chan interface{}, u *model.User,
+	resume string, lastSeq int64) *conn {
+	version, err := protocol.ParseSubprotocol(ws.Subprotocol())
+	if err != nil {
+		version = 1
+	}
+	return &conn{ws: ws, send: send, user: u, resume: resume, lastSeq: lastSeq,
+		version: version}
 }
 
 func (c *conn) readPump(h *hub) {
 	defer func() {
-		h.unregister <- c
+		select {
+		case h.unregister <- c:
+		case <-h.done:
+		}
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
 	c.ws.SetReadDeadline(time.Now().Add(pongWait))
@@ -47,15 +71,82 @@ func (c *conn) readPump(h *hub) {
 		return nil
 	})
 	for {
-		var evt event
-		err := c.ws.ReadJSON(&evt)
+		_, data, err := c.ws.ReadMessage()
 		if err != nil {
 			break
 		}
-		evt.UserID = c.user.ID
+		if !c.handle(h, data) && h.limits.strike(c.user.ID) {
+			h.sendError(c.user.ID, "", protocol.ErrFlooding,
+				"disconnected for sending too many bad or rate limited events")
+			break
+		}
+	}
+}
+
+// handle decodes an event the client sent and passes it on to be processed.
+// It reports false if the event was invalid or over the client's rate limits.
+func (c *conn) handle(h *hub, data []byte) bool {
+	evt, err := c.decode(data)
+	if err != nil {
+		requestID := protocol.RequestID(data)
+		if e, ok := err.(*protocol.UnknownEventError); ok {
+			h.sendError(c.user.ID, requestID, protocol.ErrUnknownEvent,
+				e.Error())
+		} else {
+			h.sendError(c.user.ID, requestID, protocol.ErrMalformedEvent,
+				err.Error())
+		}
+		h.limits.allow(c.user.ID, "")
+		return false
+	}
+	if !sentByClients[evt.Type] {
+		h.sendError(c.user.ID, evt.RequestID, protocol.ErrUnsupportedEvent,
+			fmt.Sprintf("clients can't send %s events", evt.Type))
+		h.limits.allow(c.user.ID, "")
+		return false
+	}
+	if !h.limits.allow(c.user.ID, evt.Type) {
+		h.sendError(c.user.ID, evt.RequestID, protocol.ErrRateLimited,
+			fmt.Sprintf("you're sending %s events too quickly", evt.Type))
+		return false
+	}
+	evt.UserID = c.user.ID
+
+	select {
+	case h.events <- evt:
+	case <-h.done:
+	}
+	return true
+}
 
-		h.events <- &evt
+func (c *conn) decode(data []byte) (*protocol.Event, error) {
+	if c.version == 1 {
+		return protocol.DecodeLegacy(data)
+	}
+	var evt protocol.Event
+	if err := json.Unmarshal(data, &evt); err != nil {
+		return nil, err
 	}
+	return &evt, nil
+}
+
+// encode converts m to the form it's sent in over c's protocol version.
+// Messages that don't exist in c's version are skipped.
+func (c *conn) encode(m *message) (v interface{}, ok bool) {
+	if c.version > 1 {
+		return m, true
+	}
+	code, ok := protocol.LegacyCode(m.Type)
+	if !ok {
+		return nil, false
+	}
+	return &legacyMessage{
+		Seq:       m.Seq,
+		Type:      code,
+		RequestID: m.RequestID,
+		UserID:    m.UserID,
+		Body:      m.Body,
+	}, true
 }
 
 func (c *conn) write(mt int, payload []byte) error {
@@ -76,7 +167,12 @@ func (c *conn) writePump() {
 				c.write(websocket.CloseMessage, []byte{})
 				return
 			}
-			if err := c.ws.WriteJSON(val); err != nil {
+			v, ok := c.encode(val.(*message))
+			if !ok {
+				continue
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteJSON(v); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -87,112 +183,355 @@ func (c *conn) writePump() {
 	}
 }
 
+// eventWorkers is how many goroutines process events sent by players in each
+// room.
+const eventWorkers = 8
+
+type directEvent struct {
+	userID int64
+	msg    *message
+}
+
+// hub tracks the sessions in a room on this node. The sessions map is owned by
+// the goroutine started by run; everything else reaches it through the hub's
+// channels, so slow work like talking to the database never happens while
+// holding it.
 type hub struct {
-	conns      map[int64]*conn
-	events     chan *event
-	broadcast  chan interface{}
+	sessions   map[int64]*session
+	events     chan *protocol.Event
+	broadcast  chan *message
+	direct     chan *directEvent
 	register   chan *conn
 	unregister chan *conn
+	expire     chan *session
+	queries    chan func(map[int64]*session)
+	limits     *limiter
 	game       *game
+
+	// done is closed when the room stops on this node. Anything waiting on
+	// the hub gives up once it is.
+	done chan struct{}
+
+	// joined, resync and left are run in their own goroutines when a player
+	// joins the room on this node, when a player's client needs to be sent
+	// the game's state again, and when a player's session here ends.
+	joined func(u *model.User)
+	resync func(userID int64)
+	left   func(userID int64)
 }
 
-func (h *hub) run() {
-	wg := sync.WaitGroup{}
-	wg.Add(8)
+func newHub() hub {
+	return hub{
+		broadcast:  make(chan *message),
+		events:     make(chan *protocol.Event),
+		direct:     make(chan *directEvent),
+		register:   make(chan *conn),
+		unregister: make(chan *conn),
+		expire:     make(chan *session),
+		queries:    make(chan func(map[int64]*session)),
+		sessions:   make(map[int64]*session),
+		limits:     newLimiter(),
+		done:       make(chan struct{}),
+	}
+}
 
-	for i := 0; i < 8; i++ {
+func (h *hub) run() {
+	for i := 0; i < eventWorkers; i++ {
 		go func() {
-			defer wg.Done()
 			for {
 				select {
-				case c := <-h.register:
-					h.conns[c.user.ID] = c
-					sendInitialState(h, c)
-					if err := h.game.addCurrentUser(c.user); err != nil {
-						log.Println(err)
-					}
-				case c := <-h.unregister:
-					if _, ok := h.conns[c.user.ID]; ok {
-						delete(h.conns, c.user.ID)
-						close(c.send)
-						if err := h.game.removeCurrentUser(c.user.ID); err != nil {
-							log.Println(err)
-						}
-					}
 				case e := <-h.events:
 					processEvent(h, e)
-				case m := <-h.broadcast:
-					for _, c := range h.conns {
-						select {
-						case c.send <- m:
-						default:
-							close(c.send)
-							delete(h.conns, c.user.ID)
-						}
-					}
+				case <-h.done:
+					return
 				}
 			}
 		}()
 	}
 
-	wg.Wait()
+	for {
+		select {
+		case c := <-h.register:
+			h.connect(c)
+		case c := <-h.unregister:
+			if s := h.sessions[c.user.ID]; s != nil && s.conn == c {
+				h.disconnect(s)
+			}
+		case s := <-h.expire:
+			if h.sessions[s.user.ID] == s && s.conn == nil &&
+				time.Since(s.disconnected) >= reconnectGrace {
+				delete(h.sessions, s.user.ID)
+				h.limits.forget(s.user.ID)
+				go h.left(s.user.ID)
+			}
+		case d := <-h.direct:
+			if s := h.sessions[d.userID]; s != nil {
+				h.deliver(s, d.msg)
+			}
+		case m := <-h.broadcast:
+			for _, s := range h.sessions {
+				h.deliver(s, m)
+			}
+			if m.Type == protocol.UserKicked {
+				h.kick(m.UserID)
+			}
+		case q := <-h.queries:
+			q(h.sessions)
+		case <-h.done:
+			for id, s := range h.sessions {
+				if s.conn != nil {
+					close(s.conn.send)
+				}
+				go h.left(id)
+			}
+			return
+		}
+	}
+}
+
+// connect attaches c to its user's session, starting a new session if the
+// user doesn't have one or c didn't ask to resume it. Either way, any
+// connection the user already had is closed in favor of c. It must only be
+// called by run.
+func (h *hub) connect(c *conn) {
+	s := h.sessions[c.user.ID]
+	if s != nil && c.resume != "" && c.resume == s.token {
+		h.attach(s, c, true)
+		if msgs, ok := s.missed(c.lastSeq); ok {
+			for _, m := range msgs {
+				h.write(s, m)
+			}
+		} else {
+			go h.resync(c.user.ID)
+		}
+		return
+	}
+
+	ns, err := newSession(c.user)
+	if err != nil {
+		log.Println(err)
+		close(c.send)
+		return
+	}
+	if s != nil {
+		if s.conn != nil {
+			close(s.conn.send)
+		}
+		h.sessions[c.user.ID] = ns
+		h.attach(ns, c, false)
+		go h.resync(c.user.ID)
+		return
+	}
+	h.sessions[c.user.ID] = ns
+	h.attach(ns, c, false)
+	go h.joined(c.user)
+}
+
+// attach makes c the connection for s, closing the one it replaces. It must
+// only be called by run.
+func (h *hub) attach(s *session, c *conn, resumed bool) {
+	if s.conn != nil {
+		close(s.conn.send)
+	}
+	s.conn = c
+	m, err := newMessage(&protocol.Event{
+		Type:   protocol.SessionStarted,
+		UserID: -1,
+		Body: &protocol.SessionStartedEvent{
+			Token:           s.token,
+			Resumed:         resumed,
+			ProtocolVersion: c.version,
+		},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	c.send <- m
+}
+
+// disconnect closes s's connection and gives the player reconnectGrace to
+// come back before they're removed from the game. It must only be called by
+// run.
+func (h *hub) disconnect(s *session) {
+	close(s.conn.send)
+	s.conn = nil
+	s.disconnected = time.Now()
+	time.AfterFunc(reconnectGrace, func() {
+		select {
+		case h.expire <- s:
+		case <-h.done:
+		}
+	})
+}
+
+// kick ends the session of a player who was kicked from the room, if they
+// have one on this node. It must only be called by run.
+func (h *hub) kick(userID int64) {
+	s := h.sessions[userID]
+	if s == nil {
+		return
+	}
+	if s.conn != nil {
+		close(s.conn.send)
+	}
+	delete(h.sessions, userID)
+	h.limits.forget(userID)
+	go h.left(userID)
+}
+
+// deliver adds m to s's history and sends it on to s's connection, if it has
+// one. It must only be called by run.
+func (h *hub) deliver(s *session, m *message) {
+	h.write(s, s.stamp(m))
+}
+
+// write queues m on s's connection without blocking, disconnecting it if its
+// buffer is full. It must only be called by run.
+func (h *hub) write(s *session, m *message) {
+	if s.conn == nil {
+		return
+	}
+	select {
+	case s.conn.send <- m:
+	default:
+		h.disconnect(s)
+	}
 }
 
+// sendTo sends evt to a single player, recording it in the round log. If the
+// player is reconnecting, the event is held so it can be replayed when they
+// resume.
+func (h *hub) sendTo(userID int64, evt *protocol.Event) {
+	h.game.record(evt, userID)
+	h.sendUnrecorded(userID, evt)
+}
+
+// sendUnrecorded sends evt to a single player like sendTo, but leaves it out
+// of the round log. It's for events that only matter at the moment they're
+// sent, like places in line, which would otherwise cost a write each.
+func (h *hub) sendUnrecorded(userID int64, evt *protocol.Event) {
+	m, err := newMessage(evt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	select {
+	case h.direct <- &directEvent{userID: userID, msg: m}:
+	case <-h.done:
+	}
+}
+
+// sendError tells a player that something they sent couldn't be handled.
+// requestID is the ID the client gave the event, if any.
+func (h *hub) sendError(userID int64, requestID, code, msg string) {
+	h.sendTo(userID, &protocol.Event{
+		Type:      protocol.Error,
+		RequestID: requestID,
+		UserID:    -1,
+		Body: &protocol.ErrorEvent{
+			Code:    code,
+			Message: msg,
+		},
+	})
+}
+
+// conn returns the connection for userID on this node, or nil if there isn't
+// one.
+func (h *hub) conn(userID int64) *conn {
+	reply := make(chan *conn, 1)
+	if !h.query(func(sessions map[int64]*session) {
+		if s := sessions[userID]; s != nil {
+			reply <- s.conn
+			return
+		}
+		reply <- nil
+	}) {
+		return nil
+	}
+	return <-reply
+}
+
+// sessionCount returns how many players have a session in the room on this
+// node, including ones who are reconnecting.
+func (h *hub) sessionCount() int {
+	reply := make(chan int, 1)
+	if !h.query(func(sessions map[int64]*session) {
+		reply <- len(sessions)
+	}) {
+		return 0
+	}
+	return <-reply
+}
+
+// query runs q with the hub's sessions, reporting false without running it
+// if the room has stopped.
+func (h *hub) query(q func(map[int64]*session)) bool {
+	select {
+	case h.queries <- q:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// RegisterAndProcessConn serves c until it disconnects. If the room has
+// stopped on this node, c is closed at once so its client can connect again
+// and start the room back up.
 func (h *hub) RegisterAndProcessConn(c *conn) {
-	h.register <- c
+	select {
+	case h.register <- c:
+	case <-h.done:
+		close(c.send)
+	}
 	go c.writePump()
 	c.readPump(h)
 }
 
-func (h *hub) ConnForUserExists(user *model.User) bool {
-	_, ok := h.conns[user.ID]
-	return ok
-}
-
 type game struct {
 	CurrentChallenge *model.Challenge
 	Hub              hub
-	pool             *redis.Pool
-	dockerRunner     *dockerRunner
+
+	// ChatFilters screen every chat message, in order.
+	ChatFilters []ChatFilter
+
+	// Tags limits the challenges picked for the room to ones with at least
+	// one of them. Any published challenge can be picked if it's empty.
+	Tags []string
+
+	// Difficulty limits the challenges picked for the room to ones of a
+	// difficulty, going by their calibrated difficulty if they have one.
+	Difficulty string
+
+	room       string
+	pool       *redis.Pool
+	codeRunner *codeRunner
+	recorder   *recorder
+	rounds     roundCache
 }
 
-func NewGame() *game {
+// NewGame makes a game for room that shares pool and executor with the other
+// rooms on this node.
+func NewGame(room string, pool *redis.Pool, executor runner.Executor) *game {
 	g := &game{
-		Hub: hub{
-			broadcast:  make(chan interface{}),
-			events:     make(chan *event),
-			register:   make(chan *conn),
-			unregister: make(chan *conn),
-			conns:      make(map[int64]*conn),
-		},
-		pool: &redis.Pool{
-			MaxIdle:     3,
-			IdleTimeout: 240 * time.Second,
-			Dial: func() (redis.Conn, error) {
-				c, err := redis.Dial("tcp", config.RedisServer())
-				if err != nil {
-					return nil, err
-				}
-				pass := config.RedisPassword()
-				if pass != "" {
-					if _, err := c.Do("AUTH", pass); err != nil {
-						c.Close()
-						return nil, err
-					}
-				}
-				return c, err
-			},
-			TestOnBorrow: func(c redis.Conn, t time.Time) error {
-				_, err := c.Do("PING")
-				return err
-			},
-		},
-		dockerRunner: &dockerRunner{
+		room: room,
+		Hub:  newHub(),
+		pool: pool,
+		codeRunner: &codeRunner{
 			WorkerCount: 32,
+			executor:    executor,
 		},
+		recorder:    newRecorder(),
+		ChatFilters: defaultChatFilters(),
+		Tags:        configList("ROOM_TAGS_" + strings.ToUpper(room)),
+		Difficulty:  config.Get("ROOM_DIFFICULTY_" + strings.ToUpper(room)),
 	}
 	g.Hub.game = g
-	g.dockerRunner.hub = &g.Hub
+	g.Hub.joined = g.joined
+	g.Hub.resync = func(userID int64) { sendInitialState(&g.Hub, userID) }
+	g.Hub.left = g.left
+	g.codeRunner.hub = &g.Hub
+	g.codeRunner.queue = newSubmissionQueue(&g.Hub)
 	return g
 }
 
@@ -200,40 +539,107 @@ type redisKey string
 
 const (
 	currentChallengeIDKey redisKey = "current_challenge_id"
+	currentRoundIDKey     redisKey = "current_round_id"
 	currentUserIDsKey     redisKey = "current_users"
+	currentUserNodesKey   redisKey = "current_user_nodes"
 	timeTotalKey          redisKey = "time_total"
 	timeRemainingKey      redisKey = "time_remaining"
 	breakKey              redisKey = "break"
+	roundSolversKey       redisKey = "round_solvers"
+	timerLockKey          redisKey = "timer_lock"
+	chatHistoryKey        redisKey = "chat_history"
+	mutedKey              redisKey = "muted"
+	kickedKey             redisKey = "kicked"
+	matchedPlayersKey     redisKey = "matched_players"
+	broadcastChannel      redisKey = "broadcast"
+	directChannel         redisKey = "direct"
 )
 
-func (g *game) currentChallengeID() (int64, error) {
-	c := g.pool.Get()
-	defer c.Close()
-	return redis.Int64(c.Do("GET", currentChallengeIDKey))
+// configList returns the comma separated values of a setting, without
+// surrounding whitespace or empty values.
+func configList(key string) []string {
+	var vals []string
+	for _, v := range strings.Split(config.Get(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// key namespaces k to the game's room.
+func (g *game) key(k redisKey) string {
+	return roomKey(g.room, k)
 }
 
-func (g *game) setCurrentChallenge(chlng *model.Challenge) error {
+// roomKey namespaces k to room.
+func roomKey(room string, k redisKey) string {
+	return room + ":" + string(k)
+}
+
+// CurrentChallengeID returns the ID of the room's current challenge, or
+// redis.ErrNil if there hasn't been one yet.
+func (g *game) CurrentChallengeID() (int64, error) {
 	c := g.pool.Get()
 	defer c.Close()
-	if err := c.Send("SET", currentChallengeIDKey, chlng.ID); err != nil {
-		return err
+	return redis.Int64(c.Do("GET", g.key(currentChallengeIDKey)))
+}
+
+// randomChallenge picks a challenge for the next round from the ones with the
+// room's tags and difficulty. If none match, the difficulty and then the tags
+// are ignored so the room doesn't stall.
+func (g *game) randomChallenge(ctx context.Context) (*model.Challenge,
+	error) {
+	chlng, err := datastore.GetRandomChallenge(ctx, g.Tags, g.Difficulty)
+	if err == sql.ErrNoRows && g.Difficulty != "" {
+		log.Printf("no published challenges in room %s are %s", g.room,
+			g.Difficulty)
+		chlng, err = datastore.GetRandomChallenge(ctx, g.Tags, "")
 	}
+	if err == sql.ErrNoRows && len(g.Tags) > 0 {
+		log.Printf("no published challenges in room %s have the tags %v",
+			g.room, g.Tags)
+		return datastore.GetRandomChallenge(ctx, nil, "")
+	}
+	return chlng, err
+}
 
-	g.Hub.broadcast <- &event{
-		Type:   challengeSet,
-		UserID: -1,
-		Body: &challengeSetEvent{
-			Challenge: chlng,
-		},
+// startRound creates a new round for chlng and makes it the current round.
+// The round is saved in the same transaction as the event that starts it.
+func (g *game) startRound(chlng *model.Challenge) (*model.Round, error) {
+	round := &model.Round{Room: g.room, ChallengeID: chlng.ID}
+	e := &model.GameEvent{
+		Room:        g.room,
+		Type:        roundStartedState,
+		ChallengeID: chlng.ID,
+		Seconds:     chlng.Seconds,
 	}
+	if err := inTx(func(ctx context.Context) error {
+		if err := datastore.SaveRound(ctx, round); err != nil {
+			return err
+		}
+		e.RoundID = round.ID
+		return datastore.SaveGameEvent(ctx, e)
+	}); err != nil {
+		return nil, err
+	}
+	if err := g.project(e); err != nil {
+		return nil, err
+	}
+	return round, nil
+}
 
-	return nil
+func (g *game) startBreak(seconds int) error {
+	return g.emit(&model.GameEvent{
+		Type:    breakStartedState,
+		Seconds: seconds,
+	})
 }
 
 func (g *game) currentUserIDs() ([]int64, error) {
 	c := g.pool.Get()
 	defer c.Close()
-	reply, err := redis.Strings(c.Do("SMEMBERS", currentUserIDsKey))
+	reply, err := redis.Strings(c.Do("SMEMBERS", g.key(currentUserIDsKey)))
 	if err != nil {
 		return nil, err
 	}
@@ -249,55 +655,95 @@ func (g *game) currentUserIDs() ([]int64, error) {
 }
 
 func (g *game) addCurrentUser(u *model.User) error {
-	c := g.pool.Get()
-	defer c.Close()
-	if err := c.Send("SADD", currentUserIDsKey, u.ID); err != nil {
+	if err := g.emit(&model.GameEvent{
+		Type:   userJoinedState,
+		UserID: u.ID,
+		Node:   nodeID,
+	}); err != nil {
 		return err
 	}
-	evt := event{
-		Type:   userJoined,
+	evt := protocol.Event{
+		Type:   protocol.UserJoined,
 		UserID: u.ID,
-		Body: &userJoinedEvent{
+		Body: &protocol.UserJoinedEvent{
 			User: u,
 		},
 	}
-	g.Hub.broadcast <- evt
+	g.broadcast(evt)
 	return nil
 }
 
-func (g *game) removeCurrentUser(id int64) error {
+// joined sends a player who just joined the room on this node the game's
+// state, and tells everyone else they're here.
+func (g *game) joined(u *model.User) {
+	sendInitialState(&g.Hub, u.ID)
+	if err := g.addCurrentUser(u); err != nil {
+		log.Println(err)
+	}
+	if err := g.arrived(u.ID); err != nil {
+		log.Println(err)
+	}
+}
+
+// left removes a player whose session on this node has ended from the room,
+// unless they've joined it again from another node since.
+func (g *game) left(userID int64) {
 	c := g.pool.Get()
-	defer c.Close()
-	if err := c.Send("SREM", currentUserIDsKey, id); err != nil {
+	node, err := redis.String(c.Do("HGET", g.key(currentUserNodesKey), userID))
+	c.Close()
+	if err == redis.ErrNil {
+		return
+	} else if err != nil {
+		log.Println(err)
+		return
+	}
+	if node != nodeID {
+		return
+	}
+	if err := g.removeCurrentUser(userID); err != nil {
+		log.Println(err)
+	}
+}
+
+func (g *game) removeCurrentUser(id int64) error {
+	if err := g.emit(&model.GameEvent{
+		Type:   userLeftState,
+		UserID: id,
+	}); err != nil {
 		return err
 	}
-	evt := event{
-		Type:   userLeft,
+	evt := protocol.Event{
+		Type:   protocol.UserLeft,
 		UserID: id,
-		Body: &userLeftEvent{
+		Body: &protocol.UserLeftEvent{
 			UserID: id,
 		},
 	}
-	g.Hub.broadcast <- evt
-	return nil
+	g.broadcast(evt)
+	return g.leftRoom(id)
 }
 
 func (g *game) timeRemaining() (remaining int, err error) {
 	c := g.pool.Get()
 	defer c.Close()
-	remaining, err = redis.Int(c.Do("GET", timeRemainingKey))
+	remaining, err = redis.Int(c.Do("GET", g.key(timeRemainingKey)))
 	if err != nil {
 		return 0, err
 	}
 	return remaining, err
 }
 
+// decrTimeRemaining counts the timer down by a second, as long as this node
+// still owns it. It returns errLostTimer if another node has taken over.
 func (g *game) decrTimeRemaining() (finished bool, remaining int,
 	err error) {
 	c := g.pool.Get()
 	defer c.Close()
-	remaining, err = redis.Int(c.Do("DECR", timeRemainingKey))
-	if err != nil {
+	remaining, err = redis.Int(tickTimerScript.Do(c, g.key(timerLockKey),
+		g.key(timeRemainingKey), nodeID, int(timerLockTTL/time.Millisecond)))
+	if err == redis.ErrNil {
+		return false, 0, errLostTimer
+	} else if err != nil {
 		return false, 0, err
 	}
 	if remaining <= 0 {
@@ -306,28 +752,16 @@ func (g *game) decrTimeRemaining() (finished bool, remaining int,
 	return finished, remaining, err
 }
 
-func (g *game) setTimeRemaining(seconds int) error {
-	c := g.pool.Get()
-	defer c.Close()
-	if err := c.Send("SET", timeTotalKey, seconds); err != nil {
-		return err
-	}
-	if err := c.Send("SET", timeRemainingKey, seconds); err != nil {
-		return err
-	}
-	return nil
-}
-
 func (g *game) totalTime() (int, error) {
 	c := g.pool.Get()
 	defer c.Close()
-	return redis.Int(c.Do("GET", timeTotalKey))
+	return redis.Int(c.Do("GET", g.key(timeTotalKey)))
 }
 
 func (g *game) isBreak() (bool, error) {
 	c := g.pool.Get()
 	defer c.Close()
-	isBreak, err := redis.Bool(c.Do("GET", breakKey))
+	isBreak, err := redis.Bool(c.Do("GET", g.key(breakKey)))
 	if err != nil {
 		if err == redis.ErrNil {
 			return false, nil
@@ -337,15 +771,39 @@ func (g *game) isBreak() (bool, error) {
 	return isBreak, nil
 }
 
-func (g *game) setBreak(isBreak bool) error {
+// addSolver records that the user solved the current round's challenge. Only
+// a user's first solve in a round is logged.
+func (g *game) addSolver(userID int64) error {
+	roundID, err := g.currentRoundID()
+	if err != nil {
+		return err
+	}
 	c := g.pool.Get()
 	defer c.Close()
-	return c.Send("SET", breakKey, isBreak)
+	solved, err := redis.Bool(c.Do("SISMEMBER", g.key(roundSolversKey), userID))
+	if err != nil {
+		return err
+	}
+	if solved {
+		return nil
+	}
+	return g.emit(&model.GameEvent{
+		Type:    solvedState,
+		RoundID: roundID,
+		UserID:  userID,
+	})
 }
 
 func (g *game) startTimer() {
 	ticker := time.NewTicker(time.Second)
-	for _ = range ticker.C {
+	defer ticker.Stop()
+	var owner bool
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.Hub.done:
+			return
+		}
 		defer func() {
 			if r := recover(); r != nil {
 				var buf bytes.Buffer
@@ -355,13 +813,51 @@ func (g *game) startTimer() {
 			}
 		}()
 
-		ctx, cancel := context.WithCancel(context.Background())
-		ctx, err := datastore.NewContextWithTx(ctx)
+		if err := g.heartbeat(); err != nil {
+			log.Println(err)
+		}
+		wasOwner := owner
+		var err error
+		owner, err = g.ownsTimer()
 		if err != nil {
-			panic(err)
+			log.Println(err)
+			continue
+		}
+		if !owner {
+			continue
+		}
+		if !wasOwner {
+			if err := g.takeOver(); err != nil {
+				panic(err)
+			}
+		}
+		if err := g.sweepUsers(); err != nil {
+			log.Println(err)
 		}
 
 		finished, remaining, err := g.decrTimeRemaining()
+		if err == errLostTimer {
+			owner = false
+			continue
+		} else if err != nil {
+			panic(err)
+		}
+		if finished {
+			// The lock was renewed along with the timer, but check it again
+			// in case this node stalled since, so that a node that took
+			// over in the meantime is the only one to move the room on.
+			owner, err = g.ownsTimer()
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if !owner {
+				continue
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx, err = datastore.NewContextWithTx(ctx)
 		if err != nil {
 			panic(err)
 		}
@@ -372,10 +868,10 @@ func (g *game) startTimer() {
 		}
 
 		if finished {
-			g.Hub.broadcast <- &event{
-				Type:   timerFinished,
+			g.broadcast(&protocol.Event{
+				Type:   protocol.TimerFinished,
 				UserID: -1,
-			}
+			})
 
 			isBreak, err := g.isBreak()
 			if err != nil {
@@ -383,52 +879,63 @@ func (g *game) startTimer() {
 			}
 
 			if isBreak {
-				if err := g.setBreak(false); err != nil {
-					panic(err)
-				}
-
-				var challenge *model.Challenge
-				for {
-					var err error
-					challenge, err = datastore.GetRandomChallenge(ctx)
-					if err != nil {
-						// TODO: Figure out why this is failing instead of working around
-						// with a loop.
-						if err == sql.ErrNoRows {
-							continue
-						}
+				challenge, err := g.randomChallenge(ctx)
+				if err == sql.ErrNoRows {
+					log.Printf("room %s has no published challenges to play",
+						g.room)
+					if err := g.startBreak(noChallengesBreak); err != nil {
 						panic(err)
 					}
-					break
-				}
-
-				if err := g.setCurrentChallenge(challenge); err != nil {
-					panic(err)
-				}
-				if err := g.setTimeRemaining(challenge.Seconds); err != nil {
+					g.broadcast(&protocol.Event{
+						Type:   protocol.NoChallenges,
+						UserID: -1,
+					})
+				} else if err != nil {
 					panic(err)
+				} else {
+					if _, err := g.startRound(challenge); err != nil {
+						panic(err)
+					}
+					// Snapshot the room's state every round, so that
+					// taking it over only replays the latest events.
+					go func() {
+						if _, err := g.replay(); err != nil {
+							log.Println(err)
+						}
+					}()
+					g.broadcast(&protocol.Event{
+						Type:   protocol.ChallengeSet,
+						UserID: -1,
+						Body: &protocol.ChallengeSetEvent{
+							Challenge: challenge.Public(),
+						},
+					})
 				}
 			} else {
-				if err := g.setBreak(true); err != nil {
+				roundID, err := g.currentRoundID()
+				if err != nil {
 					panic(err)
 				}
-				if err := g.setTimeRemaining(3); err != nil {
+				if err := g.startBreak(3); err != nil {
 					panic(err)
 				}
-				g.Hub.broadcast <- &event{
-					Type:   breakStarted,
+				rateRoundLater(roundID)
+				g.broadcast(&protocol.Event{
+					Type:   protocol.BreakStarted,
 					UserID: -1,
-				}
+				})
 			}
 		} else {
-			g.Hub.broadcast <- &event{
-				Type:   timerChanged,
+			// Ticks aren't recorded, since a replay can count down from
+			// when the round or break started.
+			g.publish(&protocol.Event{
+				Type:   protocol.TimerChanged,
 				UserID: -1,
-				Body: &timerChangedEvent{
+				Body: &protocol.TimerChangedEvent{
 					Remaining: remaining,
 					Total:     total,
 				},
-			}
+			})
 		}
 		tx, _ := datastore.TxFromContext(ctx)
 		tx.Commit()
@@ -437,8 +944,58 @@ func (g *game) startTimer() {
 }
 
 func (g *game) Run() {
-	g.setTimeRemaining(5)
+	if err := g.heartbeat(); err != nil {
+		log.Println(err)
+	}
 	go g.Hub.run()
+	go g.subscribe()
 	go g.startTimer()
-	go g.dockerRunner.Run()
+	go g.codeRunner.Run()
+	go g.recorder.run(g.Hub.done)
+}
+
+// stop shuts the room down on this node, once nobody here is in it. It must
+// only be called once.
+func (g *game) stop() {
+	close(g.Hub.done)
+	g.codeRunner.queue.close()
+	if g.room != MainRoom {
+		if err := g.clearKeys(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// roomKeys are the keys each room keeps its state in.
+var roomKeys = []redisKey{
+	currentChallengeIDKey, currentRoundIDKey, currentUserIDsKey,
+	currentUserNodesKey, timeTotalKey, timeRemainingKey, breakKey,
+	roundSolversKey, timerLockKey, chatHistoryKey, mutedKey, kickedKey,
+	matchedPlayersKey,
+}
+
+// clearRoomScript deletes KEYS, as long as nobody is in the room whose
+// current users are kept in the first of them.
+var clearRoomScript = redis.NewScript(len(roomKeys), `
+if redis.call("SCARD", KEYS[1]) > 0 then
+	return 0
+end
+return redis.call("DEL", unpack(KEYS))
+`)
+
+// clearKeys deletes the room's keys from Redis if nobody on any node is in it
+// anymore, so rooms that are done with don't take up space there forever. Its
+// state can still be rebuilt from its game events if anyone joins it again.
+func (g *game) clearKeys() error {
+	if err := g.sweepUsers(); err != nil {
+		return err
+	}
+	c := g.pool.Get()
+	defer c.Close()
+	args := make([]interface{}, len(roomKeys))
+	for i, k := range roomKeys {
+		args[i] = g.key(k)
+	}
+	_, err := clearRoomScript.Do(c, args...)
+	return err
 }
@@ -0,0 +1,446 @@
+package game
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// hubCalls counts the hooks a hub calls for each player.
+type hubCalls struct {
+	mu      sync.Mutex
+	joined  map[int64]int
+	resyncs map[int64]int
+	left    map[int64]int
+}
+
+func (c *hubCalls) add(m map[int64]int, userID int64) {
+	c.mu.Lock()
+	m[userID]++
+	c.mu.Unlock()
+}
+
+func (c *hubCalls) get(m map[int64]int, userID int64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return m[userID]
+}
+
+// startHub runs a hub that isn't part of a game, recording the hooks it
+// calls instead of talking to Redis and the database.
+func startHub() (*hub, *hubCalls) {
+	calls := &hubCalls{
+		joined:  make(map[int64]int),
+		resyncs: make(map[int64]int),
+		left:    make(map[int64]int),
+	}
+	h := newHub()
+	h.joined = func(u *model.User) { calls.add(calls.joined, u.ID) }
+	h.resync = func(userID int64) { calls.add(calls.resyncs, userID) }
+	h.left = func(userID int64) { calls.add(calls.left, userID) }
+	go h.run()
+	return &h, calls
+}
+
+func newTestConn(u *model.User, resume string, lastSeq int64) *conn {
+	return &conn{
+		send:    make(chan interface{}, 256),
+		user:    u,
+		resume:  resume,
+		lastSeq: lastSeq,
+		version: protocol.Version,
+	}
+}
+
+// received is everything a connection was sent before it was closed.
+type received struct {
+	started *protocol.SessionStartedEvent
+	seqs    []int64
+	err     string
+}
+
+// drain reads from c until the hub closes it.
+func drain(c *conn) *received {
+	r := &received{}
+	for v := range c.send {
+		m := v.(*message)
+		if r.started == nil {
+			if m.Type != protocol.SessionStarted || m.Seq != 0 {
+				r.err = "first message wasn't an unnumbered sessionStarted"
+			}
+			r.started = &protocol.SessionStartedEvent{}
+			if err := json.Unmarshal(m.Body, r.started); err != nil {
+				r.err = err.Error()
+			}
+			continue
+		}
+		if m.Seq == 0 {
+			r.err = "got an unnumbered message after sessionStarted"
+		}
+		r.seqs = append(r.seqs, m.Seq)
+	}
+	if r.started == nil && r.err == "" {
+		r.err = "closed without a sessionStarted message"
+	}
+	return r
+}
+
+func newBroadcast(i int) *message {
+	m, err := newMessage(&protocol.Event{
+		Type:   protocol.TimerChanged,
+		UserID: -1,
+		Body:   &protocol.TimerChangedEvent{Remaining: i, Total: i},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// TestHubUnderLoad connects, resumes, replaces and disconnects many players
+// at once while messages are broadcast to them, checking that each player's
+// messages arrive numbered without gaps or repeats across their connections.
+func TestHubUnderLoad(t *testing.T) {
+	const (
+		users       = 40
+		connects    = 25
+		senders     = 4
+		broadcasts  = 1000
+		lookups     = 2000
+		freshEveryN = 5
+	)
+	h, calls := startHub()
+
+	stop := make(chan struct{})
+	var bg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			for j := 0; j < broadcasts; j++ {
+				select {
+				case h.broadcast <- newBroadcast(j):
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	bg.Add(1)
+	go func() {
+		defer bg.Done()
+		for i := 0; i < lookups; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.conn(int64(rand.Intn(users) + 1))
+		}
+	}()
+
+	tokens := make([]string, users)
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			u := &model.User{ID: int64(i + 1)}
+			var token string
+			var lastSeq int64
+			for n := 0; n < connects; n++ {
+				resume := token
+				if n%freshEveryN == freshEveryN-1 {
+					resume = ""
+				}
+				c := newTestConn(u, resume, lastSeq)
+				h.register <- c
+				done := make(chan *received, 1)
+				go func() { done <- drain(c) }()
+				time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+				h.unregister <- c
+
+				var r *received
+				select {
+				case r = <-done:
+				case <-time.After(10 * time.Second):
+					t.Errorf("user %d: connection %d was never closed", u.ID, n)
+					return
+				}
+				if r.err != "" {
+					t.Errorf("user %d: connection %d: %s", u.ID, n, r.err)
+					return
+				}
+				if !checkSession(t, u.ID, n, resume, token, lastSeq, r) {
+					return
+				}
+				token = r.started.Token
+				if len(r.seqs) > 0 {
+					lastSeq = r.seqs[len(r.seqs)-1]
+				} else if !r.started.Resumed {
+					lastSeq = 0
+				}
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	bg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Every new session after the first needs the game's state, and so do
+	// some resumed ones.
+	waitFor(t, func() bool {
+		for id := int64(1); id <= users; id++ {
+			if calls.get(calls.joined, id) < 1 ||
+				calls.get(calls.resyncs, id) < connects/freshEveryN {
+				return false
+			}
+		}
+		return true
+	}, "every player to be sent the game's state")
+	for id := int64(1); id <= users; id++ {
+		if n := calls.get(calls.joined, id); n != 1 {
+			t.Errorf("user %d was reported joining %d times", id, n)
+		}
+		if n := calls.get(calls.left, id); n != 0 {
+			t.Errorf("user %d was reported leaving %d times", id, n)
+		}
+	}
+
+	sessions := make(chan map[int64]*session)
+	h.queries <- func(s map[int64]*session) {
+		// Copy what's checked, since the map belongs to run.
+		cp := make(map[int64]*session, len(s))
+		for id, sess := range s {
+			cp[id] = &session{token: sess.token, conn: sess.conn}
+		}
+		sessions <- cp
+	}
+	got := <-sessions
+	if len(got) != users {
+		t.Fatalf("hub has %d sessions, want %d", len(got), users)
+	}
+	for i, tok := range tokens {
+		s := got[int64(i+1)]
+		if s == nil {
+			t.Errorf("user %d has no session", i+1)
+			continue
+		}
+		if s.token != tok {
+			t.Errorf("user %d's session is %s, want %s", i+1, s.token, tok)
+		}
+		if s.conn != nil {
+			t.Errorf("user %d's session still has a connection", i+1)
+		}
+	}
+}
+
+// checkSession checks what connection n of a player got. A resumed session
+// carries on from the last message the player saw, unless so many were sent
+// while they were away that they had to be sent the game's state instead. A
+// new session starts numbering from 1.
+func checkSession(t *testing.T, userID int64, n int, resume, token string,
+	lastSeq int64, r *received) bool {
+	wantResumed := resume != ""
+	if r.started.Resumed != wantResumed {
+		t.Errorf("user %d: connection %d resumed = %v, want %v", userID, n,
+			r.started.Resumed, wantResumed)
+		return false
+	}
+	if wantResumed && r.started.Token != token {
+		t.Errorf("user %d: connection %d resumed session %s, want %s", userID,
+			n, r.started.Token, token)
+		return false
+	}
+	if !wantResumed && r.started.Token == token {
+		t.Errorf("user %d: connection %d reused session %s", userID, n, token)
+		return false
+	}
+	for i := 1; i < len(r.seqs); i++ {
+		if r.seqs[i] != r.seqs[i-1]+1 {
+			t.Errorf("user %d: connection %d got message %d after %d", userID,
+				n, r.seqs[i], r.seqs[i-1])
+			return false
+		}
+	}
+	if len(r.seqs) == 0 {
+		return true
+	}
+	first := r.seqs[0]
+	switch {
+	case !wantResumed && first != 1:
+		t.Errorf("user %d: connection %d started a session at message %d",
+			userID, n, first)
+		return false
+	case wantResumed && first != lastSeq+1 &&
+		first <= lastSeq+sessionBacklog+1:
+		t.Errorf("user %d: connection %d resumed at message %d after seeing "+
+			"up to %d", userID, n, first, lastSeq)
+		return false
+	}
+	return true
+}
+
+// TestHubReplacesConnections checks that connecting again closes the
+// player's old connection, and that the old connection leaving afterwards
+// doesn't disconnect the new one.
+func TestHubReplacesConnections(t *testing.T) {
+	h, _ := startHub()
+	u := &model.User{ID: 1}
+
+	old := newTestConn(u, "", 0)
+	h.register <- old
+	oldDone := make(chan *received, 1)
+	go func() { oldDone <- drain(old) }()
+
+	c := newTestConn(u, "", 0)
+	h.register <- c
+	select {
+	case r := <-oldDone:
+		if r.err != "" {
+			t.Fatal(r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old connection wasn't closed")
+	}
+
+	h.unregister <- old
+	h.broadcast <- newBroadcast(1)
+	if h.conn(u.ID) != c {
+		t.Fatal("new connection was dropped when the old one left")
+	}
+	<-c.send // sessionStarted
+	if m := (<-c.send).(*message); m.Seq != 1 {
+		t.Fatalf("got message %d, want 1", m.Seq)
+	}
+}
+
+// TestHubKick checks that a kicked player's session ends at once, on every
+// connection, and that they're reported leaving exactly once.
+func TestHubKick(t *testing.T) {
+	h, calls := startHub()
+	const users = 20
+	conns := make([]*conn, users)
+	done := make([]chan *received, users)
+	for i := range conns {
+		conns[i] = newTestConn(&model.User{ID: int64(i + 1)}, "", 0)
+		h.register <- conns[i]
+		done[i] = make(chan *received, 1)
+		go func(i int) { done[i] <- drain(conns[i]) }(i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < users; i += 2 {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			m, err := newMessage(&protocol.Event{
+				Type:   protocol.UserKicked,
+				UserID: userID,
+				Body:   &protocol.UserKickedEvent{UserID: userID},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			h.broadcast <- m
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	for i := 0; i < users; i += 2 {
+		select {
+		case <-done[i]:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("user %d's connection wasn't closed", i+1)
+		}
+	}
+	waitFor(t, func() bool {
+		for i := 0; i < users; i += 2 {
+			if calls.get(calls.left, int64(i+1)) != 1 {
+				return false
+			}
+		}
+		return true
+	}, "kicked players to be reported leaving")
+	for i := 1; i < users; i += 2 {
+		if h.conn(int64(i+1)) != conns[i] {
+			t.Errorf("user %d was disconnected without being kicked", i+1)
+		}
+		if n := calls.get(calls.left, int64(i+1)); n != 0 {
+			t.Errorf("user %d was reported leaving %d times", i+1, n)
+		}
+	}
+}
+
+// waitFor polls cond until it's true, failing the test if it takes too long.
+func waitFor(t *testing.T, cond func() bool, what string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHubStop checks that stopping a room closes the connections still in it,
+// reports their players leaving, and that nothing waiting on the hub blocks
+// afterwards.
+func TestHubStop(t *testing.T) {
+	h, calls := startHub()
+	const users = 5
+	done := make([]chan *received, users)
+	for i := 0; i < users; i++ {
+		c := newTestConn(&model.User{ID: int64(i + 1)}, "", 0)
+		h.register <- c
+		done[i] = make(chan *received, 1)
+		go func(i int) { done[i] <- drain(c) }(i)
+	}
+	if n := h.sessionCount(); n != users {
+		t.Fatalf("hub has %d sessions, want %d", n, users)
+	}
+
+	close(h.done)
+	for i := range done {
+		select {
+		case <-done[i]:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("user %d's connection wasn't closed", i+1)
+		}
+	}
+	waitFor(t, func() bool {
+		for id := int64(1); id <= users; id++ {
+			if calls.get(calls.left, id) != 1 {
+				return false
+			}
+		}
+		return true
+	}, "players to be reported leaving")
+
+	finished := make(chan struct{})
+	go func() {
+		h.sendUnrecorded(1, &protocol.Event{Type: protocol.Ack, UserID: -1})
+		if c := h.conn(1); c != nil {
+			t.Error("stopped hub returned a connection")
+		}
+		if n := h.sessionCount(); n != 0 {
+			t.Errorf("stopped hub has %d sessions", n)
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("using a stopped hub blocked")
+	}
+}
@@ -0,0 +1,161 @@
+package game
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// rate is how often something may happen: burst times at once, refilling at
+// perSecond.
+type rate struct {
+	perSecond float64
+	burst     float64
+}
+
+var (
+	// eventRates limits how often each player can send each type of event.
+	eventRates = map[protocol.EventType]rate{
+		protocol.RunCode:    {perSecond: 1.0 / 5, burst: 2},
+		protocol.SubmitCode: {perSecond: 1.0 / 5, burst: 2},
+		protocol.RunSamples: {perSecond: 1, burst: 3},
+		protocol.SendChat:   {perSecond: 2, burst: 5},
+		protocol.MuteUser:   {perSecond: 1, burst: 5},
+		protocol.KickUser:   {perSecond: 1, burst: 5},
+		protocol.JoinQueue:  {perSecond: 1.0 / 5, burst: 3},
+		protocol.LeaveQueue: {perSecond: 1.0 / 2, burst: 3},
+	}
+
+	// totalRate limits how often each player can send events of any type,
+	// including ones that are malformed.
+	totalRate = rate{perSecond: 5, burst: 10}
+
+	// strikeRate is how often a player can break the limits above before
+	// they're disconnected for flooding.
+	strikeRate = rate{perSecond: 20.0 / 60, burst: 20}
+)
+
+// maxQueuedRuns is how many of a player's runs can be waiting for or using
+// a worker at once.
+const maxQueuedRuns = 2
+
+// bucket is a token bucket.
+type bucket struct {
+	rate   rate
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(r rate) *bucket {
+	return &bucket{rate: r, tokens: r.burst, last: time.Now()}
+}
+
+// take takes a token from the bucket, reporting whether there was one.
+func (b *bucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate.perSecond
+	if b.tokens > b.rate.burst {
+		b.tokens = b.rate.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// userLimits are the buckets and queued runs for a single player.
+type userLimits struct {
+	events  map[protocol.EventType]*bucket
+	total   *bucket
+	strikes *bucket
+	runs    int
+}
+
+// limiter enforces how often players can send events and how many runs they
+// can queue. It's kept per user rather than per connection so reconnecting
+// doesn't reset it, and has its own lock so connections can check it without
+// going through the hub.
+type limiter struct {
+	mu    sync.Mutex
+	users map[int64]*userLimits
+}
+
+func newLimiter() *limiter {
+	return &limiter{users: make(map[int64]*userLimits)}
+}
+
+func (l *limiter) user(userID int64) *userLimits {
+	u := l.users[userID]
+	if u == nil {
+		u = &userLimits{
+			events:  make(map[protocol.EventType]*bucket),
+			total:   newBucket(totalRate),
+			strikes: newBucket(strikeRate),
+		}
+		l.users[userID] = u
+	}
+	return u
+}
+
+// allow reports whether userID may send an event of type t now. An empty t
+// counts only against the total limit.
+func (l *limiter) allow(userID int64, t protocol.EventType) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.user(userID)
+	now := time.Now()
+	if !u.total.take(now) {
+		return false
+	}
+	r, ok := eventRates[t]
+	if !ok {
+		return true
+	}
+	b := u.events[t]
+	if b == nil {
+		b = newBucket(r)
+		u.events[t] = b
+	}
+	return b.take(now)
+}
+
+// strike records that userID broke a limit or sent something invalid, and
+// reports whether they've done so often enough to be considered abusive.
+func (l *limiter) strike(userID int64) (abusive bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.user(userID).strikes.take(time.Now())
+}
+
+// acquireRun reserves one of userID's queued runs, reporting whether they
+// had one left. Reserved runs must be given back with releaseRun.
+func (l *limiter) acquireRun(userID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	u := l.user(userID)
+	if u.runs >= maxQueuedRuns {
+		return false
+	}
+	u.runs++
+	return true
+}
+
+func (l *limiter) releaseRun(userID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u := l.users[userID]; u != nil && u.runs > 0 {
+		u.runs--
+	}
+}
+
+// forget drops userID's limits once they've left the room, unless they still
+// have runs queued.
+func (l *limiter) forget(userID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if u := l.users[userID]; u != nil && u.runs == 0 {
+		delete(l.users, userID)
+	}
+}
@@ -0,0 +1,148 @@
+package game
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/redisutil"
+	"github.com/zachlatta/calhacks/runner"
+)
+
+// MainRoom is the room players join unless they've been matched into another
+// one.
+const MainRoom = "main"
+
+// reapInterval is how often each node looks for rooms it can stop.
+const reapInterval = time.Minute
+
+// Lobby holds the rooms this node serves players in. The main room always
+// runs; rooms players are matched into are started on a node the first time
+// one of their players connects to it, and stopped again once nobody on the
+// node is in them and nobody matched into them is still on their way.
+type Lobby struct {
+	pool     *redis.Pool
+	executor runner.Executor
+	main     *game
+
+	mu    sync.Mutex
+	games map[string]*game
+}
+
+func NewLobby() *Lobby {
+	pool := redisutil.NewPool()
+	executor := newExecutor(pool)
+	main := NewGame(MainRoom, pool, executor)
+	return &Lobby{
+		pool:     pool,
+		executor: executor,
+		main:     main,
+		games:    map[string]*game{MainRoom: main},
+	}
+}
+
+// Main returns the main room.
+func (l *Lobby) Main() *game {
+	return l.main
+}
+
+// Executor returns what every room on this node runs code with, so
+// challenges can be checked outside of a round.
+func (l *Lobby) Executor() runner.Executor {
+	return l.executor
+}
+
+// Run starts the main room, along with the jobs that only need to run once
+// on each node rather than in every room.
+func (l *Lobby) Run() {
+	l.main.Run()
+	go l.main.rejudger()
+	go l.main.calibrator()
+	go l.matchmaker()
+	go l.reaper()
+}
+
+// Room returns the room with the given name, starting it on this node if it
+// isn't running here yet. Check that players may join a room with CanJoin
+// first.
+func (l *Lobby) Room(room string) *game {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.games[room]
+	if !ok {
+		g = NewGame(room, l.pool, l.executor)
+		l.games[room] = g
+		go g.Run()
+	}
+	return g
+}
+
+func (l *Lobby) reaper() {
+	for _ = range time.Tick(reapInterval) {
+		l.reap()
+	}
+}
+
+// reap stops the rooms this node doesn't need to run anymore. Players who
+// connect to a room just as it's stopped are disconnected, and start it again
+// when they reconnect.
+func (l *Lobby) reap() {
+	l.mu.Lock()
+	rooms := make([]string, 0, len(l.games))
+	for room := range l.games {
+		if room != MainRoom {
+			rooms = append(rooms, room)
+		}
+	}
+	l.mu.Unlock()
+
+	for _, room := range rooms {
+		if g := l.idleRoom(room); g != nil {
+			g.stop()
+		}
+	}
+}
+
+// idleRoom removes room from the lobby and returns it if nobody on this node
+// is in it and nobody matched into it is still on their way. It's checked
+// while holding the lock so nobody can be handed the room in the meantime.
+func (l *Lobby) idleRoom(room string) *game {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.games[room]
+	if !ok || g.Hub.sessionCount() > 0 {
+		return nil
+	}
+	awaiting, err := g.awaitingPlayers()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	if awaiting {
+		return nil
+	}
+	delete(l.games, room)
+	return g
+}
+
+// CanJoin reports whether a player may join room. Anyone can join the main
+// room, but other rooms can only be joined by the players matched into them.
+func (l *Lobby) CanJoin(userID int64, room string) (bool, error) {
+	if room == MainRoom {
+		return true, nil
+	}
+	c := l.pool.Get()
+	defer c.Close()
+	matched, err := redis.String(c.Do("GET", matchKey(userID)))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return matched == room, err
+}
+
+// matchKey is where the room a player was last matched into is kept.
+func matchKey(userID int64) string {
+	return "match:" + strconv.FormatInt(userID, 10)
+}
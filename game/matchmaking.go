@@ -0,0 +1,426 @@
+package game
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// mode is a way of playing that players can queue for.
+type mode struct {
+	minPlayers int
+	maxPlayers int
+}
+
+var modes = map[string]mode{
+	"duel": {minPlayers: 2, maxPlayers: 2},
+	"ffa":  {minPlayers: 3, maxPlayers: 8},
+}
+
+const (
+	// matchInterval is how often the matchmaker looks for matches. Only the
+	// node holding the matchmaker lock does.
+	matchInterval     = time.Second
+	matchmakerLockTTL = 3 * time.Second
+
+	// Players are matched with others within their bracket, which starts at
+	// baseBracket rating points either side of their own rating and widens
+	// by bracketGrowth points every second they wait, up to maxBracket.
+	baseBracket   = 100
+	bracketGrowth = 10
+	maxBracket    = 1000
+
+	// regionPatience and langPatience are how long players wait before
+	// they're matched with players from other regions, or using other
+	// languages.
+	regionPatience = 30 * time.Second
+	langPatience   = 60 * time.Second
+
+	// fillPatience is how long the oldest player in a match waits for it to
+	// fill up before starting it with fewer players.
+	fillPatience = 20 * time.Second
+
+	// matchTTL is how long players have to join the room they were matched
+	// into. Reconnecting within it rejoins the room.
+	matchTTL = 10 * time.Minute
+
+	maxRegionLength = 32
+)
+
+// Matchmaking keys aren't namespaced to a room, since players queue from any
+// of them.
+const (
+	matchmakerLockKey = "matchmaker_lock"
+	matchQueueKey     = "match_queue"
+	matchRoomsKey     = "match_rooms"
+)
+
+// takeQueuedScript removes the players in ARGV from the queue, as long as
+// they're all still in it.
+var takeQueuedScript = redis.NewScript(1, `
+for _, id in ipairs(ARGV) do
+	if redis.call("HEXISTS", KEYS[1], id) == 0 then
+		return 0
+	end
+end
+redis.call("HDEL", KEYS[1], unpack(ARGV))
+return 1
+`)
+
+// queueEntry is a player waiting to be matched.
+type queueEntry struct {
+	UserID int64     `json:"user_id"`
+	Mode   string    `json:"mode"`
+	Lang   string    `json:"lang"`
+	Region string    `json:"region"`
+	Rating float64   `json:"rating"`
+	Joined time.Time `json:"joined"`
+
+	// Room is the room the player queued from, where they're told about
+	// their match.
+	Room string `json:"room"`
+}
+
+func joinQueue(h *hub, e *protocol.Event) error {
+	evt := e.Body.(*protocol.JoinQueueEvent)
+	if _, ok := modes[evt.Mode]; !ok {
+		return &eventError{protocol.ErrUnknownMode,
+			fmt.Sprintf("%q isn't a mode that can be queued for", evt.Mode)}
+	}
+	if evt.Lang != "" {
+		supported, err := h.game.codeRunner.executor.Supports(evt.Lang)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return &eventError{protocol.ErrUnsupportedLanguage,
+				fmt.Sprintf("%q isn't a supported language", evt.Lang)}
+		}
+	}
+	region := strings.ToLower(strings.TrimSpace(evt.Region))
+	if len(region) > maxRegionLength {
+		return &eventError{protocol.ErrMalformedEvent,
+			fmt.Sprintf("regions can't be longer than %d characters",
+				maxRegionLength)}
+	}
+
+	entry := &queueEntry{
+		UserID: e.UserID,
+		Mode:   evt.Mode,
+		Lang:   evt.Lang,
+		Region: region,
+		Joined: time.Now(),
+		Room:   h.game.room,
+	}
+	if err := inTx(func(ctx context.Context) error {
+		r, err := matchRating(ctx, e.UserID, evt.Lang)
+		if err != nil {
+			return err
+		}
+		entry.Rating = r.Rating
+		return nil
+	}); err != nil {
+		return err
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	c := h.game.pool.Get()
+	defer c.Close()
+	_, err = c.Do("HSET", matchQueueKey, e.UserID, body)
+	return err
+}
+
+func leaveQueue(h *hub, e *protocol.Event) error {
+	c := h.game.pool.Get()
+	defer c.Close()
+	_, err := c.Do("HDEL", matchQueueKey, e.UserID)
+	return err
+}
+
+// arrived notes that a player matched into the room has joined it, so the
+// room no longer needs to wait for them.
+func (g *game) arrived(userID int64) error {
+	if g.room == MainRoom {
+		return nil
+	}
+	c := g.pool.Get()
+	defer c.Close()
+	_, err := c.Do("SREM", g.key(matchedPlayersKey), userID)
+	return err
+}
+
+// awaitingPlayers reports whether any player matched into the room has yet to
+// join it, and can still.
+func (g *game) awaitingPlayers() (bool, error) {
+	c := g.pool.Get()
+	defer c.Close()
+	ids, err := redis.Strings(c.Do("SMEMBERS", g.key(matchedPlayersKey)))
+	if err != nil {
+		return false, err
+	}
+	for _, s := range ids {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		room, err := redis.String(c.Do("GET", matchKey(id)))
+		if err == redis.ErrNil {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		if room == g.room {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// leftRoom takes a player who left the room out of the matchmaking queue if
+// they queued from it, since they can no longer be told about their match.
+func (g *game) leftRoom(userID int64) error {
+	c := g.pool.Get()
+	defer c.Close()
+	body, err := redis.Bytes(c.Do("HGET", matchQueueKey, userID))
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var e queueEntry
+	if err := json.Unmarshal(body, &e); err != nil {
+		return err
+	}
+	if e.Room != g.room {
+		return nil
+	}
+	_, err = c.Do("HDEL", matchQueueKey, userID)
+	return err
+}
+
+// matchRating is the rating a player is matched by: their rating in lang if
+// they have one, or their overall rating if not.
+func matchRating(ctx context.Context, userID int64,
+	lang string) (*model.Rating, error) {
+	if lang != "" {
+		r, err := datastore.GetRating(ctx, userID, lang)
+		if err != sql.ErrNoRows {
+			return r, err
+		}
+	}
+	return getRating(ctx, userID, "")
+}
+
+// matchmaker matches queued players every matchInterval, whenever this node
+// holds the matchmaker lock.
+func (l *Lobby) matchmaker() {
+	for _ = range time.Tick(matchInterval) {
+		owner, err := ownsLock(l.pool, matchmakerLockKey, matchmakerLockTTL)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !owner {
+			continue
+		}
+		if err := l.makeMatches(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// makeMatches matches the players in the queue that can be, and tells them
+// which room to join.
+func (l *Lobby) makeMatches() error {
+	c := l.pool.Get()
+	defer c.Close()
+
+	vals, err := redis.Strings(c.Do("HVALS", matchQueueKey))
+	if err != nil {
+		return err
+	}
+	entries := make([]*queueEntry, 0, len(vals))
+	for _, v := range vals {
+		var e queueEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return err
+		}
+		entries = append(entries, &e)
+	}
+
+	for _, group := range findMatches(entries, time.Now()) {
+		if err := l.startMatch(c, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startMatch takes a group of players out of the queue and gives them a
+// new room. Rooms are never reused, so players who haven't joined the room
+// they were matched into yet can't end up in someone else's match. The group
+// is skipped if any of them left the queue since it was read.
+func (l *Lobby) startMatch(c redis.Conn, group []*queueEntry) error {
+	args := []interface{}{matchQueueKey}
+	userIDs := make([]int64, len(group))
+	for i, e := range group {
+		args = append(args, e.UserID)
+		userIDs[i] = e.UserID
+	}
+	taken, err := redis.Int(takeQueuedScript.Do(c, args...))
+	if err != nil || taken == 0 {
+		return err
+	}
+
+	n, err := redis.Int64(c.Do("INCR", matchRoomsKey))
+	if err != nil {
+		return err
+	}
+	room := "match-" + strconv.FormatInt(n, 10)
+
+	matched := roomKey(room, matchedPlayersKey)
+	for _, e := range group {
+		c.Send("MULTI")
+		c.Send("SET", matchKey(e.UserID), room, "EX",
+			int(matchTTL/time.Second))
+		c.Send("SADD", matched, e.UserID)
+		c.Send("EXPIRE", matched, int(matchTTL/time.Second))
+		if _, err := c.Do("EXEC"); err != nil {
+			return err
+		}
+		if err := publishDirect(l.pool, e.Room, e.UserID, &protocol.Event{
+			Type:   protocol.MatchFound,
+			UserID: -1,
+			Body: &protocol.MatchFoundEvent{
+				Room:    room,
+				Mode:    e.Mode,
+				UserIDs: userIDs,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findMatches groups queued players into matches, starting with whoever has
+// waited longest. Each match is filled with the compatible players closest to
+// its first player's rating.
+func findMatches(entries []*queueEntry, now time.Time) [][]*queueEntry {
+	sort.Sort(byJoined(entries))
+	matched := make(map[int64]bool)
+	var matches [][]*queueEntry
+	for _, e := range entries {
+		m, ok := modes[e.Mode]
+		if !ok || matched[e.UserID] {
+			continue
+		}
+
+		var candidates []*queueEntry
+		for _, o := range entries {
+			if o != e && !matched[o.UserID] && compatible(e, o, now) {
+				candidates = append(candidates, o)
+			}
+		}
+		sort.Sort(byDistance{candidates, e.Rating})
+
+		group := []*queueEntry{e}
+		for _, o := range candidates {
+			if len(group) == m.maxPlayers {
+				break
+			}
+			if compatibleWithAll(group, o, now) {
+				group = append(group, o)
+			}
+		}
+		if len(group) < m.minPlayers ||
+			(len(group) < m.maxPlayers && now.Sub(e.Joined) < fillPatience) {
+			continue
+		}
+		for _, o := range group {
+			matched[o.UserID] = true
+		}
+		matches = append(matches, group)
+	}
+	return matches
+}
+
+// compatible reports whether a and b can be matched. They must be queued for
+// the same mode and be within the wider of their brackets. They must share a
+// region and language too, unless either didn't give one or one of them has
+// waited long enough.
+func compatible(a, b *queueEntry, now time.Time) bool {
+	if a.Mode != b.Mode {
+		return false
+	}
+	waited := now.Sub(a.Joined)
+	if w := now.Sub(b.Joined); w > waited {
+		waited = w
+	}
+	if math.Abs(a.Rating-b.Rating) > bracket(waited) {
+		return false
+	}
+	if a.Region != b.Region && a.Region != "" && b.Region != "" &&
+		waited < regionPatience {
+		return false
+	}
+	if a.Lang != b.Lang && a.Lang != "" && b.Lang != "" &&
+		waited < langPatience {
+		return false
+	}
+	return true
+}
+
+func compatibleWithAll(group []*queueEntry, o *queueEntry,
+	now time.Time) bool {
+	for _, e := range group {
+		if !compatible(e, o, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// bracket is how far apart in rating players can be matched once one of them
+// has waited for d.
+func bracket(d time.Duration) float64 {
+	return math.Min(baseBracket+bracketGrowth*d.Seconds(), maxBracket)
+}
+
+type byJoined []*queueEntry
+
+func (s byJoined) Len() int           { return len(s) }
+func (s byJoined) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byJoined) Less(i, j int) bool { return s[i].Joined.Before(s[j].Joined) }
+
+// byDistance sorts entries by how close their rating is to rating.
+type byDistance struct {
+	entries []*queueEntry
+	rating  float64
+}
+
+func (s byDistance) Len() int { return len(s.entries) }
+
+func (s byDistance) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+func (s byDistance) Less(i, j int) bool {
+	return math.Abs(s.entries[i].Rating-s.rating) <
+		math.Abs(s.entries[j].Rating-s.rating)
+}
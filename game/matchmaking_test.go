@@ -0,0 +1,262 @@
+package game
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var matchNow = time.Date(2014, 10, 1, 12, 0, 0, 0, time.UTC)
+
+// queued is a player who has waited in the queue for waited by matchNow.
+func queued(id int64, mode string, rating float64,
+	waited time.Duration) *queueEntry {
+	return &queueEntry{
+		UserID: id,
+		Mode:   mode,
+		Lang:   "go",
+		Region: "us-west",
+		Rating: rating,
+		Joined: matchNow.Add(-waited),
+	}
+}
+
+func withRegion(e *queueEntry, region string) *queueEntry {
+	e.Region = region
+	return e
+}
+
+func withLang(e *queueEntry, lang string) *queueEntry {
+	e.Lang = lang
+	return e
+}
+
+func TestCompatible(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b *queueEntry
+		want bool
+	}{
+		{"same mode and rating",
+			queued(1, "duel", 1500, 0), queued(2, "duel", 1500, 0), true},
+		{"different modes",
+			queued(1, "duel", 1500, 0), queued(2, "ffa", 1500, 0), false},
+
+		{"outside a new bracket",
+			queued(1, "duel", 1500, 0), queued(2, "duel", 1650, 0), false},
+		{"bracket widened by either player waiting",
+			queued(1, "duel", 1500, 0), queued(2, "duel", 1650, 10*time.Second),
+			true},
+		{"bracket still too narrow",
+			queued(1, "duel", 1500, 5*time.Second),
+			queued(2, "duel", 1700, 0), false},
+		{"bracket capped",
+			queued(1, "duel", 1500, time.Hour), queued(2, "duel", 2600, 0),
+			false},
+		{"within the widest bracket",
+			queued(1, "duel", 1500, time.Hour), queued(2, "duel", 2450, 0),
+			true},
+
+		{"different regions",
+			queued(1, "duel", 1500, 10*time.Second),
+			withRegion(queued(2, "duel", 1500, 0), "eu"), false},
+		{"different regions after regionPatience",
+			queued(1, "duel", 1500, regionPatience),
+			withRegion(queued(2, "duel", 1500, 0), "eu"), true},
+		{"no region given",
+			queued(1, "duel", 1500, 0),
+			withRegion(queued(2, "duel", 1500, 0), ""), true},
+
+		{"different languages",
+			queued(1, "duel", 1500, regionPatience),
+			withLang(queued(2, "duel", 1500, 0), "ruby"), false},
+		{"different languages after langPatience",
+			queued(1, "duel", 1500, langPatience),
+			withLang(queued(2, "duel", 1500, 0), "ruby"), true},
+		{"no language given",
+			queued(1, "duel", 1500, 0),
+			withLang(queued(2, "duel", 1500, 0), ""), true},
+	}
+
+	for _, tt := range tests {
+		if got := compatible(tt.a, tt.b, matchNow); got != tt.want {
+			t.Errorf("%s: compatible(a, b) = %v, want %v", tt.name, got,
+				tt.want)
+		}
+		if got := compatible(tt.b, tt.a, matchNow); got != tt.want {
+			t.Errorf("%s: compatible(b, a) = %v, want %v", tt.name, got,
+				tt.want)
+		}
+	}
+}
+
+func TestFindMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*queueEntry
+		want    [][]int64
+	}{
+		{"duel",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 2*time.Second),
+				queued(2, "duel", 1520, time.Second),
+			},
+			[][]int64{{1, 2}}},
+		{"duel with the closest rating",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 3*time.Second),
+				queued(2, "duel", 1590, 2*time.Second),
+				queued(3, "duel", 1510, time.Second),
+			},
+			[][]int64{{1, 3}}},
+		{"nobody in the bracket yet",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 2*time.Second),
+				queued(2, "duel", 1800, time.Second),
+			},
+			nil},
+		{"bracket widened",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 25*time.Second),
+				queued(2, "duel", 1800, time.Second),
+			},
+			[][]int64{{1, 2}}},
+		{"waiting for the region",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 10*time.Second),
+				withRegion(queued(2, "duel", 1500, time.Second), "eu"),
+			},
+			nil},
+		{"past regionPatience",
+			[]*queueEntry{
+				queued(1, "duel", 1500, regionPatience),
+				withRegion(queued(2, "duel", 1500, time.Second), "eu"),
+			},
+			[][]int64{{1, 2}}},
+		{"waiting for the language",
+			[]*queueEntry{
+				queued(1, "duel", 1500, regionPatience),
+				withLang(queued(2, "duel", 1500, time.Second), "ruby"),
+			},
+			nil},
+		{"past langPatience",
+			[]*queueEntry{
+				queued(1, "duel", 1500, langPatience),
+				withLang(queued(2, "duel", 1500, time.Second), "ruby"),
+			},
+			[][]int64{{1, 2}}},
+		{"ffa waiting to fill",
+			[]*queueEntry{
+				queued(1, "ffa", 1500, 3*time.Second),
+				queued(2, "ffa", 1510, 2*time.Second),
+				queued(3, "ffa", 1520, time.Second),
+			},
+			nil},
+		{"ffa started short after fillPatience",
+			[]*queueEntry{
+				queued(1, "ffa", 1500, fillPatience),
+				queued(2, "ffa", 1510, 2*time.Second),
+				queued(3, "ffa", 1520, time.Second),
+			},
+			[][]int64{{1, 2, 3}}},
+		{"ffa too short even after fillPatience",
+			[]*queueEntry{
+				queued(1, "ffa", 1500, fillPatience),
+				queued(2, "ffa", 1510, time.Second),
+			},
+			nil},
+		{"full ffa started at once",
+			[]*queueEntry{
+				queued(1, "ffa", 1500, 9*time.Second),
+				queued(2, "ffa", 1510, 8*time.Second),
+				queued(3, "ffa", 1520, 7*time.Second),
+				queued(4, "ffa", 1530, 6*time.Second),
+				queued(5, "ffa", 1540, 5*time.Second),
+				queued(6, "ffa", 1550, 4*time.Second),
+				queued(7, "ffa", 1560, 3*time.Second),
+				queued(8, "ffa", 1570, 2*time.Second),
+				queued(9, "ffa", 1580, time.Second),
+			},
+			[][]int64{{1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"modes matched separately",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 4*time.Second),
+				queued(2, "ffa", 1500, 3*time.Second),
+				queued(3, "duel", 1500, 2*time.Second),
+				queued(4, "duel", 1500, time.Second),
+			},
+			[][]int64{{1, 3}}},
+		{"several duels",
+			[]*queueEntry{
+				queued(1, "duel", 1500, 4*time.Second),
+				queued(2, "duel", 2000, 3*time.Second),
+				queued(3, "duel", 1990, 2*time.Second),
+				queued(4, "duel", 1510, time.Second),
+			},
+			[][]int64{{1, 4}, {2, 3}}},
+	}
+
+	for _, tt := range tests {
+		var got [][]int64
+		for _, group := range findMatches(tt.entries, matchNow) {
+			got = append(got, userIDs(group))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got matches %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestFindMatchesRandom checks that matches found in a large random queue
+// are the right size, only have compatible players in them, and never have
+// a player in more than one.
+func TestFindMatchesRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	langs := []string{"go", "ruby", "python", ""}
+	regions := []string{"us-west", "eu", ""}
+	var entries []*queueEntry
+	for i := 0; i < 500; i++ {
+		mode := "duel"
+		if rnd.Intn(2) == 0 {
+			mode = "ffa"
+		}
+		e := queued(int64(i), mode, 1000+float64(rnd.Intn(1000)),
+			time.Duration(rnd.Intn(90))*time.Second)
+		e.Lang = langs[rnd.Intn(len(langs))]
+		e.Region = regions[rnd.Intn(len(regions))]
+		entries = append(entries, e)
+	}
+
+	matches := findMatches(entries, matchNow)
+	if len(matches) == 0 {
+		t.Fatal("no matches found")
+	}
+	seen := make(map[int64]bool)
+	for _, group := range matches {
+		m := modes[group[0].Mode]
+		if len(group) < m.minPlayers || len(group) > m.maxPlayers {
+			t.Errorf("%s match has %d players", group[0].Mode, len(group))
+		}
+		for i, e := range group {
+			if seen[e.UserID] {
+				t.Errorf("player %d is in more than one match", e.UserID)
+			}
+			seen[e.UserID] = true
+			for _, o := range group[:i] {
+				if !compatible(e, o, matchNow) {
+					t.Errorf("players %d and %d were matched but aren't "+
+						"compatible", o.UserID, e.UserID)
+				}
+			}
+		}
+	}
+}
+
+func userIDs(group []*queueEntry) []int64 {
+	ids := make([]int64, len(group))
+	for i, e := range group {
+		ids[i] = e.UserID
+	}
+	return ids
+}
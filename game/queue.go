@@ -0,0 +1,175 @@
+package game
+
+import (
+	"sync"
+
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+// priority orders jobs in the submission queue. Jobs with a lower priority
+// value always run first.
+type priority int
+
+const (
+	gradedPriority priority = iota
+	samplesPriority
+	rejudgePriority
+
+	numPriorities
+)
+
+// maxQueueLength is how many jobs can wait in the submission queue before new
+// ones are turned away.
+const maxQueueLength = 256
+
+// level is the jobs waiting at a single priority. Users take turns: each
+// user's jobs run in the order they were submitted, but a user only gets
+// another job run once everyone else waiting has had one.
+type level struct {
+	users []int64 // in the order they're next served
+	jobs  map[int64][]*runTask
+}
+
+// submissionQueue holds jobs waiting for a worker. It's safe for concurrent
+// use. Players are told their place in line whenever it changes, and only
+// then. Places in line aren't recorded in the round log.
+type submissionQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	levels [numPriorities]*level
+	length int
+	hub    *hub
+	closed bool
+
+	// positions is the position each waiting job was last told it had.
+	positions map[*runTask]int
+}
+
+func newSubmissionQueue(h *hub) *submissionQueue {
+	q := &submissionQueue{hub: h, positions: make(map[*runTask]int)}
+	q.cond = sync.NewCond(&q.mu)
+	for i := range q.levels {
+		q.levels[i] = &level{jobs: make(map[int64][]*runTask)}
+	}
+	return q
+}
+
+// push adds t to the queue, reporting false if the queue is full or closed.
+func (q *submissionQueue) push(t *runTask) bool {
+	q.mu.Lock()
+	if q.length >= maxQueueLength || q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	l := q.levels[t.priority]
+	userID := t.userID
+	if len(l.jobs[userID]) == 0 {
+		l.users = append(l.users, userID)
+	}
+	l.jobs[userID] = append(l.jobs[userID], t)
+	q.length++
+	updates := q.changedPositions()
+	q.mu.Unlock()
+
+	q.cond.Signal()
+	q.notify(updates)
+	return true
+}
+
+// pop waits for a job and removes it from the queue. It returns nil once the
+// queue is closed.
+func (q *submissionQueue) pop() *runTask {
+	q.mu.Lock()
+	for q.length == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	var t *runTask
+	for _, l := range q.levels {
+		if len(l.users) == 0 {
+			continue
+		}
+		userID := l.users[0]
+		l.users = l.users[1:]
+		t = l.jobs[userID][0]
+		if rest := l.jobs[userID][1:]; len(rest) > 0 {
+			l.jobs[userID] = rest
+			l.users = append(l.users, userID)
+		} else {
+			delete(l.jobs, userID)
+		}
+		break
+	}
+	q.length--
+	delete(q.positions, t)
+	updates := q.changedPositions()
+	q.mu.Unlock()
+
+	q.notify(append(updates, positionUpdate{t, 0}))
+	return t
+}
+
+// close wakes the workers waiting in pop so they can stop. Jobs still in the
+// queue are dropped, since nobody is left to tell the results to.
+func (q *submissionQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+type positionUpdate struct {
+	t        *runTask
+	position int
+}
+
+// changedPositions works out where each waiting job is in line, returning
+// those whose position has changed since their player was last told. It
+// must be called with q.mu held.
+func (q *submissionQueue) changedPositions() []positionUpdate {
+	var updates []positionUpdate
+	ahead := 0
+	for _, l := range q.levels {
+		// Jobs are served in rounds, with each user who has jobs left
+		// getting one per round.
+		for round := 0; ; round++ {
+			served := false
+			for _, userID := range l.users {
+				jobs := l.jobs[userID]
+				if round >= len(jobs) {
+					continue
+				}
+				served = true
+				ahead++
+				t := jobs[round]
+				if q.positions[t] != ahead {
+					q.positions[t] = ahead
+					updates = append(updates, positionUpdate{t, ahead})
+				}
+			}
+			if !served {
+				break
+			}
+		}
+	}
+	return updates
+}
+
+func (q *submissionQueue) notify(updates []positionUpdate) {
+	for _, u := range updates {
+		if u.t.c == nil {
+			continue
+		}
+		q.hub.sendUnrecorded(u.t.userID, &protocol.Event{
+			Type:      protocol.QueuePosition,
+			RequestID: u.t.requestID,
+			UserID:    -1,
+			Body: &protocol.QueuePositionEvent{
+				Position: u.position,
+			},
+		})
+	}
+}
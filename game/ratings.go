@@ -0,0 +1,88 @@
+package game
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/rating"
+)
+
+// ratingDelay is how long after a round ends it's rated, so submissions made
+// just before the end have time to be graded.
+const ratingDelay = 30 * time.Second
+
+// rateRoundLater rates a round once ratingDelay has passed.
+func rateRoundLater(roundID int64) {
+	time.AfterFunc(ratingDelay, func() {
+		if err := rateRound(roundID); err != nil {
+			log.Printf("couldn't rate round %d: %v", roundID, err)
+		}
+	})
+}
+
+// rateRound updates the ratings of everyone who submitted a solution in a
+// round, from the order they finished in. Each player's overall rating is
+// updated along with their rating in the language they used, both against
+// their opponents' overall ratings. Rounds are only ever rated once.
+func rateRound(roundID int64) error {
+	return inTx(func(ctx context.Context) error {
+		claimed, err := datastore.ClaimRound(ctx, roundID)
+		if err != nil || !claimed {
+			return err
+		}
+		attempts, err := datastore.GetRoundAttempts(ctx, roundID)
+		if err != nil || len(attempts) < 2 {
+			return err
+		}
+
+		overall := make([]*model.Rating, len(attempts))
+		inLang := make([]*model.Rating, len(attempts))
+		for i, a := range attempts {
+			if overall[i], err = getRating(ctx, a.UserID, ""); err != nil {
+				return err
+			}
+			if inLang[i], err = getRating(ctx, a.UserID, a.Lang); err != nil {
+				return err
+			}
+		}
+
+		// Every rating is updated from the ratings players had before the
+		// round, so they're worked out before any are saved.
+		var updated []*model.Rating
+		for i, a := range attempts {
+			var outcomes []rating.Outcome
+			for j, b := range attempts {
+				if i != j {
+					outcomes = append(outcomes, rating.Outcome{
+						Opponent: overall[j],
+						Score:    rating.Finish(a, b),
+					})
+				}
+			}
+			updated = append(updated, rating.Update(overall[i], outcomes),
+				rating.Update(inLang[i], outcomes))
+		}
+		for _, r := range updated {
+			if err := datastore.SaveRating(ctx, r, roundID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getRating returns a player's rating, or the rating new players start with
+// if they don't have one.
+func getRating(ctx context.Context, userID int64,
+	lang string) (*model.Rating, error) {
+	r, err := datastore.GetRating(ctx, userID, lang)
+	if err == sql.ErrNoRows {
+		return rating.New(userID, lang), nil
+	}
+	return r, err
+}
@@ -0,0 +1,263 @@
+package game
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const (
+	// rejudgePollInterval is how often nodes check for pending rejudges.
+	rejudgePollInterval = 5 * time.Second
+
+	// rejudgeConcurrency is how many of a rejudge's submissions can be in
+	// the submission queue at once, so a big rejudge never fills it.
+	rejudgeConcurrency = 4
+)
+
+// rejudger runs pending rejudges one at a time. Rejudges are claimed through
+// the database, so each is only run by one node.
+func (g *game) rejudger() {
+	for {
+		var r *model.Rejudge
+		err := inTx(func(ctx context.Context) error {
+			var err error
+			r, err = datastore.ClaimRejudge(ctx)
+			return err
+		})
+		if err == sql.ErrNoRows {
+			time.Sleep(rejudgePollInterval)
+			continue
+		} else if err != nil {
+			log.Println(err)
+			time.Sleep(rejudgePollInterval)
+			continue
+		}
+
+		r.Status = model.RejudgeFinished
+		if err := g.rejudge(r); err != nil {
+			log.Printf("rejudge %d failed: %v", r.ID, err)
+			r.Status = model.RejudgeFailed
+			r.Error = err.Error()
+		}
+		if err := inTx(func(ctx context.Context) error {
+			return datastore.FinishRejudge(ctx, r)
+		}); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// rejudge reruns the submissions r covers against the challenge as it is now,
+// and rescores every submission in the rounds they were made in. Each
+// player's submissions in a round are saved together, so a rejudge that
+// fails partway leaves every round it finished correctly scored. The
+// challenge's stats are worked out again once they're all saved.
+func (g *game) rejudge(r *model.Rejudge) error {
+	var (
+		chlng       *model.Challenge
+		submissions []*model.Submission
+	)
+	if err := inTx(func(ctx context.Context) error {
+		var err error
+		chlng, err = datastore.GetChallenge(ctx, r.ChallengeID)
+		if err != nil {
+			return err
+		}
+		submissions, err = datastore.GetChallengeSubmissions(ctx, chlng.ID)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	for len(submissions) > 0 {
+		// Submissions come grouped by round and user.
+		n := 1
+		for n < len(submissions) &&
+			submissions[n].RoundID == submissions[0].RoundID &&
+			submissions[n].UserID == submissions[0].UserID {
+			n++
+		}
+		if err := g.rejudgeGroup(r, chlng, submissions[:n]); err != nil {
+			return err
+		}
+		submissions = submissions[n:]
+	}
+	return inTx(func(ctx context.Context) error {
+		return datastore.RebuildChallengeStats(ctx, chlng.ID)
+	})
+}
+
+// rejudgeGroup rejudges one player's submissions in one round, oldest first.
+func (g *game) rejudgeGroup(r *model.Rejudge, chlng *model.Challenge,
+	group []*model.Submission) error {
+	var rerun []*model.Submission
+	for _, s := range group {
+		if !r.OnlyStale || s.ChallengeVersion < chlng.Version {
+			rerun = append(rerun, s)
+		}
+	}
+	if len(rerun) == 0 {
+		return nil
+	}
+	results, err := g.rerun(chlng, rerun)
+	if err != nil {
+		return err
+	}
+
+	var changed []*model.RejudgeResult
+	if err := inTx(func(ctx context.Context) error {
+		changed = nil
+		failed := 0
+		solved := false
+		for _, s := range group {
+			res := &model.RejudgeResult{
+				RejudgeID:      r.ID,
+				SubmissionID:   s.ID,
+				UserID:         s.UserID,
+				OldVersion:     s.ChallengeVersion,
+				NewVersion:     s.ChallengeVersion,
+				OldPassed:      s.Passed,
+				OldTestsPassed: s.TestsPassed,
+				OldTestsTotal:  s.TestsTotal,
+				OldPoints:      s.Points,
+			}
+			newResults, ok := results[s.ID]
+			if ok {
+				res.NewVersion = chlng.Version
+				res.NewTestsTotal = len(chlng.HiddenTestCases())
+				for _, tr := range newResults {
+					if tr.Passed {
+						res.NewTestsPassed++
+					}
+				}
+				res.NewPassed = res.NewTestsTotal > 0 &&
+					res.NewTestsPassed == res.NewTestsTotal
+				s.FailedTestID = failedTestID(newResults)
+			} else {
+				res.NewPassed = s.Passed
+				res.NewTestsPassed = s.TestsPassed
+				res.NewTestsTotal = s.TestsTotal
+			}
+
+			// Only the first passing submission in a round scores, less
+			// the failures before it.
+			switch {
+			case res.NewPassed && !solved:
+				res.NewPoints = solveScore(failed)
+				solved = true
+			case !res.NewPassed:
+				failed++
+			}
+
+			if !ok && !res.Changed() {
+				continue
+			}
+			if err := datastore.SaveRejudgeResult(ctx, res); err != nil {
+				return err
+			}
+			s.Passed = res.NewPassed
+			s.TestsPassed = res.NewTestsPassed
+			s.TestsTotal = res.NewTestsTotal
+			s.Points = res.NewPoints
+			s.ChallengeVersion = res.NewVersion
+			if err := datastore.UpdateSubmissionResult(ctx, s); err != nil {
+				return err
+			}
+			if d := res.NewPoints - res.OldPoints; d != 0 {
+				if err := datastore.AddUserScore(ctx, s.UserID, d); err != nil {
+					return err
+				}
+			}
+			if res.Changed() {
+				changed = append(changed, res)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	r.Submissions += len(rerun)
+	r.Changed += len(changed)
+	for _, res := range changed {
+		g.sendDirect(res.UserID, &protocol.Event{
+			Type:   protocol.Rejudged,
+			UserID: -1,
+			Body: &protocol.RejudgedEvent{
+				SubmissionID: res.SubmissionID,
+				ChallengeID:  chlng.ID,
+				RoundID:      group[0].RoundID,
+				Passed:       res.NewPassed,
+				TestsPassed:  res.NewTestsPassed,
+				TestsTotal:   res.NewTestsTotal,
+				Points:       res.NewPoints,
+				OldPassed:    res.OldPassed,
+				OldPoints:    res.OldPoints,
+			},
+		})
+	}
+	return nil
+}
+
+// rerun runs submissions against chlng's hidden test cases at the lowest
+// priority, returning their results by submission ID.
+func (g *game) rerun(chlng *model.Challenge,
+	submissions []*model.Submission) (map[int64][]*protocol.TestResult,
+	error) {
+	type rerunResult struct {
+		id  int64
+		res *taskResult
+	}
+	done := make(chan rerunResult)
+	results := make(map[int64][]*protocol.TestResult)
+	var firstErr error
+	collect := func() {
+		r := <-done
+		if r.res.err != nil && firstErr == nil {
+			firstErr = r.res.err
+		}
+		results[r.id] = r.res.results
+	}
+
+	inFlight := 0
+	for _, s := range submissions {
+		if inFlight == rejudgeConcurrency {
+			collect()
+			inFlight--
+		}
+		ch := make(chan *taskResult, 1)
+		t := &runTask{
+			userID:   s.UserID,
+			lang:     s.Lang,
+			code:     []byte(s.Code),
+			chlng:    chlng,
+			roundID:  s.RoundID,
+			priority: rejudgePriority,
+			graded:   true,
+			tests:    chlng.HiddenTestCases(),
+			done:     ch,
+		}
+		for !g.codeRunner.queue.push(t) {
+			time.Sleep(time.Second)
+		}
+		inFlight++
+		go func(id int64) {
+			done <- rerunResult{id, <-ch}
+		}(s.ID)
+	}
+	for ; inFlight > 0; inFlight-- {
+		collect()
+	}
+	if firstErr != nil {
+		return nil, fmt.Errorf("couldn't rerun submissions: %v", firstErr)
+	}
+	return results, nil
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+)
+
+// maxRecordBatch is the most entries the recorder saves with one insert.
+const maxRecordBatch = 256
+
+// recorder writes the events sent to players into the current round's log so
+// the round can be replayed after it's over. Entries that pile up while it's
+// saving are saved together next.
+type recorder struct {
+	entries chan *model.RoundEvent
+}
+
+func newRecorder() *recorder {
+	return &recorder{entries: make(chan *model.RoundEvent, 1024)}
+}
+
+// run saves entries until done is closed, and then saves whatever is left
+// before returning.
+func (r *recorder) run(done <-chan struct{}) {
+	for {
+		select {
+		case e := <-r.entries:
+			r.save(r.batch(e))
+		case <-done:
+			for {
+				batch := r.batch(nil)
+				if len(batch) == 0 {
+					return
+				}
+				r.save(batch)
+			}
+		}
+	}
+}
+
+// batch returns first, if it's set, along with the entries waiting to be
+// saved, up to maxRecordBatch of them.
+func (r *recorder) batch(first *model.RoundEvent) []*model.RoundEvent {
+	var batch []*model.RoundEvent
+	if first != nil {
+		batch = append(batch, first)
+	}
+	for len(batch) < maxRecordBatch {
+		select {
+		case e := <-r.entries:
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (r *recorder) save(batch []*model.RoundEvent) {
+	if err := inTx(func(ctx context.Context) error {
+		return datastore.SaveRoundEvents(ctx, batch)
+	}); err != nil {
+		log.Println(err)
+	}
+}
+
+// record queues evt to be appended to the current round's log. A recipientID
+// of 0 marks the event as a broadcast. Events sent before the first round has
+// started aren't recorded. If the recorder has fallen too far behind, the
+// event is left out rather than holding up the game.
+func (g *game) record(evt interface{}, recipientID int64) {
+	roundID, err := g.rounds.get(g)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if roundID == 0 {
+		return
+	}
+	body, err := json.Marshal(evt)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	select {
+	case g.recorder.entries <- &model.RoundEvent{
+		Created:     time.Now(),
+		RoundID:     roundID,
+		RecipientID: recipientID,
+		Event:       body,
+	}:
+	default:
+		log.Printf("left an event out of round %d's log, since the "+
+			"recorder is behind", roundID)
+	}
+}
+
+// roundCacheTTL is the longest a node goes without checking which round is
+// current before recording an event.
+const roundCacheTTL = 5 * time.Second
+
+// roundCache remembers the room's current round so recording an event doesn't
+// cost a trip to Redis. The round only changes when one starts, which every
+// node hears about through the ChallengeSet broadcast that follows it, so the
+// cache is cleared then.
+type roundCache struct {
+	mu      sync.Mutex
+	id      int64
+	fetched time.Time
+}
+
+func (rc *roundCache) get(g *game) (int64, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if time.Since(rc.fetched) < roundCacheTTL {
+		return rc.id, nil
+	}
+	id, err := g.currentRoundID()
+	if err != nil {
+		return 0, err
+	}
+	rc.id, rc.fetched = id, time.Now()
+	return id, nil
+}
+
+func (rc *roundCache) clear() {
+	rc.mu.Lock()
+	rc.fetched = time.Time{}
+	rc.mu.Unlock()
+}
+
+func (g *game) currentRoundID() (int64, error) {
+	c := g.pool.Get()
+	defer c.Close()
+	id, err := redis.Int64(c.Do("GET", g.key(currentRoundIDKey)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return id, nil
+}
@@ -0,0 +1,167 @@
+package game
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/config"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+	"github.com/zachlatta/calhacks/runner"
+	"github.com/zachlatta/calhacks/sandbox"
+)
+
+type runTask struct {
+	userID    int64
+	c         *conn
+	requestID string
+	lang      string
+	code      []byte
+	chlng     *model.Challenge
+	roundID   int64
+	priority  priority
+
+	// graded is whether the task is a submission. Submissions are run
+	// against hidden test cases and scored; everything else is run against
+	// samples or a custom input.
+	graded bool
+
+	// input is the custom input to run the code with instead of the
+	// samples, if any.
+	input *string
+
+	// tests are the test cases to run the code against, in order.
+	tests []model.TestCase
+
+	// done is sent the task's results instead of them going to a player,
+	// for tasks no player is waiting on, like rejudges. Tasks with done set
+	// have no conn.
+	done chan<- *taskResult
+}
+
+type taskResult struct {
+	results []*protocol.TestResult
+	err     error
+}
+
+// codeRunner takes tasks off the submission queue and has them executed,
+// WorkerCount at a time. Tasks are executed in this process unless the
+// RUNNER setting is "remote", in which case they're sent to calhacks-worker
+// processes.
+type codeRunner struct {
+	WorkerCount int
+
+	executor runner.Executor
+	hub      *hub
+	queue    *submissionQueue
+}
+
+// newExecutor makes what code is run with on this node. It's made once per
+// process and shared by every room.
+func newExecutor(pool *redis.Pool) runner.Executor {
+	if config.Get("RUNNER") == "remote" {
+		return runner.NewRemote(pool, nodeID)
+	}
+	sb, err := sandbox.New(sandbox.DefaultEndpoint)
+	if err != nil {
+		panic(err)
+	}
+	return &runner.Local{Sandbox: sb}
+}
+
+var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+func randSeq(n int) string {
+	rand.Seed(time.Now().UnixNano())
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letters[rand.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+func (b *codeRunner) worker() {
+	for {
+		t := b.queue.pop()
+		if t == nil {
+			return
+		}
+		results, err := b.runTests(t)
+		if t.done != nil {
+			t.done <- &taskResult{results, err}
+			continue
+		}
+		b.hub.limits.releaseRun(t.userID)
+		if err != nil {
+			log.Println(err)
+			b.hub.sendError(t.userID, t.requestID, protocol.ErrInternal,
+				"your code couldn't be run")
+			continue
+		}
+
+		if t.graded {
+			if err := b.hub.game.grade(t, results); err != nil {
+				log.Println(err)
+				b.hub.sendError(t.userID, t.requestID, protocol.ErrInternal,
+					"your submission couldn't be graded")
+			}
+			continue
+		}
+
+		b.hub.sendTo(t.userID, &protocol.Event{
+			Type:      protocol.SamplesRan,
+			RequestID: t.requestID,
+			UserID:    t.userID,
+			Body: &protocol.SamplesRanEvent{
+				Results: results,
+			},
+		})
+	}
+}
+
+// runTests runs t's code against each of its test cases. Graded tasks stop at
+// the first test case that fails.
+func (b *codeRunner) runTests(t *runTask) ([]*protocol.TestResult, error) {
+	res, err := b.executor.Execute(&runner.Job{
+		Lang:          t.lang,
+		Code:          t.code,
+		Tests:         t.tests,
+		Judge:         t.input == nil,
+		Checker:       t.chlng.Checker,
+		StopOnFailure: t.graded,
+		TimeLimit:     t.chlng.TimeLimitDuration(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*protocol.TestResult, len(res.Results))
+	for i, r := range res.Results {
+		tc := t.tests[i]
+		results[i] = &protocol.TestResult{
+			TestCaseID: tc.ID,
+			Input:      tc.Input,
+			Output:     r.Output,
+			Passed:     r.Passed,
+			TimedOut:   r.TimedOut,
+		}
+		if !t.graded && tc.Sample {
+			results[i].ExpectedOutput = tc.ExpectedOutput
+		}
+	}
+	return results, nil
+}
+
+func (b *codeRunner) Run() {
+	var wg sync.WaitGroup
+	wg.Add(b.WorkerCount)
+	for i := 0; i < b.WorkerCount; i++ {
+		go func() {
+			b.worker()
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const (
+	// reconnectGrace is how long a player stays in the game after their
+	// connection drops, giving them a chance to reconnect and resume.
+	reconnectGrace = 30 * time.Second
+
+	// sessionBacklog is how many of the most recent messages are kept for
+	// replaying to a client that resumes its session.
+	sessionBacklog = 128
+)
+
+// message is an event as it's written to the wire. Messages sent within a
+// session are stamped with consecutive sequence numbers so that a client that
+// reconnects can tell the server what it last saw. Control messages that
+// aren't part of the session's history have no sequence number.
+type message struct {
+	Seq       int64              `json:"seq,omitempty"`
+	Type      protocol.EventType `json:"type"`
+	RequestID string             `json:"request_id,omitempty"`
+	UserID    int64              `json:"user_id"`
+	Body      json.RawMessage    `json:"body,omitempty"`
+}
+
+// legacyMessage is a message as it's sent to clients using version 1 of the
+// protocol, which numbered event types.
+type legacyMessage struct {
+	Seq       int64           `json:"seq,omitempty"`
+	Type      int             `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	UserID    int64           `json:"user_id"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+func newMessage(evt interface{}) (*message, error) {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// session is a player's presence in a room. It outlives any one connection
+// so that a player whose connection drops can pick up where they left off.
+// Sessions are owned by the hub's run goroutine.
+type session struct {
+	token   string
+	user    *model.User
+	conn    *conn // nil while disconnected
+	seq     int64
+	backlog []*message
+
+	// disconnected is when conn was last lost.
+	disconnected time.Time
+}
+
+func newSession(u *model.User) (*session, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &session{token: hex.EncodeToString(b), user: u}, nil
+}
+
+// stamp assigns m the next sequence number in s and adds it to the backlog.
+func (s *session) stamp(m *message) *message {
+	stamped := *m
+	s.seq++
+	stamped.Seq = s.seq
+	s.backlog = append(s.backlog, &stamped)
+	if len(s.backlog) > sessionBacklog {
+		s.backlog = s.backlog[len(s.backlog)-sessionBacklog:]
+	}
+	return &stamped
+}
+
+// missed returns the messages sent after lastSeq. ok is false if some of them
+// are no longer in the backlog, in which case the client needs to start over
+// from a fresh copy of the game's state.
+func (s *session) missed(lastSeq int64) (msgs []*message, ok bool) {
+	if lastSeq > s.seq || lastSeq < 0 {
+		return nil, false
+	}
+	if lastSeq == s.seq {
+		return nil, true
+	}
+	if len(s.backlog) == 0 || s.backlog[0].Seq > lastSeq+1 {
+		return nil, false
+	}
+	return s.backlog[lastSeq+1-s.backlog[0].Seq:], true
+}
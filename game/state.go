@@ -0,0 +1,262 @@
+package game
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+)
+
+// Types of state transitions written to the game event log.
+const (
+	roundStartedState = "round_started"
+	breakStartedState = "break_started"
+	userJoinedState   = "user_joined"
+	userLeftState     = "user_left"
+	solvedState       = "solved"
+)
+
+// state is the projection of a room's game event log. Redis holds a cached
+// copy of it that is kept up to date as events are emitted, and that is
+// rewritten from the log whenever a node takes over the room's timer.
+type state struct {
+	roundID     int64
+	challengeID int64
+	isBreak     bool
+	started     time.Time
+	seconds     int
+	users       map[int64]string // user ID to the node they're connected to
+	solvers     map[int64]bool
+}
+
+func newState() *state {
+	return &state{
+		users:   make(map[int64]string),
+		solvers: make(map[int64]bool),
+	}
+}
+
+func (s *state) apply(e *model.GameEvent) {
+	switch e.Type {
+	case roundStartedState:
+		s.roundID = e.RoundID
+		s.challengeID = e.ChallengeID
+		s.isBreak = false
+		s.started = e.Created
+		s.seconds = e.Seconds
+		s.solvers = make(map[int64]bool)
+	case breakStartedState:
+		s.isBreak = true
+		s.started = e.Created
+		s.seconds = e.Seconds
+	case userJoinedState:
+		s.users[e.UserID] = e.Node
+	case userLeftState:
+		delete(s.users, e.UserID)
+	case solvedState:
+		if e.RoundID == s.roundID {
+			s.solvers[e.UserID] = true
+		}
+	}
+}
+
+// snapshot returns s as a snapshot as of the event with the ID eventID.
+func (s *state) snapshot(room string, eventID int64) *model.GameSnapshot {
+	snap := &model.GameSnapshot{
+		Room:        room,
+		EventID:     eventID,
+		RoundID:     s.roundID,
+		ChallengeID: s.challengeID,
+		IsBreak:     s.isBreak,
+		Started:     s.started,
+		Seconds:     s.seconds,
+		Users:       make([]model.SnapshotUser, 0, len(s.users)),
+		Solvers:     make([]int64, 0, len(s.solvers)),
+	}
+	for id, node := range s.users {
+		snap.Users = append(snap.Users, model.SnapshotUser{ID: id, Node: node})
+	}
+	for id := range s.solvers {
+		snap.Solvers = append(snap.Solvers, id)
+	}
+	return snap
+}
+
+func stateFromSnapshot(snap *model.GameSnapshot) *state {
+	s := newState()
+	s.roundID = snap.RoundID
+	s.challengeID = snap.ChallengeID
+	s.isBreak = snap.IsBreak
+	s.started = snap.Started
+	s.seconds = snap.Seconds
+	for _, u := range snap.Users {
+		s.users[u.ID] = u.Node
+	}
+	for _, id := range snap.Solvers {
+		s.solvers[id] = true
+	}
+	return s
+}
+
+// remaining returns how many seconds are left in the current round or break
+// as of now.
+func (s *state) remaining(now time.Time) int {
+	r := s.seconds - int(now.Sub(s.started)/time.Second)
+	if r < 0 {
+		return 0
+	}
+	return r
+}
+
+// inTx runs fn in a new transaction, committing it if fn succeeds and rolling
+// it back otherwise.
+func inTx(fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, err := datastore.NewContextWithTx(ctx)
+	if err != nil {
+		return err
+	}
+	tx, _ := datastore.TxFromContext(ctx)
+	if err := fn(ctx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// emit durably appends e to the game event log and then applies it to the
+// state cached in Redis.
+func (g *game) emit(e *model.GameEvent) error {
+	e.Room = g.room
+	if err := inTx(func(ctx context.Context) error {
+		return datastore.SaveGameEvent(ctx, e)
+	}); err != nil {
+		return err
+	}
+	return g.project(e)
+}
+
+// project applies a single event to the state cached in Redis. All of an
+// event's writes are made in one transaction so readers never see it half
+// applied.
+func (g *game) project(e *model.GameEvent) error {
+	c := g.pool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	switch e.Type {
+	case roundStartedState:
+		c.Send("SET", g.key(currentRoundIDKey), e.RoundID)
+		c.Send("SET", g.key(currentChallengeIDKey), e.ChallengeID)
+		c.Send("SET", g.key(breakKey), false)
+		c.Send("SET", g.key(timeTotalKey), e.Seconds)
+		c.Send("SET", g.key(timeRemainingKey), e.Seconds)
+		c.Send("DEL", g.key(roundSolversKey))
+	case breakStartedState:
+		c.Send("SET", g.key(breakKey), true)
+		c.Send("SET", g.key(timeTotalKey), e.Seconds)
+		c.Send("SET", g.key(timeRemainingKey), e.Seconds)
+	case userJoinedState:
+		c.Send("SADD", g.key(currentUserIDsKey), e.UserID)
+		c.Send("HSET", g.key(currentUserNodesKey), e.UserID, e.Node)
+	case userLeftState:
+		c.Send("SREM", g.key(currentUserIDsKey), e.UserID)
+		c.Send("HDEL", g.key(currentUserNodesKey), e.UserID)
+	case solvedState:
+		c.Send("SADD", g.key(roundSolversKey), e.UserID)
+	}
+	_, err := c.Do("EXEC")
+	if e.Type == roundStartedState {
+		g.rounds.clear()
+	}
+	return err
+}
+
+// snapshotLag is how old game events have to be before they go into a
+// snapshot. Events are numbered as they're written, but can be committed out
+// of order, so recent ones are left for the next snapshot in case one
+// numbered before them hasn't been committed yet.
+const snapshotLag = time.Minute
+
+// replay works out the room's state from its latest snapshot and the game
+// events after it, saving a newer snapshot if enough has happened since.
+func (g *game) replay() (*state, error) {
+	var (
+		s      *state
+		events []*model.GameEvent
+		after  int64
+	)
+	if err := inTx(func(ctx context.Context) error {
+		snap, err := datastore.GetGameSnapshot(ctx, g.room)
+		switch {
+		case err == sql.ErrNoRows:
+			s = newState()
+		case err != nil:
+			return err
+		default:
+			s = stateFromSnapshot(snap)
+			after = snap.EventID
+		}
+		events, err = datastore.GetGameEvents(ctx, g.room, after)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-snapshotLag)
+	i := 0
+	for ; i < len(events) && events[i].Created.Before(cutoff); i++ {
+		s.apply(events[i])
+	}
+	if i > 0 {
+		snap := s.snapshot(g.room, events[i-1].ID)
+		if err := inTx(func(ctx context.Context) error {
+			return datastore.SaveGameSnapshot(ctx, snap)
+		}); err != nil {
+			log.Println(err)
+		}
+	}
+	for ; i < len(events); i++ {
+		s.apply(events[i])
+	}
+	return s, nil
+}
+
+// rebuild works out the room's state from its game event log and overwrites
+// the state cached in Redis with the result. Time remaining is worked out
+// from when the current round or break started, so time spent while no
+// server was running counts against it.
+func (g *game) rebuild() (*state, error) {
+	s, err := g.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	c := g.pool.Get()
+	defer c.Close()
+
+	c.Send("MULTI")
+	c.Send("SET", g.key(currentRoundIDKey), s.roundID)
+	c.Send("SET", g.key(currentChallengeIDKey), s.challengeID)
+	c.Send("SET", g.key(breakKey), s.isBreak)
+	c.Send("SET", g.key(timeTotalKey), s.seconds)
+	c.Send("SET", g.key(timeRemainingKey), s.remaining(time.Now()))
+	c.Send("DEL", g.key(currentUserIDsKey), g.key(currentUserNodesKey),
+		g.key(roundSolversKey))
+	for id, node := range s.users {
+		c.Send("SADD", g.key(currentUserIDsKey), id)
+		c.Send("HSET", g.key(currentUserNodesKey), id, node)
+	}
+	for id := range s.solvers {
+		c.Send("SADD", g.key(roundSolversKey), id)
+	}
+	if _, err := c.Do("EXEC"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,124 @@
+package game
+
+import (
+	"log"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/protocol"
+)
+
+const (
+	// solvePoints is what solving a round's challenge is worth, less
+	// wrongPenalty for each failed submission before it, down to no less
+	// than minSolvePoints.
+	solvePoints    = 100
+	wrongPenalty   = 10
+	minSolvePoints = 10
+)
+
+func solveScore(failed int) int {
+	points := solvePoints - failed*wrongPenalty
+	if points < minSolvePoints {
+		return minSolvePoints
+	}
+	return points
+}
+
+// grade records a submission's results and scores it, then tells the player
+// how they did. Only the first passing submission in a round scores.
+func (g *game) grade(t *runTask, results []*protocol.TestResult) error {
+	s := &model.Submission{
+		UserID:      t.userID,
+		RoundID:     t.roundID,
+		ChallengeID: t.chlng.ID,
+		Room:        g.room,
+		Lang:        t.lang,
+		Code:        string(t.code),
+		TestsTotal:  len(t.tests),
+
+		ChallengeVersion: t.chlng.Version,
+	}
+	for _, r := range results {
+		if r.Passed {
+			s.TestsPassed++
+		}
+	}
+	s.Passed = s.TestsTotal > 0 && s.TestsPassed == s.TestsTotal
+	s.FailedTestID = failedTestID(results)
+
+	var firstAttempt bool
+	if err := inTx(func(ctx context.Context) error {
+		passed, failed, err := datastore.GetSubmissionCounts(ctx, s.RoundID,
+			s.UserID)
+		if err != nil {
+			return err
+		}
+		firstAttempt = passed+failed == 0
+		if s.Passed && passed == 0 {
+			s.Points = solveScore(failed)
+			if err := datastore.AddUserScore(ctx, s.UserID,
+				s.Points); err != nil {
+				return err
+			}
+		}
+		return datastore.SaveSubmission(ctx, s)
+	}); err != nil {
+		return err
+	}
+	addStats(s, firstAttempt)
+
+	if s.Points > 0 {
+		if err := g.addSolver(s.UserID); err != nil {
+			return err
+		}
+	}
+
+	var last protocol.TestResult
+	if len(results) > 0 {
+		last = *results[len(results)-1]
+	}
+	g.Hub.sendTo(s.UserID, &protocol.Event{
+		Type:      protocol.CodeRan,
+		RequestID: t.requestID,
+		UserID:    s.UserID,
+		Body: &protocol.CodeRanEvent{
+			Output:      last.Output,
+			Passed:      s.Passed,
+			TimedOut:    last.TimedOut,
+			TestsPassed: s.TestsPassed,
+			TestsTotal:  s.TestsTotal,
+			Points:      s.Points,
+		},
+	})
+	return nil
+}
+
+// failedTestID returns the ID of the test case a graded task failed on, or
+// zero if it passed them all. Graded tasks stop at the first failure, so it's
+// always the last result.
+func failedTestID(results []*protocol.TestResult) int64 {
+	if len(results) == 0 || results[len(results)-1].Passed {
+		return 0
+	}
+	return results[len(results)-1].TestCaseID
+}
+
+// addStats adds a graded submission to its challenge's stats. Stats aren't
+// worth failing a submission over, so errors are only logged. It's tried
+// twice, since adding a challenge's first submission can conflict with
+// another node doing the same.
+func addStats(s *model.Submission, firstAttempt bool) {
+	var err error
+	for i := 0; i < 2; i++ {
+		err = inTx(func(ctx context.Context) error {
+			return datastore.AddSubmissionStats(ctx, s, firstAttempt)
+		})
+		if err == nil {
+			return
+		}
+	}
+	log.Printf("couldn't add submission %d to stats: %v", s.ID, err)
+}
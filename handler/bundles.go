@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/zachlatta/calhacks/bundle"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// maxPackageSize is the largest challenge package that can be imported, in
+// bytes.
+const maxPackageSize = 32 << 20
+
+// importChallenge creates a challenge from a package zipped in the request's
+// body, in any format the bundle package reads. Like challenges submitted as
+// JSON, it starts as a draft.
+func importChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) (func() error, bufHandler, error) {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return nil, nil, unauthorized()
+	}
+
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPackageSize))
+	if err != nil {
+		return nil, nil, badRequest(err)
+	}
+	files, err := bundle.ReadZip(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, badRequest(err)
+	}
+	c, err := bundle.Decode(files)
+	if err != nil {
+		return nil, nil, validationError(err.Error())
+	}
+
+	validate := func() error { return validateChallenge(c) }
+	return validate, func(ctx context.Context, w http.ResponseWriter,
+		r *http.Request) error {
+		c.AuthorID = user.ID
+		c.Status = model.StatusDraft
+		if err := datastore.SaveChallenge(ctx, c); err != nil {
+			return err
+		}
+		return renderJSON(w, c, http.StatusCreated)
+	}, nil
+}
+
+// exportChallenge returns a challenge as a zipped package. Packages include
+// every test case and solution, so only users who can edit the challenge, and
+// reviewers while it's in review, can export it.
+func exportChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	if !c.ReviewableBy(user) {
+		return forbidden()
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="challenge-%d.zip"`, c.ID))
+	return bundle.Encode(c).WriteZip(w)
+}
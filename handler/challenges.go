@@ -1,46 +1,517 @@
 package handler
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/mux"
+	"github.com/zachlatta/calhacks"
 	"github.com/zachlatta/calhacks/datastore"
 	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/runner"
 
 	"code.google.com/p/go.net/context"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	defaultTimeLimit = 2000
+	maxTimeLimit     = 10000
+)
+
+const maxTags = 10
+
+var (
+	tagRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+	difficulties = map[string]bool{
+		model.DifficultyEasy:   true,
+		model.DifficultyMedium: true,
+		model.DifficultyHard:   true,
+	}
+)
+
+// normalizeTags lowercases tags and removes duplicates, and checks that
+// they're short and only have letters, numbers and dashes.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRe.MatchString(tag) {
+			return nil, validationError(fmt.Sprintf("%q isn't a valid tag, "+
+				"tags are up to 32 letters, numbers and dashes", tag))
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTags {
+		return nil, validationError(fmt.Sprintf(
+			"a challenge can have at most %d tags", maxTags))
+	}
+	return normalized, nil
+}
+
+// validateChallenge checks c's fields, filling in defaults, and then checks
+// its test cases by running its solutions against them. That can take a
+// while, so it's run outside of any transaction as slowHandler work.
+func validateChallenge(c *model.Challenge) error {
+	if c.TimeLimit == 0 {
+		c.TimeLimit = defaultTimeLimit
+	}
+	switch {
+	case len(c.Title) < 5:
+		return validationError("title must be at least 5 characters long")
+	case c.Seconds < 1:
+		return validationError("seconds must be at least 1")
+	case c.TimeLimit < 1 || c.TimeLimit > maxTimeLimit:
+		return validationError(fmt.Sprintf(
+			"time_limit must be between 1 and %d milliseconds", maxTimeLimit))
+	case len(c.HiddenTestCases()) == 0:
+		return validationError("there must be at least one hidden test case")
+	case c.Difficulty != "" && !difficulties[c.Difficulty]:
+		return validationError("difficulty must be easy, medium or hard")
+	}
+	var err error
+	if c.Tags, err = normalizeTags(c.Tags); err != nil {
+		return err
+	}
+
+	ex := calhacks.Lobby.Executor()
+	var correct bool
+	for _, s := range c.Solutions {
+		supported, err := ex.Supports(s.Lang)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return validationError(fmt.Sprintf(
+				"%q isn't a supported language", s.Lang))
+		}
+		correct = correct || s.Correct
+	}
+	if !correct {
+		return validationError("there must be at least one correct solution")
+	}
+	if c.Checker != nil {
+		supported, err := ex.Supports(c.Checker.Lang)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			return validationError(fmt.Sprintf(
+				"the checker's language %q isn't supported", c.Checker.Lang))
+		}
+	}
+	if err := runner.Validate(ex, c); err != nil {
+		if _, ok := err.(*runner.ValidationError); ok {
+			return validationError(err.Error())
+		}
+		return err
+	}
+	return nil
+}
+
 func submitChallenge(ctx context.Context, w http.ResponseWriter,
-	r *http.Request) error {
+	r *http.Request) (func() error, bufHandler, error) {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return nil, nil, unauthorized()
+	}
+
 	var c model.Challenge
 	defer r.Body.Close()
 	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-		return badRequest(err)
+		return nil, nil, badRequest(err)
 	}
 
-	switch {
-	case len(c.Title) <= 5:
-		return validationError("title must be at least 5 characters long")
-	case c.Seconds <= 0:
-		return validationError("seconds must be at least 0")
-	case c.ID != 0:
-		return validationError("you cannot set the id")
+	if c.ID != 0 {
+		return nil, nil, validationError("you cannot set the id")
 	}
-
 	for _, tc := range c.TestCases {
 		switch {
 		case tc.ID != 0:
-			return validationError("you cannot set the id")
+			return nil, nil, validationError("you cannot set the id")
 		}
 	}
 
-	if c.TestCases == nil {
-		c.TestCases = make([]model.TestCase, 0)
+	validate := func() error { return validateChallenge(&c) }
+	return validate, func(ctx context.Context, w http.ResponseWriter,
+		r *http.Request) error {
+		c.AuthorID = user.ID
+		c.Status = model.StatusDraft
+		if err := datastore.SaveChallenge(ctx, &c); err != nil {
+			return err
+		}
+		return renderJSON(w, c, http.StatusCreated)
+	}, nil
+}
+
+// challengeFromRequest returns the challenge whose ID is in the request's
+// URL. Challenges that aren't visible to the user aren't found.
+func challengeFromRequest(ctx context.Context,
+	r *http.Request) (*model.Challenge, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		return nil, badRequest(err)
 	}
+	c, err := datastore.GetChallenge(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, notFound("challenge not found")
+	} else if err != nil {
+		return nil, err
+	}
+	user, _ := datastore.UserFromContext(ctx)
+	if !c.VisibleTo(user) {
+		return nil, notFound("challenge not found")
+	}
+	return c, nil
+}
 
-	if err := datastore.SaveChallenge(ctx, &c); err != nil {
+// getChallenge returns a challenge. Its hidden test cases are only included
+// for users who can edit it, and for reviewers while it's in review.
+func getChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
 		return err
 	}
+	user, _ := datastore.UserFromContext(ctx)
+	if !c.ReviewableBy(user) {
+		c = c.Public()
+	}
+	return renderJSON(w, c, http.StatusOK)
+}
 
-	return renderJSON(w, c, http.StatusCreated)
+func currentChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	id, err := calhacks.Game.CurrentChallengeID()
+	if err == redis.ErrNil {
+		return notFound("there's no current challenge")
+	} else if err != nil {
+		return err
+	}
+	c, err := datastore.GetChallenge(ctx, id)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, c.Public(), http.StatusOK)
+}
+
+// listChallenges lists challenges newest first, without their test cases.
+// It's paginated with the limit and offset parameters, and can be filtered
+// by author_id, title, difficulty, status and any number of tag parameters.
+// The q parameter searches titles and descriptions, ordering the challenges
+// by how well they match. Only published challenges are listed unless
+// another status is asked for, which only reviewers can do for challenges
+// other than their own. Admins can include deleted challenges with
+// deleted=true.
+func listChallenges(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, _ := datastore.UserFromContext(ctx)
+	f := datastore.ChallengeFilter{
+		Title:      r.FormValue("title"),
+		Query:      strings.TrimSpace(r.FormValue("q")),
+		Difficulty: r.FormValue("difficulty"),
+		Status:     model.StatusPublished,
+		Limit:      defaultPageSize,
+	}
+	if f.Difficulty != "" && !difficulties[f.Difficulty] {
+		return badRequest(errors.New("difficulty must be easy, medium or hard"))
+	}
+	for _, tag := range r.Form["tag"] {
+		f.Tags = append(f.Tags, strings.ToLower(strings.TrimSpace(tag)))
+	}
+	var err error
+	if s := r.FormValue("limit"); s != "" {
+		f.Limit, err = strconv.Atoi(s)
+		if err != nil || f.Limit < 1 || f.Limit > maxPageSize {
+			return badRequest(errors.New("limit must be between 1 and 100"))
+		}
+	}
+	if s := r.FormValue("offset"); s != "" {
+		f.Offset, err = strconv.Atoi(s)
+		if err != nil || f.Offset < 0 {
+			return badRequest(errors.New("offset must be a positive number"))
+		}
+	}
+	if s := r.FormValue("author_id"); s != "" {
+		f.AuthorID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return badRequest(err)
+		}
+	}
+	if s := r.FormValue("status"); s != "" {
+		if !statuses[s] {
+			return badRequest(fmt.Errorf("%q isn't a challenge status", s))
+		}
+		f.Status = s
+	}
+	if f.Status != model.StatusPublished {
+		if user == nil || (!user.IsReviewer() && f.AuthorID != user.ID) {
+			return forbidden()
+		}
+	}
+	if r.FormValue("deleted") == "true" {
+		if user == nil || !user.IsAdmin() {
+			return forbidden()
+		}
+		f.IncludeDeleted = true
+	}
+
+	chlngs, err := datastore.ListChallenges(ctx, &f)
+	if err != nil {
+		return err
+	}
+	for i, c := range chlngs {
+		if !c.ReviewableBy(user) {
+			chlngs[i] = c.Public()
+		}
+	}
+	return renderJSON(w, chlngs, http.StatusOK)
+}
+
+// updateChallenge replaces a challenge's fields and test cases with the ones
+// given. Test cases with an ID are updated, ones without are added, and
+// existing ones that are left out are removed. Unless a reviewer makes the
+// change, a challenge that's in review or published goes back to being a
+// draft so the change gets reviewed. Changes that could change verdicts
+// rejudge the challenge's submissions.
+func updateChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) (func() error, bufHandler, error) {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return nil, nil, unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.EditableBy(user) {
+		return nil, nil, forbidden()
+	}
+
+	var update model.Challenge
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		return nil, nil, badRequest(err)
+	}
+	if update.ID != 0 && update.ID != c.ID {
+		return nil, nil, validationError("you cannot change the id")
+	}
+
+	validate := func() error { return validateChallenge(&update) }
+	return validate, func(ctx context.Context, w http.ResponseWriter,
+		r *http.Request) error {
+		return applyUpdate(ctx, w, r, user, &update)
+	}, nil
+}
+
+// applyUpdate saves a validated update to the challenge in the request's URL,
+// checking again that user can edit it, since it may have changed while the
+// update was being validated.
+func applyUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	user *model.User, update *model.Challenge) error {
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	if !c.EditableBy(user) {
+		return forbidden()
+	}
+
+	before := model.VersionOf(c)
+	existing := make(map[int64]bool)
+	for _, tc := range c.TestCases {
+		existing[tc.ID] = true
+	}
+	for _, tc := range update.TestCases {
+		if tc.ID != 0 && !existing[tc.ID] {
+			return validationError("test case ids must belong to the challenge")
+		}
+	}
+
+	c.Title = update.Title
+	c.Description = update.Description
+	c.Seconds = update.Seconds
+	c.TimeLimit = update.TimeLimit
+	c.TestCases = update.TestCases
+	c.Solutions = update.Solutions
+	c.Checker = update.Checker
+	c.Tags = update.Tags
+	c.Difficulty = update.Difficulty
+	c.Attribution = update.Attribution
+	if !user.IsReviewer() && (c.Status == model.StatusInReview ||
+		c.Status == model.StatusPublished) {
+		c.Status = model.StatusDraft
+	}
+	if err := datastore.SaveChallenge(ctx, c); err != nil {
+		return err
+	}
+	if !model.VersionOf(c).JudgesLike(before) {
+		if err := autoRejudge(ctx, c); err != nil {
+			return err
+		}
+	}
+	return renderJSON(w, c, http.StatusOK)
+}
+
+func deleteChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	if !c.EditableBy(user) {
+		return forbidden()
+	}
+	if err := datastore.DeleteChallenge(ctx, c.ID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+var statuses = map[string]bool{
+	model.StatusDraft:     true,
+	model.StatusInReview:  true,
+	model.StatusPublished: true,
+	model.StatusRetired:   true,
+}
+
+// canMove reports whether u may move c to status. Authors submit their drafts
+// for review and can withdraw them. Published challenges can be retired by
+// their author or a reviewer, and only reviewers can bring them back.
+// Challenges are only published by approving them with a review.
+func canMove(u *model.User, c *model.Challenge, status string) bool {
+	switch {
+	case c.Status == model.StatusDraft && status == model.StatusInReview,
+		c.Status == model.StatusInReview && status == model.StatusDraft:
+		return c.EditableBy(u)
+	case c.Status == model.StatusPublished && status == model.StatusRetired:
+		return c.EditableBy(u) || u.IsReviewer()
+	case c.Status == model.StatusRetired && status == model.StatusPublished:
+		return u.IsReviewer()
+	}
+	return false
+}
+
+// setChallengeStatus moves a challenge to the status given in the body, like
+// {"status": "in_review"}.
+func setChallengeStatus(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return badRequest(err)
+	}
+	if !statuses[body.Status] {
+		return validationError(fmt.Sprintf("%q isn't a challenge status",
+			body.Status))
+	}
+	if c.Deleted != nil {
+		return validationError("deleted challenges can't change status")
+	}
+	if !canMove(user, c, body.Status) {
+		if c.EditableBy(user) || user.IsReviewer() {
+			return validationError(fmt.Sprintf(
+				"a challenge can't go from %s to %s", c.Status, body.Status))
+		}
+		return forbidden()
+	}
+
+	if err := datastore.SetChallengeStatus(ctx, c, body.Status); err != nil {
+		return err
+	}
+	return renderJSON(w, c, http.StatusOK)
+}
+
+// reviewChallenge approves or rejects a challenge that's in review, which
+// publishes it or sends it back to its author as a draft. Only reviewers can
+// review challenges, and they can't review their own unless they're admins.
+// Rejections must say why in the comment.
+func reviewChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	if !user.IsReviewer() || (c.AuthorID == user.ID && !user.IsAdmin()) {
+		return forbidden()
+	}
+
+	var review model.Review
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		return badRequest(err)
+	}
+	switch {
+	case c.Status != model.StatusInReview:
+		return validationError("only challenges in review can be reviewed")
+	case !review.Approved && review.Comment == "":
+		return validationError("say why the challenge was rejected")
+	}
+
+	review.ID = 0
+	review.ChallengeID = c.ID
+	review.ReviewerID = user.ID
+	if err := datastore.SaveReview(ctx, &review); err != nil {
+		return err
+	}
+	status := model.StatusDraft
+	if review.Approved {
+		status = model.StatusPublished
+	}
+	if err := datastore.SetChallengeStatus(ctx, c, status); err != nil {
+		return err
+	}
+	return renderJSON(w, review, http.StatusCreated)
+}
+
+// challengeReviews lists a challenge's reviews, oldest first. They can be
+// seen by whoever can edit the challenge, and by reviewers while it's in
+// review.
+func challengeReviews(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := reviewableChallengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	reviews, err := datastore.GetReviews(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, reviews, http.StatusOK)
 }
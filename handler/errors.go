@@ -20,3 +20,11 @@ func unauthorized() *httputil.HTTPError {
 	return &httputil.HTTPError{http.StatusUnauthorized,
 		errors.New("unauthorized")}
 }
+
+func forbidden() *httputil.HTTPError {
+	return &httputil.HTTPError{http.StatusForbidden, errors.New("forbidden")}
+}
+
+func notFound(message string) *httputil.HTTPError {
+	return &httputil.HTTPError{http.StatusNotFound, errors.New(message)}
+}
@@ -19,10 +19,29 @@ import (
 
 func Handler() *mux.Router {
 	m := router.API()
-	m.Get(router.SubmitChallenge).Handler(bufHandler(submitChallenge))
-	// TODO: m.Get(router.Challenge).Handler(bufHandler(getPost))
-	// TODO: m.Get(router.CurrentChallenge).Handler(bufHandler(currentChallenge))
+	m.Get(router.Challenges).Handler(bufHandler(listChallenges))
+	m.Get(router.SubmitChallenge).Handler(slowHandler(submitChallenge))
+	m.Get(router.Challenge).Handler(bufHandler(getChallenge))
+	m.Get(router.UpdateChallenge).Handler(slowHandler(updateChallenge))
+	m.Get(router.DeleteChallenge).Handler(bufHandler(deleteChallenge))
+	m.Get(router.CurrentChallenge).Handler(bufHandler(currentChallenge))
+	m.Get(router.SetChallengeStatus).Handler(bufHandler(setChallengeStatus))
+	m.Get(router.ChallengeReviews).Handler(bufHandler(challengeReviews))
+	m.Get(router.ReviewChallenge).Handler(bufHandler(reviewChallenge))
+	m.Get(router.ImportChallenge).Handler(slowHandler(importChallenge))
+	m.Get(router.ExportChallenge).Handler(bufHandler(exportChallenge))
+	m.Get(router.ChallengeVersions).Handler(bufHandler(challengeVersions))
+	m.Get(router.ChallengeVersion).Handler(bufHandler(challengeVersion))
+	m.Get(router.ChallengeRejudges).Handler(bufHandler(challengeRejudges))
+	m.Get(router.RejudgeChallenge).Handler(bufHandler(rejudgeChallenge))
+	m.Get(router.ChallengeStats).Handler(bufHandler(challengeStats))
+	m.Get(router.Rejudge).Handler(bufHandler(getRejudge))
+	m.Get(router.UserRatings).Handler(bufHandler(userRatings))
+	m.Get(router.UserRatingHistory).Handler(bufHandler(userRatingHistory))
+	m.Get(router.TopRatings).Handler(bufHandler(topRatings))
+	m.Get(router.RoundReplay).Handler(handler(replayRound))
 	m.Get(router.WebsocketConnect).Handler(handler(wsConnect))
+	m.Get(router.ProtocolSchema).Handler(bufHandler(protocolSchema))
 
 	m.Get(router.OauthLogin).Handler(bufHandler(oauthLogin))
 	m.Get(router.OauthAccessToken).Handler(bufHandler(oauthAccessToken))
@@ -68,13 +87,7 @@ func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type bufHandler func(context.Context, http.ResponseWriter, *http.Request) error
 
 func (h bufHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if rv := recover(); rv != nil {
-			err := errors.New("handler panic")
-			logError(r, err, rv)
-			handleAPIError(w, r, http.StatusInternalServerError, err, false)
-		}
-	}()
+	defer recoverPanic(w, r)
 
 	var (
 		rb  httputil.ResponseBuffer
@@ -91,17 +104,66 @@ func (h bufHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		rb.WriteTo(w)
 		tx.Commit()
-	} else if e, ok := err.(*httputil.HTTPError); ok {
+	} else {
+		renderError(w, r, err)
+		tx.Rollback()
+	}
+}
+
+// slowHandler handles requests that need slow work done that doesn't touch
+// the database, like running a challenge's solutions, without holding a
+// database connection through it. The handler reads and authorizes the
+// request in one transaction, returning the work to do and a bufHandler that
+// finishes the request in another transaction once the work is done.
+// Anything the finishing handler relies on may have changed in between, so
+// it has to check it again.
+type slowHandler func(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) (work func() error, finish bufHandler, err error)
+
+func (h slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		work   func() error
+		finish bufHandler
+	)
+	bufHandler(func(ctx context.Context, w http.ResponseWriter,
+		r *http.Request) error {
+		var err error
+		work, finish, err = h(ctx, w, r)
+		return err
+	}).ServeHTTP(w, r)
+	if finish == nil {
+		return
+	}
+
+	defer recoverPanic(w, r)
+	if err := work(); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	finish.ServeHTTP(w, r)
+}
+
+// recoverPanic responds with an internal server error if the handler
+// panicked. It must be deferred.
+func recoverPanic(w http.ResponseWriter, r *http.Request) {
+	if rv := recover(); rv != nil {
+		err := errors.New("handler panic")
+		logError(r, err, rv)
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+	}
+}
+
+// renderError responds with err, logging it unless it's the client's fault.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	if e, ok := err.(*httputil.HTTPError); ok {
 		if e.Status >= 500 {
 			logError(r, err, nil)
 		}
 		handleAPIError(w, r, e.Status, e.Err, true)
-		tx.Rollback()
-	} else {
-		logError(r, err, nil)
-		handleAPIError(w, r, http.StatusInternalServerError, err, false)
-		tx.Rollback()
+		return
 	}
+	logError(r, err, nil)
+	handleAPIError(w, r, http.StatusInternalServerError, err, false)
 }
 
 func logError(req *http.Request, err error, rv interface{}) {
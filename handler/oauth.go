@@ -2,8 +2,11 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 
 	"github.com/google/go-github/github"
 	"github.com/zachlatta/calhacks/config"
@@ -14,9 +17,17 @@ import (
 	"code.google.com/p/goauth2/oauth"
 )
 
+// oauthLogin sends the user to GitHub to log in. Clients running on the
+// user's machine, like the command line client, can pass a redirect URL on
+// the loopback interface to get the token sent there instead of to the
+// homepage.
 func oauthLogin(ctx context.Context, w http.ResponseWriter,
 	r *http.Request) error {
-	http.Redirect(w, r, config.GitHubOauthConfig().AuthCodeURL(""),
+	redirect := r.FormValue("redirect")
+	if redirect != "" && !isLoopbackURL(redirect) {
+		return badRequest(errors.New("redirect must be a loopback http URL"))
+	}
+	http.Redirect(w, r, config.GitHubOauthConfig().AuthCodeURL(redirect),
 		http.StatusTemporaryRedirect)
 	return nil
 }
@@ -52,12 +63,37 @@ func oauthAccessToken(ctx context.Context, w http.ResponseWriter,
 		return err
 	}
 
-	jwtTok, err := createToken(user)
+	jwtTok, err := CreateToken(user)
 	if err != nil {
 		return err
 	}
 
+	if redirect := r.FormValue("state"); isLoopbackURL(redirect) {
+		http.Redirect(w, r, fmt.Sprintf("%s?tok=%s", redirect, jwtTok),
+			http.StatusTemporaryRedirect)
+		return nil
+	}
+
 	http.Redirect(w, r, fmt.Sprintf("%s/login?tok=%s", config.HomepageURL(),
 		jwtTok), http.StatusTemporaryRedirect)
 	return nil
 }
+
+// isLoopbackURL reports whether s is a plain http URL on the loopback
+// interface. Tokens are only ever sent to URLs like these so they can't be
+// redirected to someone else's server.
+func isLoopbackURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "http" || u.RawQuery != "" {
+		return false
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
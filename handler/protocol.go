@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/zachlatta/calhacks/protocol"
+
+	"code.google.com/p/go.net/context"
+)
+
+func protocolSchema(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	return renderJSON(w, protocol.Schema(), http.StatusOK)
+}
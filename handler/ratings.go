@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+func userFromRequest(ctx context.Context, r *http.Request) (*model.User,
+	error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	user, err := datastore.GetUser(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil, notFound("user not found")
+	}
+	return user, err
+}
+
+// userRatings returns a user's overall rating and their rating in each
+// language they've used.
+func userRatings(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, err := userFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	ratings, err := datastore.GetUserRatings(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, ratings, http.StatusOK)
+}
+
+// userRatingHistory returns a user's rating after each round they've been
+// rated in, oldest first, for charting. It's their overall rating unless a
+// lang parameter is given.
+func userRatingHistory(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, err := userFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	changes, err := datastore.GetRatingChanges(ctx, user.ID,
+		r.FormValue("lang"))
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, changes, http.StatusOK)
+}
+
+// topRatings returns the highest ratings, overall or in the language given
+// by the lang parameter. The limit parameter says how many, up to 100.
+func topRatings(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	limit := defaultPageSize
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return badRequest(errors.New("limit must be between 1 and 100"))
+		}
+	}
+	ratings, err := datastore.GetTopRatings(ctx, r.FormValue("lang"), limit)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, ratings, http.StatusOK)
+}
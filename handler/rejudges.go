@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// autoRejudge queues a rejudge of c's submissions that were judged against
+// an older version, if it has any submissions at all.
+func autoRejudge(ctx context.Context, c *model.Challenge) error {
+	n, err := datastore.CountChallengeSubmissions(ctx, c.ID)
+	if err != nil || n == 0 {
+		return err
+	}
+	reason := fmt.Sprintf("version %d changed how solutions are judged",
+		c.Version)
+	return datastore.SaveRejudge(ctx, &model.Rejudge{
+		ChallengeID: c.ID,
+		Reason:      reason,
+		OnlyStale:   true,
+	})
+}
+
+// rejudgeChallenge queues a rejudge of every submission to a challenge. Only
+// admins can start rejudges. The body can give a reason, like
+// {"reason": "test case 3 was wrong"}.
+func rejudgeChallenge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	if !user.IsAdmin() {
+		return forbidden()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	defer r.Body.Close()
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return badRequest(err)
+		}
+	}
+	if body.Reason == "" {
+		body.Reason = "requested by an admin"
+	}
+
+	rejudge := &model.Rejudge{
+		ChallengeID: c.ID,
+		RequestedBy: user.ID,
+		Reason:      body.Reason,
+	}
+	if err := datastore.SaveRejudge(ctx, rejudge); err != nil {
+		return err
+	}
+	return renderJSON(w, rejudge, http.StatusAccepted)
+}
+
+// challengeRejudges lists a challenge's rejudges, newest first.
+func challengeRejudges(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := reviewableChallengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	rejudges, err := datastore.GetChallengeRejudges(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, rejudges, http.StatusOK)
+}
+
+// getRejudge returns a rejudge with how it changed each submission it
+// reran. It can be seen by whoever can edit the challenge, and by reviewers
+// while it's in review.
+func getRejudge(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return unauthorized()
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		return badRequest(err)
+	}
+	rejudge, err := datastore.GetRejudge(ctx, id)
+	if err == sql.ErrNoRows {
+		return notFound("rejudge not found")
+	} else if err != nil {
+		return err
+	}
+	c, err := datastore.GetChallenge(ctx, rejudge.ChallengeID)
+	if err != nil {
+		return err
+	}
+	if !c.ReviewableBy(user) {
+		return forbidden()
+	}
+	return renderJSON(w, rejudge, http.StatusOK)
+}
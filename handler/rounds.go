@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/zachlatta/calhacks/datastore"
+
+	"code.google.com/p/go.net/context"
+)
+
+// maxReplaySpeed is how many times faster than real time rounds can be
+// replayed.
+const maxReplaySpeed = 100
+
+// replayRound streams a round's event log back as newline-delimited JSON,
+// sleeping between events so they arrive with their original spacing. The
+// speed parameter plays the round back faster than real time. The events are
+// loaded up front so the request's transaction isn't held open while they're
+// streamed. The timer's ticks aren't in the log; the round's length is in the
+// challenge of its challengeSet event, so count down from that instead.
+func replayRound(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		handleAPIError(w, r, http.StatusBadRequest, err, true)
+		return
+	}
+
+	speed := 1.0
+	if s := r.FormValue("speed"); s != "" {
+		speed, err = strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(speed) || speed < 1 ||
+			speed > maxReplaySpeed {
+			handleAPIError(w, r, http.StatusBadRequest,
+				fmt.Errorf("speed must be a number from 1 to %d",
+					maxReplaySpeed), true)
+			return
+		}
+	}
+
+	round, err := datastore.GetRound(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			handleAPIError(w, r, http.StatusNotFound,
+				errors.New("round not found"), true)
+			return
+		}
+		logError(r, err, nil)
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+		return
+	}
+
+	// Events sent privately to other players are never replayed.
+	var recipientID int64
+	if user, ok := datastore.UserFromContext(ctx); ok {
+		recipientID = user.ID
+	}
+	events, err := datastore.GetRoundEvents(ctx, round.ID, recipientID)
+	if err != nil {
+		logError(r, err, nil)
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+		return
+	}
+	tx, _ := datastore.TxFromContext(ctx)
+	if err := tx.Commit(); err != nil {
+		logError(r, err, nil)
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, e := range events {
+		if i > 0 {
+			gap := e.Created.Sub(events[i-1].Created)
+			time.Sleep(time.Duration(float64(gap) / speed))
+		}
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
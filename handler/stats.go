@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/zachlatta/calhacks/datastore"
+
+	"code.google.com/p/go.net/context"
+)
+
+// challengeStats returns how players have done on a challenge. Anyone who
+// can see the challenge can see its stats.
+func challengeStats(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	stats, err := datastore.GetChallengeStats(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, stats, http.StatusOK)
+}
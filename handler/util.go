@@ -16,7 +16,8 @@ func renderJSON(w http.ResponseWriter, v interface{}, status int) error {
 	return json.NewEncoder(w).Encode(&v)
 }
 
-func createToken(u *model.User) (string, error) {
+// CreateToken issues the JWT that authenticates u with the API.
+func CreateToken(u *model.User) (string, error) {
 	token := jwt.New(jwt.GetSigningMethod("HS256"))
 	token.Claims["id"] = u.ID
 	token.Claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
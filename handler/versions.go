@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/zachlatta/calhacks/datastore"
+	"github.com/zachlatta/calhacks/model"
+
+	"code.google.com/p/go.net/context"
+)
+
+// reviewableChallengeFromRequest is like challengeFromRequest, but only finds
+// challenges the user can see everything in: ones they can edit, or ones in
+// review if they're a reviewer.
+func reviewableChallengeFromRequest(ctx context.Context,
+	r *http.Request) (*model.Challenge, error) {
+	user, ok := datastore.UserFromContext(ctx)
+	if !ok {
+		return nil, unauthorized()
+	}
+	c, err := challengeFromRequest(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if !c.ReviewableBy(user) {
+		return nil, forbidden()
+	}
+	return c, nil
+}
+
+// challengeVersions lists a challenge's versions, oldest first, with what
+// changed in each one since the version before. Test cases are left out, so
+// get a single version to see them.
+func challengeVersions(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := reviewableChallengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	versions, err := datastore.GetChallengeVersions(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	for i, v := range versions {
+		if i > 0 {
+			v.Diff = v.DiffFrom(versions[i-1])
+		}
+	}
+	for _, v := range versions {
+		v.TestCases = nil
+	}
+	return renderJSON(w, versions, http.StatusOK)
+}
+
+// challengeVersion returns one version of a challenge, with its test cases
+// and what changed since the version before.
+func challengeVersion(ctx context.Context, w http.ResponseWriter,
+	r *http.Request) error {
+	c, err := reviewableChallengeFromRequest(ctx, r)
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(mux.Vars(r)["Version"])
+	if err != nil {
+		return badRequest(err)
+	}
+	v, err := datastore.GetChallengeVersion(ctx, c.ID, n)
+	if err == sql.ErrNoRows {
+		return notFound("version not found")
+	} else if err != nil {
+		return err
+	}
+	if n > 1 {
+		prev, err := datastore.GetChallengeVersion(ctx, c.ID, n-1)
+		if err != nil {
+			return err
+		}
+		v.Diff = v.DiffFrom(prev)
+	}
+	return renderJSON(w, v, http.StatusOK)
+}
@@ -1,13 +1,15 @@
 package handler
 
 import (
-	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/zachlatta/calhacks"
 	"github.com/zachlatta/calhacks/datastore"
 	"github.com/zachlatta/calhacks/game"
+	"github.com/zachlatta/calhacks/protocol"
 
 	"code.google.com/p/go.net/context"
 )
@@ -15,26 +17,71 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    protocol.Subprotocols,
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
+// wsConnect connects the user to the main room, or to the room given by the
+// room parameter if they were matched into it. Clients choose a protocol
+// version by requesting its websocket subprotocol, and get version 1 if they
+// don't request one. Clients that lost their connection can pass the token of
+// the session they were in and the sequence number of the last message they
+// got to pick up where they left off. Any connection the user already has is
+// closed in favor of the new one.
 func wsConnect(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user, _ := datastore.UserFromContext(ctx)
 	if user == nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if calhacks.Game.Hub.ConnForUserExists(user) {
-		handleAPIError(w, r, http.StatusConflict,
-			errors.New("connection for user already exists"), true)
+	room := r.FormValue("room")
+	if room == "" {
+		room = game.MainRoom
+	}
+	ok, err := calhacks.Lobby.CanJoin(user.ID, room)
+	if err != nil {
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+		return
+	}
+	if !ok {
+		http.Error(w, "you haven't been matched into that room",
+			http.StatusForbidden)
+		return
+	}
+	g := calhacks.Lobby.Room(room)
+	until, err := g.KickedUntil(user.ID)
+	if err != nil {
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
+		return
+	}
+	if time.Now().Before(until) {
+		http.Error(w, "kicked from the room until "+until.Format(time.RFC3339),
+			http.StatusForbidden)
+		return
+	}
+	var lastSeq int64
+	if s := r.FormValue("last_seq"); s != "" {
+		lastSeq, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			handleAPIError(w, r, http.StatusBadRequest, err, true)
+			return
+		}
+	}
+	// Connections stay open for as long as the player plays, so the
+	// request's transaction is finished before it's upgraded rather than
+	// holding a database connection the whole time.
+	tx, _ := datastore.TxFromContext(ctx)
+	if err := tx.Commit(); err != nil {
+		handleAPIError(w, r, http.StatusInternalServerError, err, false)
 		return
 	}
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
-	c := game.NewConn(ws, make(chan interface{}), user)
-	calhacks.Game.Hub.RegisterAndProcessConn(c)
+	c := game.NewConn(ws, make(chan interface{}, 256), user,
+		r.FormValue("session"), lastSeq)
+	g.Hub.RegisterAndProcessConn(c)
 }
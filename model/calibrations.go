@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+// Attempt is a player's try at a challenge in a single round, made up of
+// their submissions in it.
+type Attempt struct {
+	RoundID     int64 `json:"round_id"`
+	UserID      int64 `json:"user_id"`
+	ChallengeID int64 `json:"challenge_id"`
+	Solved      bool  `json:"solved"`
+
+	// Lang is the language of the submission that solved the challenge, or
+	// of the player's last submission if none did.
+	Lang string `json:"lang"`
+
+	// SolveSeconds is how far into the round the challenge was solved, and
+	// RoundSeconds how long the round was.
+	SolveSeconds int `json:"solve_seconds"`
+	RoundSeconds int `json:"round_seconds"`
+}
+
+// Calibration is how hard a challenge is estimated to be from how players
+// have done on it, and what its difficulty and round length should be.
+type Calibration struct {
+	ChallengeID int64     `json:"challenge_id"`
+	Calibrated  time.Time `json:"calibrated"`
+
+	// Rating is the challenge's Elo rating, on the same scale as players'.
+	// A player rated the same as a challenge is expected to solve it at the
+	// very end of the round.
+	Rating   float64 `json:"rating"`
+	Attempts int     `json:"attempts"`
+
+	// Difficulty and Seconds are left empty until there's enough data to
+	// suggest them.
+	Difficulty string `json:"difficulty,omitempty"`
+	Seconds    int    `json:"seconds,omitempty"`
+
+	// Applied is whether the suggestions have been applied to the
+	// challenge.
+	Applied bool `json:"applied"`
+}
@@ -2,19 +2,151 @@ package model
 
 import "time"
 
+// TestCase is an input to run a solution with and the output it must print.
+// Sample test cases are shown to players and can be run without being
+// graded. The rest are hidden and used for grading.
 type TestCase struct {
+	ID             int64     `json:"id"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+	Input          string    `json:"input"`
+	ExpectedOutput string    `json:"expected_output"`
+	Sample         bool      `json:"sample"`
+}
+
+// Solution is an author's solution to a challenge, used to check that the
+// challenge's test cases are right before it's saved. Correct solutions must
+// pass every test case, and wrong ones must fail at least one.
+type Solution struct {
 	ID      int64     `json:"id"`
 	Created time.Time `json:"created"`
 	Updated time.Time `json:"updated"`
+	Lang    string    `json:"lang"`
+	Code    string    `json:"code"`
+	Correct bool      `json:"correct"`
+}
+
+// Checker decides whether a solution's output is right, for challenges with
+// more than one right answer. It's given a JSON object on stdin with the
+// test case's "input" and "expected_output" and the solution's "output", and
+// must print "ok" on its first line if the output is right. Anything else it
+// prints says why it isn't.
+type Checker struct {
+	Lang string `json:"lang"`
+	Code string `json:"code"`
+}
+
+// Difficulties a challenge can be rated.
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Attribution credits where a challenge came from when it wasn't written for
+// calhacks, like a contest it was first used in.
+type Attribution struct {
+	Author string `json:"author"`
+	Source string `json:"source"`
+	URL    string `json:"url"`
 }
 
+// Statuses a challenge moves through. Challenges start as drafts, are
+// submitted for review by their author, and are published once a reviewer
+// approves them. Only published challenges are picked for rounds. Retired
+// challenges were once published but have been taken out of rotation.
+const (
+	StatusDraft     = "draft"
+	StatusInReview  = "in_review"
+	StatusPublished = "published"
+	StatusRetired   = "retired"
+)
+
 type Challenge struct {
-	ID             int64      `json:"id"`
-	Created        time.Time  `json:"created"`
-	Updated        time.Time  `json:"updated"`
-	Title          string     `json:"title"`
-	Description    string     `json:"description"`
-	Seconds        int        `json:"seconds"`
-	ExpectedOutput string     `json:"-"`
-	TestCases      []TestCase `json:"test_cases"`
+	ID          int64      `json:"id"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Seconds     int        `json:"seconds"`
+	AuthorID    int64      `json:"author_id"`
+	Status      string     `json:"status"`
+	Version     int        `json:"version"`
+	Tags        []string   `json:"tags"`
+	Difficulty  string     `json:"difficulty,omitempty"`
+	TestCases   []TestCase `json:"test_cases"`
+	Solutions   []Solution `json:"solutions,omitempty"`
+
+	// Checker judges solutions' output. Without one, output must match the
+	// expected output, ignoring leading and trailing whitespace.
+	Checker *Checker `json:"checker,omitempty"`
+
+	Attribution *Attribution `json:"attribution,omitempty"`
+
+	// TimeLimit is how long solutions may run for each test case, in
+	// milliseconds.
+	TimeLimit int `json:"time_limit"`
+
+	// Deleted is when the challenge was deleted, if it has been.
+	Deleted *time.Time `json:"deleted,omitempty"`
+}
+
+// EditableBy reports whether u may change c.
+func (c *Challenge) EditableBy(u *User) bool {
+	return u != nil && (u.IsAdmin() || (c.AuthorID != 0 && c.AuthorID == u.ID))
+}
+
+// ReviewableBy reports whether u may see all of c, including its hidden test
+// cases and solutions. Besides those who can edit it, reviewers can while
+// it's in review.
+func (c *Challenge) ReviewableBy(u *User) bool {
+	return c.EditableBy(u) ||
+		(u != nil && u.IsReviewer() && c.Status == StatusInReview)
+}
+
+// VisibleTo reports whether u may see c. Challenges that haven't been
+// published are only visible to their author and reviewers.
+func (c *Challenge) VisibleTo(u *User) bool {
+	if c.Deleted != nil {
+		return c.EditableBy(u)
+	}
+	return c.Status == StatusPublished || c.EditableBy(u) ||
+		(u != nil && u.IsReviewer())
+}
+
+// Public returns a copy of c that's safe to show players, without its hidden
+// test cases, solutions or checker.
+func (c *Challenge) Public() *Challenge {
+	if c == nil {
+		return nil
+	}
+	pub := *c
+	pub.TestCases = c.Samples()
+	pub.Solutions = nil
+	pub.Checker = nil
+	return &pub
+}
+
+func (c *Challenge) TimeLimitDuration() time.Duration {
+	return time.Duration(c.TimeLimit) * time.Millisecond
+}
+
+// Samples returns c's sample test cases.
+func (c *Challenge) Samples() []TestCase {
+	return c.testCases(true)
+}
+
+// HiddenTestCases returns the test cases c is graded with.
+func (c *Challenge) HiddenTestCases() []TestCase {
+	return c.testCases(false)
+}
+
+func (c *Challenge) testCases(sample bool) []TestCase {
+	tcs := []TestCase{}
+	for _, tc := range c.TestCases {
+		if tc.Sample == sample {
+			tcs = append(tcs, tc)
+		}
+	}
+	return tcs
 }
@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// GameEvent is a single state transition in a room's durable event log.
+// Fields that don't apply to an event's type are left as zero values.
+type GameEvent struct {
+	ID          int64     `json:"id"`
+	Created     time.Time `json:"created"`
+	Room        string    `json:"room"`
+	Type        string    `json:"type"`
+	RoundID     int64     `json:"round_id"`
+	ChallengeID int64     `json:"challenge_id"`
+	UserID      int64     `json:"user_id"`
+	Seconds     int       `json:"seconds"`
+	Node        string    `json:"node"`
+}
+
+// GameSnapshot is a room's state as of one of its game events, so the state
+// can be worked out from the events after it instead of the whole log.
+type GameSnapshot struct {
+	Room        string         `json:"room"`
+	EventID     int64          `json:"event_id"`
+	RoundID     int64          `json:"round_id"`
+	ChallengeID int64          `json:"challenge_id"`
+	IsBreak     bool           `json:"is_break"`
+	Started     time.Time      `json:"started"`
+	Seconds     int            `json:"seconds"`
+	Users       []SnapshotUser `json:"users"`
+	Solvers     []int64        `json:"solvers"`
+}
+
+// SnapshotUser is a player in a room as of a snapshot, and the node they're
+// connected to.
+type SnapshotUser struct {
+	ID   int64  `json:"id"`
+	Node string `json:"node"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Rating is a player's Glicko-2 skill rating, overall or in a single
+// language. Ratings are updated after every round from the order players
+// finished in.
+type Rating struct {
+	UserID int64 `json:"user_id"`
+
+	// Lang is the language the rating is for, or empty for the overall
+	// rating.
+	Lang string `json:"lang,omitempty"`
+
+	Rating float64 `json:"rating"`
+
+	// Deviation is how uncertain the rating is. It shrinks the more rounds
+	// a player finishes, and Volatility is how erratic their results are.
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+
+	Rounds  int       `json:"rounds"`
+	Updated time.Time `json:"updated"`
+}
+
+// RatingChange is a player's rating after a round, for charting how it's
+// changed over time.
+type RatingChange struct {
+	RoundID   int64     `json:"round_id"`
+	Created   time.Time `json:"created"`
+	Rating    float64   `json:"rating"`
+	Deviation float64   `json:"deviation"`
+}
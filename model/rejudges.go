@@ -0,0 +1,68 @@
+package model
+
+import "time"
+
+// Statuses a rejudge moves through.
+const (
+	RejudgePending  = "pending"
+	RejudgeRunning  = "running"
+	RejudgeFinished = "finished"
+	RejudgeFailed   = "failed"
+)
+
+// Rejudge reruns a challenge's stored submissions against its current
+// version, and rescores them. Rejudges are started by admins, or
+// automatically when a challenge's hidden test cases, checker or time limit
+// change.
+type Rejudge struct {
+	ID          int64     `json:"id"`
+	Created     time.Time `json:"created"`
+	ChallengeID int64     `json:"challenge_id"`
+
+	// RequestedBy is the admin who started the rejudge, or zero if it was
+	// started automatically.
+	RequestedBy int64  `json:"requested_by,omitempty"`
+	Reason      string `json:"reason"`
+
+	// OnlyStale limits the rejudge to submissions judged against an older
+	// version of the challenge.
+	OnlyStale bool `json:"only_stale"`
+
+	Status   string     `json:"status"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	// Submissions is how many submissions were rerun, and Changed how many
+	// of them got a different verdict or score.
+	Submissions int    `json:"submissions"`
+	Changed     int    `json:"changed"`
+	Error       string `json:"error,omitempty"`
+
+	Results []*RejudgeResult `json:"results,omitempty"`
+}
+
+// RejudgeResult records how a rejudge changed a single submission.
+type RejudgeResult struct {
+	ID             int64 `json:"id"`
+	RejudgeID      int64 `json:"rejudge_id"`
+	SubmissionID   int64 `json:"submission_id"`
+	UserID         int64 `json:"user_id"`
+	OldVersion     int   `json:"old_version"`
+	NewVersion     int   `json:"new_version"`
+	OldPassed      bool  `json:"old_passed"`
+	NewPassed      bool  `json:"new_passed"`
+	OldTestsPassed int   `json:"old_tests_passed"`
+	NewTestsPassed int   `json:"new_tests_passed"`
+	OldTestsTotal  int   `json:"old_tests_total"`
+	NewTestsTotal  int   `json:"new_tests_total"`
+	OldPoints      int   `json:"old_points"`
+	NewPoints      int   `json:"new_points"`
+}
+
+// Changed reports whether the submission's verdict or score changed.
+func (r *RejudgeResult) Changed() bool {
+	return r.OldPassed != r.NewPassed ||
+		r.OldTestsPassed != r.NewTestsPassed ||
+		r.OldTestsTotal != r.NewTestsTotal ||
+		r.OldPoints != r.NewPoints
+}
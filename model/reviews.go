@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Review is a reviewer's verdict on a challenge that was submitted for
+// review. Approving a challenge publishes it, and rejecting it sends it back
+// to its author as a draft.
+type Review struct {
+	ID          int64     `json:"id"`
+	Created     time.Time `json:"created"`
+	ChallengeID int64     `json:"challenge_id"`
+	ReviewerID  int64     `json:"reviewer_id"`
+	Approved    bool      `json:"approved"`
+	Comment     string    `json:"comment"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Round struct {
+	ID          int64     `json:"id"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Room        string    `json:"room"`
+	ChallengeID int64     `json:"challenge_id"`
+}
+
+// RoundEvent is a single entry in a round's append-only event log. Broadcast
+// events have a RecipientID of 0.
+type RoundEvent struct {
+	ID          int64           `json:"id"`
+	Created     time.Time       `json:"created"`
+	RoundID     int64           `json:"round_id"`
+	RecipientID int64           `json:"recipient_id,omitempty"`
+	Event       json.RawMessage `json:"event"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+// ChallengeStats sum up every graded submission to a challenge, to show how
+// hard it is.
+type ChallengeStats struct {
+	ChallengeID int64      `json:"challenge_id"`
+	Updated     *time.Time `json:"updated,omitempty"`
+	Submissions int        `json:"submissions"`
+
+	// Attempts counts each player once for each round they submitted a
+	// solution in, and Solves how many of those attempts ended in a solve.
+	Attempts  int     `json:"attempts"`
+	Solves    int     `json:"solves"`
+	SolveRate float64 `json:"solve_rate"`
+
+	// MedianSolveSeconds is the median time from a round starting to a
+	// player solving it, or nil if no one has.
+	MedianSolveSeconds *float64 `json:"median_solve_seconds"`
+
+	// Languages are ordered by how many submissions were written in them.
+	Languages []*LangStats `json:"languages"`
+
+	// MostFailedTest is the test case that's failed the most submissions,
+	// or nil if none have failed one.
+	MostFailedTest *TestFailures `json:"most_failed_test"`
+
+	// Calibration is the challenge's latest calibration, if it's been
+	// calibrated.
+	Calibration *Calibration `json:"calibration,omitempty"`
+}
+
+// LangStats sum up the submissions to a challenge in a single language.
+type LangStats struct {
+	Lang        string `json:"lang"`
+	Submissions int    `json:"submissions"`
+	Solves      int    `json:"solves"`
+}
+
+// TestFailures is how many submissions failed on a test case.
+type TestFailures struct {
+	TestCaseID int64 `json:"test_case_id"`
+	Failures   int   `json:"failures"`
+}
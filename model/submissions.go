@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// Submission is a solution a player submitted for grading during a round.
+type Submission struct {
+	ID          int64     `json:"id"`
+	Created     time.Time `json:"created"`
+	UserID      int64     `json:"user_id"`
+	RoundID     int64     `json:"round_id"`
+	ChallengeID int64     `json:"challenge_id"`
+	Room        string    `json:"room"`
+	Lang        string    `json:"lang"`
+	Code        string    `json:"code"`
+	Passed      bool      `json:"passed"`
+	TestsPassed int       `json:"tests_passed"`
+	TestsTotal  int       `json:"tests_total"`
+
+	// ChallengeVersion is the version of the challenge the submission was
+	// judged against.
+	ChallengeVersion int `json:"challenge_version"`
+
+	// Points is what the submission added to the player's score. Only the
+	// first passing submission in a round scores.
+	Points int `json:"points"`
+
+	// FailedTestID is the hidden test case the submission failed on, if it
+	// failed one.
+	FailedTestID int64 `json:"failed_test_id,omitempty"`
+}
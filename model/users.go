@@ -12,4 +12,21 @@ type User struct {
 	GitHubURL      string    `json:"github_url"`
 	AccessToken    string    `json:"-"`
 	Score          int64     `json:"score"`
+	Role           string    `json:"role"`
+}
+
+// Roles a user can have. Reviewers approve challenges before they're
+// published. Admins can moderate every room and do anything a reviewer can.
+const (
+	RolePlayer   = "player"
+	RoleReviewer = "reviewer"
+	RoleAdmin    = "admin"
+)
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+func (u *User) IsReviewer() bool {
+	return u.Role == RoleReviewer || u.IsAdmin()
 }
@@ -0,0 +1,204 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// ChallengeVersion is an unchanging snapshot of everything a challenge's
+// solutions are judged by: its statement, time limit, checker and test cases.
+// A new version is made whenever any of them change, so submissions can say
+// exactly what they were judged against.
+type ChallengeVersion struct {
+	ID          int64      `json:"id"`
+	ChallengeID int64      `json:"challenge_id"`
+	Version     int        `json:"version"`
+	Created     time.Time  `json:"created"`
+	EditorID    int64      `json:"editor_id,omitempty"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	TimeLimit   int        `json:"time_limit"`
+	Checker     *Checker   `json:"checker,omitempty"`
+	TestCases   []TestCase `json:"test_cases,omitempty"`
+
+	// Diff is what changed since the version before, when it's asked for.
+	Diff *VersionDiff `json:"diff,omitempty"`
+}
+
+// VersionOf returns a snapshot of c as it is now. It isn't numbered.
+func VersionOf(c *Challenge) *ChallengeVersion {
+	v := &ChallengeVersion{
+		ChallengeID: c.ID,
+		Title:       c.Title,
+		Description: c.Description,
+		TimeLimit:   c.TimeLimit,
+		TestCases:   append([]TestCase{}, c.TestCases...),
+	}
+	if c.Checker != nil {
+		checker := *c.Checker
+		v.Checker = &checker
+	}
+	return v
+}
+
+// VersionDiff is what changed from one version of a challenge to the next.
+type VersionDiff struct {
+	// Changed names the fields that changed, out of title, description,
+	// time_limit, checker and test_cases.
+	Changed []string `json:"changed"`
+
+	// Description is a line by line diff of the description. Each line
+	// starts with "+" if it was added, "-" if it was removed, or " " if it
+	// wasn't changed.
+	Description []string `json:"description,omitempty"`
+
+	// TestCasesAdded, TestCasesRemoved and TestCasesChanged are the IDs of
+	// the test cases that changed.
+	TestCasesAdded   []int64 `json:"test_cases_added,omitempty"`
+	TestCasesRemoved []int64 `json:"test_cases_removed,omitempty"`
+	TestCasesChanged []int64 `json:"test_cases_changed,omitempty"`
+}
+
+// SameAs reports whether v and o judge solutions the same way.
+func (v *ChallengeVersion) SameAs(o *ChallengeVersion) bool {
+	return len(v.DiffFrom(o).Changed) == 0
+}
+
+// JudgesLike reports whether v and o would give every solution the same
+// verdict: whether they have the same time limit, checker and hidden test
+// cases.
+func (v *ChallengeVersion) JudgesLike(o *ChallengeVersion) bool {
+	if v.TimeLimit != o.TimeLimit ||
+		(v.Checker == nil) != (o.Checker == nil) ||
+		(v.Checker != nil && *v.Checker != *o.Checker) {
+		return false
+	}
+	var hidden, oHidden []TestCase
+	for _, tc := range v.TestCases {
+		if !tc.Sample {
+			hidden = append(hidden, tc)
+		}
+	}
+	for _, tc := range o.TestCases {
+		if !tc.Sample {
+			oHidden = append(oHidden, tc)
+		}
+	}
+	if len(hidden) != len(oHidden) {
+		return false
+	}
+	for i, tc := range hidden {
+		if tc.Input != oHidden[i].Input ||
+			tc.ExpectedOutput != oHidden[i].ExpectedOutput {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffFrom returns what changed from prev to v.
+func (v *ChallengeVersion) DiffFrom(prev *ChallengeVersion) *VersionDiff {
+	d := &VersionDiff{Changed: []string{}}
+	if v.Title != prev.Title {
+		d.Changed = append(d.Changed, "title")
+	}
+	if v.Description != prev.Description {
+		d.Changed = append(d.Changed, "description")
+		d.Description = diffLines(prev.Description, v.Description)
+	}
+	if v.TimeLimit != prev.TimeLimit {
+		d.Changed = append(d.Changed, "time_limit")
+	}
+	if (v.Checker == nil) != (prev.Checker == nil) ||
+		(v.Checker != nil && *v.Checker != *prev.Checker) {
+		d.Changed = append(d.Changed, "checker")
+	}
+
+	old := make(map[int64]TestCase)
+	for _, tc := range prev.TestCases {
+		old[tc.ID] = tc
+	}
+	for _, tc := range v.TestCases {
+		o, ok := old[tc.ID]
+		switch {
+		case !ok:
+			d.TestCasesAdded = append(d.TestCasesAdded, tc.ID)
+		case o.Input != tc.Input || o.ExpectedOutput != tc.ExpectedOutput ||
+			o.Sample != tc.Sample:
+			d.TestCasesChanged = append(d.TestCasesChanged, tc.ID)
+		}
+		delete(old, tc.ID)
+	}
+	for _, tc := range prev.TestCases {
+		if _, ok := old[tc.ID]; ok {
+			d.TestCasesRemoved = append(d.TestCasesRemoved, tc.ID)
+		}
+	}
+	if len(d.TestCasesAdded) > 0 || len(d.TestCasesRemoved) > 0 ||
+		len(d.TestCasesChanged) > 0 {
+		d.Changed = append(d.Changed, "test_cases")
+	}
+	return d
+}
+
+// maxDiffCells bounds the work diffLines does. Bigger diffs show every old
+// line removed and every new line added.
+const maxDiffCells = 1000000
+
+// diffLines returns a line by line diff from a to b, based on their longest
+// common subsequence of lines.
+func diffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(x)*len(y) > maxDiffCells {
+		var diff []string
+		for _, l := range x {
+			diff = append(diff, "-"+l)
+		}
+		for _, l := range y {
+			diff = append(diff, "+"+l)
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			diff = append(diff, " "+x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		diff = append(diff, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		diff = append(diff, "+"+y[j])
+	}
+	return diff
+}
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// legacyTypes lists event types in the order version 1 of the protocol
+// numbered them. It must never be reordered; new event types have no number
+// and aren't sent to version 1 clients.
+var legacyTypes = []EventType{
+	UserJoined,
+	UserLeft,
+	TimerChanged,
+	TimerFinished,
+	ChallengeSet,
+	BreakStarted,
+	RunCode,
+	CodeRan,
+	InitialState,
+}
+
+// LegacyCode returns the number version 1 of the protocol used for t.
+func LegacyCode(t EventType) (int, bool) {
+	for i, lt := range legacyTypes {
+		if lt == t {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// DecodeLegacy decodes an event sent by a version 1 client.
+func DecodeLegacy(data []byte) (*Event, error) {
+	var wrapper struct {
+		Type      int             `json:"type"`
+		RequestID string          `json:"request_id"`
+		Body      json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, err
+	}
+	if wrapper.Type < 0 || wrapper.Type >= len(legacyTypes) {
+		return nil, &UnknownEventError{
+			Type: EventType(strconv.Itoa(wrapper.Type)),
+		}
+	}
+	translated, err := json.Marshal(struct {
+		Type      EventType       `json:"type"`
+		RequestID string          `json:"request_id,omitempty"`
+		Body      json.RawMessage `json:"body,omitempty"`
+	}{legacyTypes[wrapper.Type], wrapper.RequestID, wrapper.Body})
+	if err != nil {
+		return nil, err
+	}
+	var e Event
+	if err := json.Unmarshal(translated, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
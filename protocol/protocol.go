@@ -0,0 +1,367 @@
+// Package protocol defines the events exchanged with clients over the game's
+// websocket.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// Version is the newest version of the protocol. Clients pick a version by
+// requesting its subprotocol when they connect. Clients that don't request
+// one get version 1, which identifies events by number.
+const Version = 2
+
+const subprotocolPrefix = "calhacks.v"
+
+// Subprotocols lists the websocket subprotocols the server accepts, in order
+// of preference.
+var Subprotocols = []string{Subprotocol(2), Subprotocol(1)}
+
+// Subprotocol returns the name of the websocket subprotocol for version.
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// ParseSubprotocol returns the protocol version named by a negotiated
+// websocket subprotocol. An empty subprotocol means version 1.
+func ParseSubprotocol(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+	if !strings.HasPrefix(s, subprotocolPrefix) {
+		return 0, fmt.Errorf("unknown subprotocol %q", s)
+	}
+	return strconv.Atoi(strings.TrimPrefix(s, subprotocolPrefix))
+}
+
+type EventType string
+
+const (
+	UserJoined     EventType = "userJoined"
+	UserLeft       EventType = "userLeft"
+	TimerChanged   EventType = "timerChanged"
+	TimerFinished  EventType = "timerFinished"
+	ChallengeSet   EventType = "challengeSet"
+	BreakStarted   EventType = "breakStarted"
+	RunCode        EventType = "runCode"
+	CodeRan        EventType = "codeRan"
+	InitialState   EventType = "initialState"
+	SessionStarted EventType = "sessionStarted"
+	Ack            EventType = "ack"
+	Error          EventType = "error"
+	SendChat       EventType = "sendChat"
+	ChatMessage    EventType = "chatMessage"
+	MuteUser       EventType = "muteUser"
+	UserMuted      EventType = "userMuted"
+	KickUser       EventType = "kickUser"
+	UserKicked     EventType = "userKicked"
+	QueuePosition  EventType = "queuePosition"
+	RunSamples     EventType = "runSamples"
+	SamplesRan     EventType = "samplesRan"
+	SubmitCode     EventType = "submitCode"
+	Rejudged       EventType = "rejudged"
+	JoinQueue      EventType = "joinQueue"
+	LeaveQueue     EventType = "leaveQueue"
+	MatchFound     EventType = "matchFound"
+	NoChallenges   EventType = "noChallenges"
+)
+
+type UserJoinedEvent struct {
+	User *model.User `json:"user"`
+}
+
+type UserLeftEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+type TimerChangedEvent struct {
+	Total     int `json:"total"`
+	Remaining int `json:"remaining"`
+}
+
+type ChallengeSetEvent struct {
+	Challenge *model.Challenge `json:"challenge"`
+}
+
+// RunCodeEvent is the body of SubmitCode events, which submit code for
+// grading against the current challenge's hidden test cases. Code is base64
+// encoded. Older clients send the same body as a RunCode event, which is
+// treated as a submission.
+type RunCodeEvent struct {
+	Code string `json:"code"`
+	Lang string `json:"lang"`
+}
+
+// CodeRanEvent is the result of a submission. Output is what the code printed
+// for the last test case it was run against, and TimedOut whether it ran out
+// of time on it. Tests stop at the first one that fails.
+type CodeRanEvent struct {
+	Output      string `json:"output"`
+	Passed      bool   `json:"passed"`
+	TimedOut    bool   `json:"timed_out,omitempty"`
+	TestsPassed int    `json:"tests_passed"`
+	TestsTotal  int    `json:"tests_total"`
+	Points      int    `json:"points"`
+}
+
+// RunSamplesEvent runs code against the current challenge's sample test
+// cases, or against Input alone if it's set. It's never graded.
+type RunSamplesEvent struct {
+	Code  string  `json:"code"`
+	Lang  string  `json:"lang"`
+	Input *string `json:"input,omitempty"`
+}
+
+// TestResult is the outcome of running code against a single input. Custom
+// inputs have no expected output, and never pass.
+type TestResult struct {
+	TestCaseID     int64  `json:"test_case_id,omitempty"`
+	Input          string `json:"input"`
+	Output         string `json:"output"`
+	ExpectedOutput string `json:"expected_output,omitempty"`
+	Passed         bool   `json:"passed"`
+	TimedOut       bool   `json:"timed_out,omitempty"`
+}
+
+type SamplesRanEvent struct {
+	Results []*TestResult `json:"results"`
+}
+
+type InitialStateEvent struct {
+	CurrentChallenge     *model.Challenge `json:"current_challenge"`
+	CurrentUsers         []*model.User    `json:"current_users"`
+	CurrentTimeRemaining int              `json:"time_remaining"`
+	TotalTime            int              `json:"total_time"`
+
+	// RecentChat is the room's most recent chat messages, oldest first.
+	RecentChat []*ChatMessageEvent `json:"recent_chat"`
+}
+
+type SessionStartedEvent struct {
+	Token           string `json:"token"`
+	Resumed         bool   `json:"resumed"`
+	ProtocolVersion int    `json:"protocol_version"`
+}
+
+// SendChatEvent is sent by a player to chat with the room, or with one other
+// player if To is set.
+type SendChatEvent struct {
+	To   int64  `json:"to,omitempty"`
+	Text string `json:"text"`
+}
+
+type ChatMessageEvent struct {
+	From     int64     `json:"from"`
+	Username string    `json:"username"`
+	To       int64     `json:"to,omitempty"`
+	Text     string    `json:"text"`
+	Sent     time.Time `json:"sent"`
+}
+
+// MuteUserEvent is sent by an admin to stop a player from chatting for a
+// while.
+type MuteUserEvent struct {
+	UserID  int64 `json:"user_id"`
+	Seconds int   `json:"seconds"`
+}
+
+type UserMutedEvent struct {
+	UserID int64     `json:"user_id"`
+	Until  time.Time `json:"until"`
+}
+
+// KickUserEvent is sent by an admin to remove a player from the room and keep
+// them out for a while.
+type KickUserEvent struct {
+	UserID  int64 `json:"user_id"`
+	Seconds int   `json:"seconds"`
+}
+
+type UserKickedEvent struct {
+	UserID int64     `json:"user_id"`
+	Until  time.Time `json:"until"`
+}
+
+// QueuePositionEvent tells a player where a run they asked for is in line.
+// It carries the request ID of the run. Position 1 is next, and 0 means the
+// run has started.
+type QueuePositionEvent struct {
+	Position int `json:"position"`
+}
+
+// RejudgedEvent tells a player that one of their past submissions got a
+// different verdict or score after it was rejudged, like when a challenge's
+// test cases were fixed.
+type RejudgedEvent struct {
+	SubmissionID int64 `json:"submission_id"`
+	ChallengeID  int64 `json:"challenge_id"`
+	RoundID      int64 `json:"round_id"`
+	Passed       bool  `json:"passed"`
+	TestsPassed  int   `json:"tests_passed"`
+	TestsTotal   int   `json:"tests_total"`
+	Points       int   `json:"points"`
+	OldPassed    bool  `json:"old_passed"`
+	OldPoints    int   `json:"old_points"`
+}
+
+// JoinQueueEvent puts a player in the matchmaking queue for a mode, like
+// "duel". Players are matched with others of a similar rating, and with the
+// same Lang and Region if they give them, though both are relaxed the longer
+// they wait. Joining again replaces their place in the queue.
+type JoinQueueEvent struct {
+	Mode   string `json:"mode"`
+	Lang   string `json:"lang,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+// MatchFoundEvent tells a queued player the room they've been matched into,
+// along with everyone else in the match. They join it by connecting again
+// with the room parameter set to Room.
+type MatchFoundEvent struct {
+	Room    string  `json:"room"`
+	Mode    string  `json:"mode"`
+	UserIDs []int64 `json:"user_ids"`
+}
+
+// Error codes sent in ErrorEvents.
+const (
+	ErrUnknownEvent        = "unknown_event"
+	ErrMalformedEvent      = "malformed_event"
+	ErrUnsupportedEvent    = "unsupported_event"
+	ErrUnsupportedLanguage = "unsupported_language"
+	ErrNoActiveRound       = "no_active_round"
+	ErrRateLimited         = "rate_limited"
+	ErrQueueFull           = "queue_full"
+	ErrInternal            = "internal_error"
+	ErrMessageTooLong      = "message_too_long"
+	ErrMessageRejected     = "message_rejected"
+	ErrMuted               = "muted"
+	ErrForbidden           = "forbidden"
+	ErrUserNotFound        = "user_not_found"
+	ErrTooManyRuns         = "too_many_runs"
+	ErrFlooding            = "flooding"
+	ErrNoSamples           = "no_samples"
+	ErrUnknownMode         = "unknown_mode"
+	ErrNotConnected        = "not_connected"
+)
+
+// ErrorEvent tells a client that something it sent couldn't be handled.
+type ErrorEvent struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// bodies maps each event type to a constructor for its body. Event types
+// without a body map to nil.
+var bodies = map[EventType]func() interface{}{
+	UserJoined:     func() interface{} { return new(UserJoinedEvent) },
+	UserLeft:       func() interface{} { return new(UserLeftEvent) },
+	TimerChanged:   func() interface{} { return new(TimerChangedEvent) },
+	TimerFinished:  nil,
+	ChallengeSet:   func() interface{} { return new(ChallengeSetEvent) },
+	BreakStarted:   nil,
+	RunCode:        func() interface{} { return new(RunCodeEvent) },
+	CodeRan:        func() interface{} { return new(CodeRanEvent) },
+	InitialState:   func() interface{} { return new(InitialStateEvent) },
+	SessionStarted: func() interface{} { return new(SessionStartedEvent) },
+	Ack:            nil,
+	Error:          func() interface{} { return new(ErrorEvent) },
+	SendChat:       func() interface{} { return new(SendChatEvent) },
+	ChatMessage:    func() interface{} { return new(ChatMessageEvent) },
+	MuteUser:       func() interface{} { return new(MuteUserEvent) },
+	UserMuted:      func() interface{} { return new(UserMutedEvent) },
+	KickUser:       func() interface{} { return new(KickUserEvent) },
+	UserKicked:     func() interface{} { return new(UserKickedEvent) },
+	QueuePosition:  func() interface{} { return new(QueuePositionEvent) },
+	RunSamples:     func() interface{} { return new(RunSamplesEvent) },
+	SamplesRan:     func() interface{} { return new(SamplesRanEvent) },
+	SubmitCode:     func() interface{} { return new(RunCodeEvent) },
+	Rejudged:       func() interface{} { return new(RejudgedEvent) },
+	JoinQueue:      func() interface{} { return new(JoinQueueEvent) },
+	LeaveQueue:     nil,
+	MatchFound:     func() interface{} { return new(MatchFoundEvent) },
+	NoChallenges:   nil,
+}
+
+// EventTypes returns every known event type.
+func EventTypes() []EventType {
+	types := make([]EventType, 0, len(bodies))
+	for t := range bodies {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Event is a single message sent over the websocket in either direction.
+// Events the server sends as part of a session carry a sequence number.
+//
+// Clients can give the events they send a request ID. The server answers each
+// of them with an Ack or Error event carrying the same ID, and puts the ID on
+// any other events sent as a result, such as CodeRan.
+type Event struct {
+	Seq       int64       `json:"seq,omitempty"`
+	Type      EventType   `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	UserID    int64       `json:"user_id"`
+	Body      interface{} `json:"body,omitempty"`
+}
+
+// RequestID returns the request ID of an encoded event, even if the rest of
+// it can't be decoded.
+func RequestID(data []byte) string {
+	var wrapper struct {
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(data, &wrapper)
+	return wrapper.RequestID
+}
+
+// UnknownEventError is returned when decoding an event of a type that isn't
+// part of the protocol.
+type UnknownEventError struct {
+	Type EventType
+}
+
+func (e *UnknownEventError) Error() string {
+	return fmt.Sprintf("unknown event type %q", e.Type)
+}
+
+// UnmarshalJSON decodes an event, decoding its body into a pointer to the
+// struct for its type.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var wrapper struct {
+		Seq       int64           `json:"seq"`
+		Type      EventType       `json:"type"`
+		RequestID string          `json:"request_id"`
+		UserID    int64           `json:"user_id"`
+		Body      json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return err
+	}
+	newBody, ok := bodies[wrapper.Type]
+	if !ok {
+		return &UnknownEventError{Type: wrapper.Type}
+	}
+	e.Seq = wrapper.Seq
+	e.Type = wrapper.Type
+	e.RequestID = wrapper.RequestID
+	e.UserID = wrapper.UserID
+	e.Body = nil
+	if newBody != nil {
+		body := newBody()
+		if len(wrapper.Body) > 0 {
+			if err := json.Unmarshal(wrapper.Body, body); err != nil {
+				return err
+			}
+		}
+		e.Body = body
+	}
+	return nil
+}
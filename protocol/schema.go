@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema returns a JSON Schema describing every event in the protocol. It's
+// generated from the Go structs for each event body, so it can't drift from
+// what the server actually sends.
+func Schema() map[string]interface{} {
+	types := EventTypes()
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	defs := make(map[string]interface{})
+	var variants []interface{}
+	for _, t := range types {
+		variant := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"seq":        map[string]interface{}{"type": "integer"},
+				"type":       map[string]interface{}{"const": string(t)},
+				"request_id": map[string]interface{}{"type": "string"},
+				"user_id":    map[string]interface{}{"type": "integer"},
+			},
+			"required": []string{"type", "user_id"},
+		}
+		if newBody := bodies[t]; newBody != nil {
+			defs[string(t)] = typeSchema(reflect.TypeOf(newBody()))
+			variant["properties"].(map[string]interface{})["body"] =
+				map[string]interface{}{"$ref": "#/definitions/" + string(t)}
+		}
+		variants = append(variants, variant)
+	}
+
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"title":       "calhacks websocket event",
+		"version":     Version,
+		"oneOf":       variants,
+		"definitions": defs,
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name, omitempty := jsonName(f)
+			if name == "-" {
+				continue
+			}
+			props[name] = typeSchema(f.Type)
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": props,
+			"required":   required,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+func jsonName(f reflect.StructField) (name string, omitempty bool) {
+	tag := f.Tag.Get("json")
+	if tag == "" {
+		return f.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
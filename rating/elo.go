@@ -0,0 +1,89 @@
+// Package rating estimates how strong players are and how hard challenges
+// are from how players have done on them.
+package rating
+
+import (
+	"math"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+const (
+	// initialRating is the rating players and challenges start with.
+	initialRating = 1500
+
+	// playerK and challengeK are how far a single attempt can move a
+	// player's or a challenge's Elo rating. Challenges are attempted far
+	// more often than players attempt challenges, so they move less.
+	playerK    = 32
+	challengeK = 8
+)
+
+// Score is how well an attempt went, from 0 for not solving the challenge to
+// 1 for solving it the moment the round started. Solving it just as the round
+// ended scores 0.5.
+func Score(a *model.Attempt) float64 {
+	if !a.Solved {
+		return 0
+	}
+	if a.RoundSeconds <= 0 {
+		return 1
+	}
+	frac := float64(a.SolveSeconds) / float64(a.RoundSeconds)
+	return 1 - 0.5*math.Max(0, math.Min(1, frac))
+}
+
+// Expected is the score a player with the rating player is expected to get
+// on a challenge with the rating challenge.
+func Expected(player, challenge float64) float64 {
+	return 1 / (1 + math.Pow(10, (challenge-player)/400))
+}
+
+// Elo rates players and challenges against each other, treating every
+// attempt as a game between a player and a challenge that the player wins by
+// solving it quickly.
+type Elo struct {
+	Players    map[int64]float64
+	Challenges map[int64]float64
+
+	// Attempts is how many attempts each challenge's rating is based on.
+	Attempts map[int64]int
+}
+
+func NewElo() *Elo {
+	return &Elo{
+		Players:    make(map[int64]float64),
+		Challenges: make(map[int64]float64),
+		Attempts:   make(map[int64]int),
+	}
+}
+
+// Add updates the ratings of a's player and challenge. Attempts must be added
+// in the order they were made.
+func (e *Elo) Add(a *model.Attempt) {
+	player, ok := e.Players[a.UserID]
+	if !ok {
+		player = initialRating
+	}
+	challenge, ok := e.Challenges[a.ChallengeID]
+	if !ok {
+		challenge = initialRating
+	}
+	d := Score(a) - Expected(player, challenge)
+	e.Players[a.UserID] = player + playerK*d
+	e.Challenges[a.ChallengeID] = challenge - challengeK*d
+	e.Attempts[a.ChallengeID]++
+}
+
+// Difficulty buckets a challenge's Elo rating by the score a player with the
+// initial rating is expected to get on it.
+func Difficulty(challenge float64) string {
+	switch p := Expected(initialRating, challenge); {
+	case p >= 0.6:
+		return model.DifficultyEasy
+	case p >= 0.4:
+		return model.DifficultyMedium
+	default:
+		return model.DifficultyHard
+	}
+}
@@ -0,0 +1,131 @@
+package rating
+
+import (
+	"math"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// Glicko-2 constants, see http://www.glicko.net/glicko/glicko2.pdf.
+const (
+	initialDeviation  = 350
+	initialVolatility = 0.06
+
+	// tau limits how much volatility can change in a single round.
+	tau = 0.5
+
+	// glickoScale converts between the Glicko and Glicko-2 scales.
+	glickoScale = 173.7178
+
+	volatilityEpsilon = 0.000001
+)
+
+// New returns the rating a player starts with, overall if lang is empty.
+func New(userID int64, lang string) *model.Rating {
+	return &model.Rating{
+		UserID:     userID,
+		Lang:       lang,
+		Rating:     initialRating,
+		Deviation:  initialDeviation,
+		Volatility: initialVolatility,
+	}
+}
+
+// Outcome is a player's result against a single opponent: 1 for finishing
+// ahead of them, 0.5 for tying and 0 for finishing behind.
+type Outcome struct {
+	Opponent *model.Rating
+	Score    float64
+}
+
+// Finish is the outcome for a of finishing a round with b. Players who solved
+// the challenge finish in the order they solved it, ahead of everyone who
+// didn't.
+func Finish(a, b *model.Attempt) float64 {
+	switch {
+	case a.Solved && b.Solved:
+		if a.SolveSeconds < b.SolveSeconds {
+			return 1
+		} else if a.SolveSeconds > b.SolveSeconds {
+			return 0
+		}
+		return 0.5
+	case a.Solved:
+		return 1
+	case b.Solved:
+		return 0
+	}
+	return 0.5
+}
+
+// Update returns r after a round with the given outcomes, treating the round
+// as a Glicko-2 rating period. r itself isn't changed.
+func Update(r *model.Rating, outcomes []Outcome) *model.Rating {
+	updated := *r
+	if len(outcomes) == 0 {
+		return &updated
+	}
+
+	mu := (r.Rating - initialRating) / glickoScale
+	phi := r.Deviation / glickoScale
+	var vInv, sum float64
+	for _, o := range outcomes {
+		muJ := (o.Opponent.Rating - initialRating) / glickoScale
+		g := glickoG(o.Opponent.Deviation / glickoScale)
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInv += g * g * e * (1 - e)
+		sum += g * (o.Score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	sigma := newVolatility(phi, r.Volatility, v, delta)
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+
+	updated.Rating = mu*glickoScale + initialRating
+	updated.Deviation = phi * glickoScale
+	updated.Volatility = sigma
+	updated.Rounds++
+	return &updated
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// newVolatility finds a player's new volatility with the Illinois algorithm,
+// step 5 of the Glicko-2 paper.
+func newVolatility(phi, sigma, v, delta float64) float64 {
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(tau*tau)
+	}
+
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*tau) < 0 {
+			k++
+		}
+		B = a - k*tau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > volatilityEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB < 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	return math.Exp(A / 2)
+}
@@ -0,0 +1,58 @@
+package rating
+
+import (
+	"math"
+	"testing"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+func TestUpdate(t *testing.T) {
+	// The example worked through in Glickman's paper, a 1500 player beating
+	// a 1400 and losing to a 1550 and a 1700.
+	example := []Outcome{
+		{Opponent: &model.Rating{Rating: 1400, Deviation: 30}, Score: 1},
+		{Opponent: &model.Rating{Rating: 1550, Deviation: 100}, Score: 0},
+		{Opponent: &model.Rating{Rating: 1700, Deviation: 300}, Score: 0},
+	}
+
+	tests := []struct {
+		name     string
+		r        model.Rating
+		outcomes []Outcome
+		want     model.Rating
+	}{
+		{
+			name: "glickman's example",
+			r: model.Rating{Rating: 1500, Deviation: 200,
+				Volatility: 0.06},
+			outcomes: example,
+			want: model.Rating{Rating: 1464.06, Deviation: 151.52,
+				Volatility: 0.05999, Rounds: 1},
+		},
+		{
+			name: "no outcomes",
+			r: model.Rating{Rating: 1500, Deviation: 200,
+				Volatility: 0.06, Rounds: 3},
+			want: model.Rating{Rating: 1500, Deviation: 200,
+				Volatility: 0.06, Rounds: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		r := tt.r
+		got := Update(&r, tt.outcomes)
+		if math.Abs(got.Rating-tt.want.Rating) > 0.05 ||
+			math.Abs(got.Deviation-tt.want.Deviation) > 0.05 ||
+			math.Abs(got.Volatility-tt.want.Volatility) > 0.00001 ||
+			got.Rounds != tt.want.Rounds {
+			t.Errorf("%s: got %.2f/%.2f/%.5f after %d rounds, want "+
+				"%.2f/%.2f/%.5f after %d", tt.name, got.Rating, got.Deviation,
+				got.Volatility, got.Rounds, tt.want.Rating, tt.want.Deviation,
+				tt.want.Volatility, tt.want.Rounds)
+		}
+		if r != tt.r {
+			t.Errorf("%s: changed the rating it was given", tt.name)
+		}
+	}
+}
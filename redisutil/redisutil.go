@@ -0,0 +1,34 @@
+package redisutil
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/config"
+)
+
+// NewPool returns a pool of connections to the configured Redis server.
+func NewPool() *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", config.RedisServer())
+			if err != nil {
+				return nil, err
+			}
+			pass := config.RedisPassword()
+			if pass != "" {
+				if _, err := c.Do("AUTH", pass); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, err
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
@@ -5,11 +5,45 @@ import "github.com/gorilla/mux"
 func API() *mux.Router {
 	m := mux.NewRouter()
 
+	m.Path("/challenges").Methods("GET").Name(Challenges)
 	m.Path("/challenges").Methods("POST").Name(SubmitChallenge)
 	m.Path("/challenges/current").Methods("GET").Name(CurrentChallenge)
-	m.Path("/challenges/{ID:.+}").Methods("GET").Name(Challenge)
+	m.Path("/challenges/import").Methods("POST").Name(ImportChallenge)
+	m.Path("/challenges/{ID:[0-9]+}").Methods("GET").Name(Challenge)
+	m.Path("/challenges/{ID:[0-9]+}").Methods("PUT").Name(UpdateChallenge)
+	m.Path("/challenges/{ID:[0-9]+}").Methods("DELETE").Name(DeleteChallenge)
+	m.Path("/challenges/{ID:[0-9]+}/status").Methods("PUT").
+		Name(SetChallengeStatus)
+	m.Path("/challenges/{ID:[0-9]+}/reviews").Methods("GET").
+		Name(ChallengeReviews)
+	m.Path("/challenges/{ID:[0-9]+}/reviews").Methods("POST").
+		Name(ReviewChallenge)
+	m.Path("/challenges/{ID:[0-9]+}/export").Methods("GET").
+		Name(ExportChallenge)
+	m.Path("/challenges/{ID:[0-9]+}/versions").Methods("GET").
+		Name(ChallengeVersions)
+	m.Path("/challenges/{ID:[0-9]+}/versions/{Version:[0-9]+}").
+		Methods("GET").Name(ChallengeVersion)
+	m.Path("/challenges/{ID:[0-9]+}/rejudges").Methods("GET").
+		Name(ChallengeRejudges)
+	m.Path("/challenges/{ID:[0-9]+}/rejudges").Methods("POST").
+		Name(RejudgeChallenge)
+
+	m.Path("/challenges/{ID:[0-9]+}/stats").Methods("GET").
+		Name(ChallengeStats)
+
+	m.Path("/rejudges/{ID:[0-9]+}").Methods("GET").Name(Rejudge)
+
+	m.Path("/users/{ID:[0-9]+}/ratings").Methods("GET").Name(UserRatings)
+	m.Path("/users/{ID:[0-9]+}/ratings/history").Methods("GET").
+		Name(UserRatingHistory)
+
+	m.Path("/ratings").Methods("GET").Name(TopRatings)
+
+	m.Path("/rounds/{ID:[0-9]+}/replay").Methods("GET").Name(RoundReplay)
 
 	m.Path("/connect").Methods("GET").Name(WebsocketConnect)
+	m.Path("/protocol/schema").Methods("GET").Name(ProtocolSchema)
 
 	m.Path("/oauth/login").Methods("GET").Name(OauthLogin)
 	m.Path("/oauth/access_token").Methods("GET").Name(OauthAccessToken)
@@ -1,11 +1,34 @@
 package router
 
 const (
-	Challenge        = "challenge"
-	SubmitChallenge  = "challenge:submit"
-	CurrentChallenge = "challenge:current"
+	Challenge          = "challenge"
+	Challenges         = "challenge:list"
+	SubmitChallenge    = "challenge:submit"
+	UpdateChallenge    = "challenge:update"
+	DeleteChallenge    = "challenge:delete"
+	CurrentChallenge   = "challenge:current"
+	SetChallengeStatus = "challenge:status"
+	ChallengeReviews   = "challenge:reviews"
+	ReviewChallenge    = "challenge:review"
+	ImportChallenge    = "challenge:import"
+	ExportChallenge    = "challenge:export"
+	ChallengeVersions  = "challenge:versions"
+	ChallengeVersion   = "challenge:version"
+	ChallengeRejudges  = "challenge:rejudges"
+	RejudgeChallenge   = "challenge:rejudge"
+	ChallengeStats     = "challenge:stats"
+
+	Rejudge = "rejudge"
+
+	UserRatings       = "user:ratings"
+	UserRatingHistory = "user:rating_history"
+
+	TopRatings = "rating:top"
+
+	RoundReplay = "round:replay"
 
 	WebsocketConnect = "websocket:connect"
+	ProtocolSchema   = "protocol:schema"
 
 	OauthLogin       = "oauth:login"
 	OauthAccessToken = "oauth:access_token"
@@ -0,0 +1,251 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// queueTimeout is how long a job can wait for a worker to take it,
+	// beyond the time it can take to run, before it's given up on. It also
+	// covers a worker dying partway through the job and it being queued
+	// again.
+	queueTimeout = 2 * time.Minute
+
+	// resultsTTL is how long a node's results list outlives the node.
+	resultsTTL = 5 * time.Minute
+
+	// languagesTTL is how long the languages workers support are cached.
+	languagesTTL = 5 * time.Second
+)
+
+// Redis keys used by the job protocol. Jobs are pushed onto a single list,
+// and results onto a list named by the job. Workers move each job they take
+// onto their own processing list until it's done, so that the jobs of a
+// worker that dies can be found and queued again.
+const jobsKey = "runner:jobs"
+
+func resultsKey(node string) string {
+	return "runner:results:" + node
+}
+
+func processingKey(worker string) string {
+	return "runner:processing:" + worker
+}
+
+const workersKey = "runner:workers"
+
+func workerKey(id string) string {
+	return "runner:worker:" + id
+}
+
+// reapScript queues the jobs on a worker's processing list in KEYS[2] again,
+// next in line, and forgets the worker, as long as its heartbeat in KEYS[1]
+// has expired. KEYS[3] is the jobs list, KEYS[4] the set of workers and
+// ARGV[1] the worker's ID. It returns how many jobs were queued again.
+var reapScript = redis.NewScript(4, `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local n = 0
+local job = redis.call("LPOP", KEYS[2])
+while job do
+	redis.call("RPUSH", KEYS[3], job)
+	n = n + 1
+	job = redis.call("LPOP", KEYS[2])
+end
+redis.call("SREM", KEYS[4], ARGV[1])
+return n
+`)
+
+// reapWorker queues the jobs of the worker with the given ID again if it has
+// stopped sending heartbeats.
+func reapWorker(c redis.Conn, id string) (int, error) {
+	return redis.Int(reapScript.Do(c, workerKey(id), processingKey(id),
+		jobsKey, workersKey, id))
+}
+
+var ErrTimeout = errors.New("timed out waiting for a worker")
+
+// Remote runs jobs on calhacks-worker processes.
+type Remote struct {
+	pool *redis.Pool
+	node string
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan *Result
+
+	langMu           sync.Mutex
+	languages        map[string]bool
+	languagesFetched time.Time
+}
+
+// NewRemote returns an executor that sends jobs to workers through the Redis
+// in pool. node uniquely identifies this process so workers know where to
+// send results. Only one should be made for each node, since they'd take each
+// other's results.
+func NewRemote(pool *redis.Pool, node string) *Remote {
+	r := &Remote{
+		pool:    pool,
+		node:    node,
+		pending: make(map[string]chan *Result),
+	}
+	go r.listen()
+	go r.reapWorkers()
+	return r
+}
+
+func (r *Remote) Execute(job *Job) (*Result, error) {
+	r.mu.Lock()
+	r.nextID++
+	job.ID = r.node + "-" + strconv.FormatInt(r.nextID, 10)
+	reply := make(chan *Result, 1)
+	r.pending[job.ID] = reply
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, job.ID)
+		r.mu.Unlock()
+	}()
+
+	job.ReplyTo = resultsKey(r.node)
+	timeout := queueTimeout + job.MaxRunTime()
+	job.Deadline = time.Now().Add(timeout)
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	c := r.pool.Get()
+	_, err = c.Do("LPUSH", jobsKey, data)
+	c.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case res := <-reply:
+		if res.Error != "" {
+			return nil, fmt.Errorf("worker %s: %s", res.Worker, res.Error)
+		}
+		return res, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// listen hands results to the jobs waiting for them, reconnecting if the
+// connection to Redis drops.
+func (r *Remote) listen() {
+	for {
+		if err := r.receive(); err != nil {
+			log.Println(err)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (r *Remote) receive() error {
+	c := r.pool.Get()
+	defer c.Close()
+	for {
+		reply, err := redis.ByteSlices(c.Do("BRPOP", resultsKey(r.node), 0))
+		if err != nil {
+			return err
+		}
+		var res Result
+		if err := json.Unmarshal(reply[1], &res); err != nil {
+			log.Println(err)
+			continue
+		}
+		r.mu.Lock()
+		if ch, ok := r.pending[res.JobID]; ok {
+			ch <- &res
+		}
+		r.mu.Unlock()
+	}
+}
+
+// reapWorkers queues the jobs of workers that have died partway through them
+// again, every heartbeatInterval. Every node does this, but each dead
+// worker's jobs are only queued again once.
+func (r *Remote) reapWorkers() {
+	for _ = range time.Tick(heartbeatInterval) {
+		if err := r.reap(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (r *Remote) reap() error {
+	c := r.pool.Get()
+	defer c.Close()
+	ids, err := redis.Strings(c.Do("SMEMBERS", workersKey))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		n, err := reapWorker(c, id)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("worker %s died, queued its %d jobs again", id, n)
+		}
+	}
+	return nil
+}
+
+// Supports reports whether any live worker advertises lang.
+func (r *Remote) Supports(lang string) (bool, error) {
+	r.langMu.Lock()
+	defer r.langMu.Unlock()
+	if time.Since(r.languagesFetched) > languagesTTL {
+		workers, err := Workers(r.pool)
+		if err != nil {
+			return false, err
+		}
+		r.languages = make(map[string]bool)
+		for _, w := range workers {
+			for _, l := range w.Languages {
+				r.languages[l] = true
+			}
+		}
+		r.languagesFetched = time.Now()
+	}
+	return r.languages[lang], nil
+}
+
+// Workers returns the workers that have sent a heartbeat recently. Workers
+// that haven't are left for reapWorkers to forget once their jobs are queued
+// again.
+func Workers(pool *redis.Pool) ([]*WorkerInfo, error) {
+	c := pool.Get()
+	defer c.Close()
+	ids, err := redis.Strings(c.Do("SMEMBERS", workersKey))
+	if err != nil {
+		return nil, err
+	}
+	var workers []*WorkerInfo
+	for _, id := range ids {
+		data, err := redis.Bytes(c.Do("GET", workerKey(id)))
+		if err == redis.ErrNil {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var w WorkerInfo
+		if err := json.Unmarshal(data, &w); err != nil {
+			return nil, err
+		}
+		workers = append(workers, &w)
+	}
+	return workers, nil
+}
@@ -0,0 +1,166 @@
+// Package runner judges code against test cases, either in this process or
+// on calhacks-worker processes that take jobs from Redis.
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zachlatta/calhacks/model"
+	"github.com/zachlatta/calhacks/sandbox"
+)
+
+// Job is code to run against a list of test cases.
+type Job struct {
+	ID      string           `json:"id"`
+	ReplyTo string           `json:"reply_to"`
+	Lang    string           `json:"lang"`
+	Code    []byte           `json:"code"`
+	Tests   []model.TestCase `json:"tests"`
+
+	// Judge is whether to compare the code's output against each test
+	// case's expected output. Code run with a custom input isn't judged.
+	Judge bool `json:"judge"`
+
+	// Checker judges the code's output instead of comparing it with the
+	// expected output, if it's set.
+	Checker *model.Checker `json:"checker,omitempty"`
+
+	// StopOnFailure stops the job at the first test case that fails.
+	StopOnFailure bool `json:"stop_on_failure"`
+
+	// TimeLimit is how long the code may run for each test case. Zero
+	// means DefaultTimeLimit.
+	TimeLimit time.Duration `json:"time_limit"`
+
+	// Deadline is when whoever sent the job stops waiting for its result.
+	// Workers drop jobs they take after it. Zero means no deadline.
+	Deadline time.Time `json:"deadline"`
+}
+
+// DefaultTimeLimit is how long code may run for each test case when a job
+// doesn't say.
+const DefaultTimeLimit = 10 * time.Second
+
+// testOverhead is roughly how long it takes to start the sandbox for each
+// test case, on top of the time the code itself may take.
+const testOverhead = time.Second
+
+func (j *Job) timeLimit() time.Duration {
+	if j.TimeLimit <= 0 {
+		return DefaultTimeLimit
+	}
+	return j.TimeLimit
+}
+
+// MaxRunTime is about the longest the job can take to run, with every test
+// case and the checker run on it using all of their time.
+func (j *Job) MaxRunTime() time.Duration {
+	perTest := j.timeLimit() + testOverhead
+	if j.Judge && j.Checker != nil {
+		perTest += DefaultTimeLimit + testOverhead
+	}
+	return time.Duration(len(j.Tests)) * perTest
+}
+
+// TestResult is what code printed for a single test case, and how long it
+// took. Code that runs out of time fails.
+type TestResult struct {
+	Output   string        `json:"output"`
+	Passed   bool          `json:"passed"`
+	TimedOut bool          `json:"timed_out"`
+	Time     time.Duration `json:"time"`
+}
+
+// Result is the outcome of a job. Results are in the same order as the job's
+// tests, and stop early if the job stopped on a failure. Error is set if the
+// job couldn't be run at all.
+type Result struct {
+	JobID   string        `json:"job_id"`
+	Worker  string        `json:"worker"`
+	Results []*TestResult `json:"results"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Executor runs jobs.
+type Executor interface {
+	Execute(job *Job) (*Result, error)
+
+	// Supports reports whether jobs in lang can be run.
+	Supports(lang string) (bool, error)
+}
+
+// Run runs job in sb.
+func Run(sb *sandbox.Sandbox, job *Job) *Result {
+	res := &Result{JobID: job.ID}
+	timeLimit := job.timeLimit()
+	for _, tc := range job.Tests {
+		out, err := sb.Run(job.Lang, job.Code, tc.Input, timeLimit)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		r := &TestResult{
+			Output:   out.Text,
+			TimedOut: out.TimedOut,
+			Time:     out.Time,
+		}
+		if job.Judge && !out.TimedOut {
+			r.Passed, err = judge(sb, job.Checker, tc, out.Text)
+			if err != nil {
+				res.Error = err.Error()
+				return res
+			}
+		}
+		res.Results = append(res.Results, r)
+		if job.StopOnFailure && !r.Passed {
+			break
+		}
+	}
+	return res
+}
+
+// judge reports whether output is right for tc, using checker if it isn't
+// nil.
+func judge(sb *sandbox.Sandbox, checker *model.Checker, tc model.TestCase,
+	output string) (bool, error) {
+	if checker == nil {
+		return strings.TrimSpace(output) ==
+			strings.TrimSpace(tc.ExpectedOutput), nil
+	}
+	input, err := json.Marshal(map[string]string{
+		"input":           tc.Input,
+		"expected_output": tc.ExpectedOutput,
+		"output":          output,
+	})
+	if err != nil {
+		return false, err
+	}
+	out, err := sb.Run(checker.Lang, []byte(checker.Code), string(input),
+		DefaultTimeLimit)
+	if err != nil {
+		return false, err
+	}
+	if out.TimedOut {
+		return false, fmt.Errorf("checker took longer than %s",
+			DefaultTimeLimit)
+	}
+	verdict := strings.SplitN(strings.TrimSpace(out.Text), "\n", 2)[0]
+	return strings.TrimSpace(verdict) == "ok", nil
+}
+
+// Local runs jobs in this process.
+type Local struct {
+	Sandbox *sandbox.Sandbox
+}
+
+func (l *Local) Execute(job *Job) (*Result, error) {
+	return Run(l.Sandbox, job), nil
+}
+
+func (l *Local) Supports(lang string) (bool, error) {
+	_, ok := sandbox.Languages[lang]
+	return ok, nil
+}
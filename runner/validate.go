@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zachlatta/calhacks/model"
+)
+
+// ValidationError lists what's wrong with a challenge's solutions.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Validate runs each of c's solutions against every one of its test cases.
+// It returns a *ValidationError unless every correct solution passes all of
+// them within c's time limit, and every wrong solution fails at least one.
+func Validate(ex Executor, c *model.Challenge) error {
+	verr := &ValidationError{}
+	for i, s := range c.Solutions {
+		res, err := ex.Execute(&Job{
+			Lang:          s.Lang,
+			Code:          []byte(s.Code),
+			Tests:         c.TestCases,
+			Judge:         true,
+			Checker:       c.Checker,
+			StopOnFailure: true,
+			TimeLimit:     c.TimeLimitDuration(),
+		})
+		if err != nil {
+			return err
+		}
+		failed := -1
+		for j, r := range res.Results {
+			if !r.Passed {
+				failed = j
+				break
+			}
+		}
+
+		switch {
+		case s.Correct && failed >= 0:
+			r := res.Results[failed]
+			why := "gave the wrong output"
+			if r.TimedOut {
+				why = fmt.Sprintf("took longer than %s", c.TimeLimitDuration())
+			}
+			verr.Problems = append(verr.Problems, fmt.Sprintf(
+				"solution %d is marked correct but %s on test case %d", i+1,
+				why, failed+1))
+		case !s.Correct && failed < 0:
+			verr.Problems = append(verr.Problems, fmt.Sprintf(
+				"solution %d is marked wrong but passes every test case", i+1))
+		}
+	}
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package runner
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zachlatta/calhacks/sandbox"
+)
+
+const (
+	// heartbeatInterval is how often workers advertise themselves, and
+	// workerTTL how long they're considered alive after doing so.
+	heartbeatInterval = 3 * time.Second
+	workerTTL         = 10 * time.Second
+
+	// pollTimeout is how long a worker blocks waiting for a job before
+	// checking again.
+	pollTimeout = 5
+
+	// requeueWait is how long a worker waits after putting back a job in a
+	// language it can't run, giving other workers a chance to take it.
+	requeueWait = time.Second
+)
+
+// WorkerInfo is what a worker advertises about itself in its heartbeats.
+type WorkerInfo struct {
+	ID          string    `json:"id"`
+	Languages   []string  `json:"languages"`
+	Concurrency int       `json:"concurrency"`
+	Busy        int       `json:"busy"`
+	Started     time.Time `json:"started"`
+	Heartbeat   time.Time `json:"heartbeat"`
+}
+
+// Worker takes jobs from Redis and runs them in a sandbox.
+type Worker struct {
+	ID          string
+	Concurrency int
+	Languages   []string
+
+	pool    *redis.Pool
+	sandbox *sandbox.Sandbox
+	started time.Time
+
+	mu   sync.Mutex
+	busy int
+}
+
+// NewWorker returns a worker that runs jobs in every language the sandbox
+// supports.
+func NewWorker(id string, concurrency int, pool *redis.Pool,
+	sb *sandbox.Sandbox) *Worker {
+	w := &Worker{
+		ID:          id,
+		Concurrency: concurrency,
+		pool:        pool,
+		sandbox:     sb,
+		started:     time.Now(),
+	}
+	for lang := range sandbox.Languages {
+		w.Languages = append(w.Languages, lang)
+	}
+	return w
+}
+
+// Run runs jobs until the process exits. Jobs left unfinished by the last
+// worker with the same ID are queued again first.
+func (w *Worker) Run() {
+	if err := w.requeueLeftovers(); err != nil {
+		log.Println(err)
+	}
+	go func() {
+		for _ = range time.Tick(heartbeatInterval) {
+			if err := w.heartbeat(); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	if err := w.heartbeat(); err != nil {
+		log.Println(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(w.Concurrency)
+	for i := 0; i < w.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if err := w.work(); err != nil {
+					log.Println(err)
+					time.Sleep(time.Second)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) heartbeat() error {
+	w.mu.Lock()
+	info := WorkerInfo{
+		ID:          w.ID,
+		Languages:   w.Languages,
+		Concurrency: w.Concurrency,
+		Busy:        w.busy,
+		Started:     w.started,
+		Heartbeat:   time.Now(),
+	}
+	w.mu.Unlock()
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+
+	c := w.pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("SET", workerKey(w.ID), data, "EX", int(workerTTL/time.Second))
+	c.Send("SADD", workersKey, w.ID)
+	_, err = c.Do("EXEC")
+	return err
+}
+
+// requeueLeftovers queues the jobs on the worker's processing list again.
+// They were being run by an earlier worker with the same ID that died.
+func (w *Worker) requeueLeftovers() error {
+	c := w.pool.Get()
+	defer c.Close()
+	if _, err := c.Do("DEL", workerKey(w.ID)); err != nil {
+		return err
+	}
+	n, err := reapWorker(c, w.ID)
+	if n > 0 {
+		log.Printf("queued %d jobs left by the last run again", n)
+	}
+	return err
+}
+
+// work waits for a single job and runs it. The job stays on the worker's
+// processing list until its result has been sent.
+func (w *Worker) work() error {
+	c := w.pool.Get()
+	data, err := redis.Bytes(c.Do("BRPOPLPUSH", jobsKey, processingKey(w.ID),
+		pollTimeout))
+	c.Close()
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		w.finish(data)
+		return err
+	}
+	if !job.Deadline.IsZero() && time.Now().After(job.Deadline) {
+		log.Printf("dropped job %s, its deadline passed", job.ID)
+		return w.finish(data)
+	}
+	if !w.supports(job.Lang) {
+		if err := w.putBack(data); err != nil {
+			return err
+		}
+		time.Sleep(requeueWait)
+		return nil
+	}
+
+	w.setBusy(1)
+	res := Run(w.sandbox, &job)
+	w.setBusy(-1)
+	res.Worker = w.ID
+
+	result, err := json.Marshal(res)
+	if err != nil {
+		w.finish(data)
+		return err
+	}
+	c = w.pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("LPUSH", job.ReplyTo, result)
+	c.Send("EXPIRE", job.ReplyTo, int(resultsTTL/time.Second))
+	c.Send("LREM", processingKey(w.ID), 1, data)
+	_, err = c.Do("EXEC")
+	return err
+}
+
+// finish takes a job off the worker's processing list without sending a
+// result.
+func (w *Worker) finish(data []byte) error {
+	c := w.pool.Get()
+	defer c.Close()
+	_, err := c.Do("LREM", processingKey(w.ID), 1, data)
+	return err
+}
+
+// putBack returns a job the worker can't run to the front of the queue.
+func (w *Worker) putBack(data []byte) error {
+	c := w.pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("LREM", processingKey(w.ID), 1, data)
+	c.Send("RPUSH", jobsKey, data)
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (w *Worker) supports(lang string) bool {
+	for _, l := range w.Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Worker) setBusy(delta int) {
+	w.mu.Lock()
+	w.busy += delta
+	w.mu.Unlock()
+}
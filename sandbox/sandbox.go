@@ -0,0 +1,141 @@
+// Package sandbox runs untrusted code in Docker containers.
+package sandbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const imgBase = "zachlatta/calhacks-"
+
+// Language is how code in a language is run: the image to run it in, and the
+// command that runs a file of it.
+type Language struct {
+	Image   string
+	Command string
+}
+
+// Languages are the languages code can be run in, by name.
+var Languages = map[string]Language{
+	"ruby": {Image: imgBase + "ruby", Command: "ruby"},
+}
+
+// DefaultEndpoint is the Docker daemon's socket on the local machine.
+const DefaultEndpoint = "unix:///var/run/docker.sock"
+
+// Sandbox runs code in containers on a single Docker daemon.
+type Sandbox struct {
+	docker *docker.Client
+
+	// Dir is where code and its input are written so containers can read
+	// them. It must be at the same path on the Docker host.
+	Dir string
+}
+
+func New(endpoint string) (*Sandbox, error) {
+	c, err := docker.NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Sandbox{docker: c, Dir: "/tmp/calhacks"}, nil
+}
+
+// Output is what a run of some code printed and how long it took.
+type Output struct {
+	Text     string
+	Time     time.Duration
+	TimedOut bool
+}
+
+// Run runs code written in lang in a new container with input on stdin. The
+// container is killed if it runs for longer than timeLimit. Everything the
+// code wrote to stdout and stderr is returned.
+func (s *Sandbox) Run(lang string, code []byte, input string,
+	timeLimit time.Duration) (*Output, error) {
+	l, ok := Languages[lang]
+	if !ok {
+		return nil, fmt.Errorf("unsupported language %q", lang)
+	}
+	base := filepath.Join(s.Dir, randName())
+	filename := filepath.Join(base, randName())
+	inputFilename := filepath.Join(base, randName())
+
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(base)
+
+	if err := ioutil.WriteFile(filename, code, 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(inputFilename, []byte(input), 0644); err != nil {
+		return nil, err
+	}
+
+	container, err := s.docker.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      l.Image,
+			Entrypoint: []string{"/bin/sh", "-c"},
+			Cmd: []string{fmt.Sprintf("%s %s < %s", l.Command, filename,
+				inputFilename)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer s.docker.RemoveContainer(docker.RemoveContainerOptions{
+		ID:    container.ID,
+		Force: true,
+	})
+
+	if err := s.docker.StartContainer(container.ID, &docker.HostConfig{
+		Binds: []string{fmt.Sprintf("%s:%s", base, base)},
+	}); err != nil {
+		return nil, err
+	}
+	out := &Output{}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.docker.WaitContainer(container.ID)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(timeLimit):
+		out.TimedOut = true
+		s.docker.KillContainer(docker.KillContainerOptions{ID: container.ID})
+		err = <-done
+	}
+	out.Time = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := s.docker.Logs(docker.LogsOptions{
+		Container:    container.ID,
+		OutputStream: &buf,
+		ErrorStream:  &buf,
+		Stdout:       true,
+		Stderr:       true,
+	}); err != nil {
+		return nil, err
+	}
+	out.Text = buf.String()
+	return out, nil
+}
+
+func randName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}